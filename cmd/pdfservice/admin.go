@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/bcc-code/pdf-service/internal/app"
+	"github.com/bcc-code/pdf-service/internal/config"
+)
+
+// newAdminServer serves the operational endpoints on server.admin_address and server.admin_port, apart from
+// the API, so they are not exposed with it. The listener has no authentication of its own: it binds to
+// loopback unless configured otherwise, and must only be reachable from inside the cluster.
+//
+//   - GET /metrics: the Prometheus metrics, when enabled
+//   - /debug/pprof/: the Go runtime profiles
+//   - GET /debug/config: the configuration in effect, redacted, as YAML
+//   - GET /debug/failures: the most recent failed renders, newest first
+func newAdminServer(cfg config.ServerConfig, prometheus *app.PrometheusExporter, svc *app.Service, current func() *config.Config) *http.Server {
+	mux := http.NewServeMux()
+	if prometheus != nil {
+		mux.Handle("GET /metrics", prometheus.Handler())
+	}
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("GET /debug/config", func(w http.ResponseWriter, r *http.Request) {
+		data, err := yaml.Marshal(current().Redacted())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		_, _ = w.Write(data)
+	})
+	mux.Handle("GET /debug/failures", svc.RecentFailuresHandler())
+	return &http.Server{
+		Addr:              net.JoinHostPort(cfg.AdminAddress, strconv.Itoa(cfg.AdminPort)),
+		Handler:           mux,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bcc-code/pdf-service/internal/app"
+)
+
+func TestAdminServerServesDebugEndpoints(t *testing.T) {
+	r := newTestReloader(t, newJWKSServer(t))
+	svc := app.NewService(acceptingValidator{}, newFailingEngines(t), r.active.ServiceConfig(), app.NewMockObservabilityProvider(),
+		app.WithRecentFailures(5))
+	admin := newAdminServer(r.active.Server, nil, svc, r.current)
+	assert.Equal(t, "127.0.0.1:0", admin.Addr)
+
+	assert.Equal(t, http.StatusInternalServerError, postLargeRequest(t, svc))
+
+	rec := getAdmin(admin, "/debug/failures")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var failures []app.FailedRender
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &failures))
+	if assert.Len(t, failures, 1) {
+		assert.Equal(t, "weasyprint", failures[0].Engine)
+		assert.Equal(t, http.StatusInternalServerError, failures[0].Status)
+		assert.Contains(t, failures[0].Error, "not rendering in tests")
+		assert.NotEmpty(t, failures[0].RequestID)
+	}
+
+	rec = getAdmin(admin, "/debug/config")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/yaml", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "max_request_bytes: 104857600")
+
+	assert.Equal(t, http.StatusOK, getAdmin(admin, "/debug/pprof/").Code)
+	assert.Equal(t, http.StatusNotFound, getAdmin(admin, "/metrics").Code)
+}
+
+func getAdmin(admin *http.Server, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	admin.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
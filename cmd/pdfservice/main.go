@@ -2,81 +2,356 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/bcc-code/pdf-service/internal/app"
+	"github.com/bcc-code/pdf-service/internal/config"
 )
 
+const sandboxSelfTestTimeout = 30 * time.Second
+
+// engineVersionTimeout bounds the startup query of an engine's renderer version.
+const engineVersionTimeout = 30 * time.Second
+
 func main() {
-	port := getEnv("PORT", "8080")
-	authority := mustGetEnv("AUTH_AUTHORITY")
-	audience := mustGetEnv("AUTH_AUDIENCE")
-	otelServiceName := strings.TrimSpace(os.Getenv("OTEL_SERVICE_NAME"))
+	app.RunHelper(os.Args[1:])
+
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to YAML configuration file")
+	flag.Parse()
+
+	// run returns instead of exiting so its deferred cleanup (telemetry flush, audit log) always happens.
+	if err := run(*configPath); err != nil {
+		log.Printf("%s", err)
+		os.Exit(1)
+	}
+}
 
-	obs := app.Observability(app.NewMockObservabilityProvider())
+func run(configPath string) error {
+	cfg, err := config.Load(configPath, os.LookupEnv)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
 
-	if otelServiceName != "" {
-		obs = app.NewObservabilityProvider(otelServiceName)
+	var observabilityOptions []app.ObservabilityOption
+	var prometheus *app.PrometheusExporter
+	if cfg.Telemetry.Prometheus {
+		prometheus, err = app.NewPrometheusExporter()
+		if err != nil {
+			return fmt.Errorf("failed to initialize prometheus exporter: %w", err)
+		}
+		observabilityOptions = append(observabilityOptions, app.WithPrometheus(prometheus))
 	}
+
+	if cfg.Telemetry.LogFormat == config.LogFormatJSON {
+		observabilityOptions = append(observabilityOptions, app.WithJSONLogs())
+	}
+
+	obs := newObservability(cfg, observabilityOptions)
 	defer obs.Shutdown()
 	logger := obs.Logger()
 
-	validator, err := app.NewOIDCValidator(context.Background(), authority, audience, defaultRequiredScope, obs)
+	validator, err := app.NewOIDCValidator(context.Background(), cfg.Issuers(), cfg.Auth.Audience, cfg.Auth.RequiredScope, obs)
 	if err != nil {
-		log.Fatalf("failed to initialize authentication: %s", err)
+		return fmt.Errorf("failed to initialize authentication: %w", err)
 	}
 
+	// The HMAC validator is always installed so keys can be added by a reload; without keys it rejects every signature.
+	keySet, err := loadHMACKeySet(cfg.Auth.HMACKeysFile)
+	if err != nil {
+		return fmt.Errorf("failed to initialize request signing: %w", err)
+	}
+	signedRequests, err := app.NewHMACValidator(nil, cfg.Auth.RequiredScope, cfg.Auth.HMACMaxClockSkew)
+	if err != nil {
+		return fmt.Errorf("failed to initialize request signing: %w", err)
+	}
+	signedRequests.SetKeys(keySet)
+	serviceOptions := []app.ServiceOption{
+		app.WithSignedRequests(signedRequests),
+		// The sandbox binds the host's fonts, so fontconfig on the host sees what WeasyPrint sees.
+		app.WithFontLister(&app.FontconfigLister{}),
+	}
+
+	if cfg.Audit.Path != "" {
+		auditLog, err := app.NewAuditLog(cfg.Audit.Path, cfg.Audit.MaxBytes)
+		if err != nil {
+			return fmt.Errorf("failed to open audit log: %w", err)
+		}
+		defer auditLog.Close()
+		serviceOptions = append(serviceOptions, app.WithAuditLog(auditLog))
+	}
+
+	engines, runnerSettings, closeEngines, err := newEngines(cfg, obs)
+	if err != nil {
+		return err
+	}
+	defer closeEngines()
+	if cfg.Render.Shadow.Engine != "" {
+		serviceOptions = append(serviceOptions, app.WithShadowRendering(cfg.ShadowConfig()))
+		logger.Info("shadow rendering enabled", "candidate", cfg.Render.Shadow.Engine, "sample_rate", cfg.Render.Shadow.SampleRate)
+	}
+
+	serviceOptions = append(serviceOptions, app.WithRecentFailures(cfg.Render.RecentFailures))
+
 	svc := app.NewService(
 		validator,
-		app.WeasyprintRunner{BwrapPath: defaultBwrapPath, WeasyprintPath: defaultWeasyprintPath, DefaultStylesheetPath: defaultStylesheetPath},
-		app.Config{
-			MaxRequestBytes: defaultMaxRequestBytes,
-			RequestTimeout:  defaultRequestTimeout,
-		},
+		engines,
+		cfg.ServiceConfig(),
 		obs,
+		serviceOptions...,
 	)
 
+	listenAddress := ":" + strconv.Itoa(cfg.Server.Port)
 	server := &http.Server{
-		Addr:              ":" + port,
+		Addr:              listenAddress,
 		Handler:           svc.Routes(),
-		ReadHeaderTimeout: 10 * time.Second,
-		ReadTimeout:       30 * time.Second,
-		WriteTimeout:      defaultRequestTimeout + 15*time.Second,
-		IdleTimeout:       60 * time.Second,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	var certificates *certificateStore
+	if cfg.TLSEnabled() {
+		certificate, err := loadCertificate(cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to initialize tls: %w", err)
+		}
+		certificates = &certificateStore{}
+		certificates.set(certificate)
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: certificates.getCertificate}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	reloads := &reloader{
+		configPath:     configPath,
+		active:         cfg,
+		svc:            svc,
+		validator:      validator,
+		signedRequests: signedRequests,
+		certificates:   certificates,
+		runnerSettings: runnerSettings,
+		logger:         logger,
 	}
+	go reloads.run(ctx)
 
-	logger.Info("service starting", "listen_address", ":"+port, "authority", authority, "audience", audience)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("server failed: %s", err)
+	serveErr := make(chan error, 2)
+	if cfg.Server.AdminPort != 0 {
+		admin := newAdminServer(cfg.Server, prometheus, svc, reloads.current)
+		defer admin.Close()
+		go func() {
+			logger.Info("admin listener starting", "listen_address", admin.Addr, "prometheus", prometheus != nil)
+			if err := admin.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				serveErr <- fmt.Errorf("admin listener: %w", err)
+			}
+		}()
 	}
+	go func() {
+		logger.Info("service starting", "listen_address", listenAddress, "tls", cfg.TLSEnabled(), "issuers", cfg.Issuers(), "audience", cfg.Auth.Audience)
+		if cfg.TLSEnabled() {
+			serveErr <- server.ListenAndServeTLS("", "")
+		} else {
+			serveErr <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("server failed: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		stop()
+	}
+
+	return shutdown(server, svc, cfg.Server, logger)
 }
 
-const (
-	defaultRequiredScope   = "pdf#create"
-	defaultMaxRequestBytes = int64(104857600)
-	defaultRequestTimeout  = 120 * time.Second
-	defaultBwrapPath       = "bwrap"
-	defaultWeasyprintPath  = "weasyprint"
-	defaultStylesheetPath  = "assets/default.css"
-)
+// newObservability creates the OpenTelemetry provider when a service name is configured, and logs locally
+// otherwise. When the exporters cannot be created the service starts with local logs rather than not at all.
+// A metric reader belongs to a single provider, so only the provider in use is created.
+func newObservability(cfg *config.Config, opts []app.ObservabilityOption) app.Observability {
+	if cfg.Telemetry.ServiceName == "" {
+		return app.NewMockObservabilityProvider(opts...)
+	}
+	provider, err := app.NewObservabilityProvider(cfg.ObservabilityConfig(), opts...)
+	if err == nil {
+		return provider
+	}
+	local := app.NewMockObservabilityProvider(opts...)
+	local.Logger().Error("telemetry exporters unavailable, logging locally", "exporter", cfg.Telemetry.Exporter, "cause", err)
+	return local
+}
 
-func getEnv(name string, fallback string) string {
-	value := strings.TrimSpace(os.Getenv(name))
-	if value == "" {
-		return fallback
+// shutdown fails the health check, cancels queued renders and waits for running renders to finish.
+// Renders still running at the drain deadline are cancelled by closing their connections.
+func shutdown(server *http.Server, svc *app.Service, cfg config.ServerConfig, logger *slog.Logger) error {
+	logger.Info("shutdown started", "shutdown_delay", cfg.ShutdownDelay, "shutdown_timeout", cfg.ShutdownTimeout)
+	svc.Drain()
+	time.Sleep(cfg.ShutdownDelay)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := svc.Shutdown(drainCtx); err != nil {
+		logger.Warn("shadow renders still running at the drain deadline", "cause", err)
 	}
-	return value
+
+	if err := server.Shutdown(drainCtx); err != nil {
+		logger.Warn("drain deadline exceeded, cancelling remaining renders", "cause", err)
+		_ = server.Close()
+		return nil
+	}
+
+	logger.Info("shutdown complete")
+	return nil
 }
 
-func mustGetEnv(name string) string {
-	value := strings.TrimSpace(os.Getenv(name))
-	if value == "" {
-		_, _ = os.Stderr.WriteString("missing required environment variable: " + name + "\n")
-		os.Exit(1)
+// sandboxRunner is a PDFRunner that can verify its own isolation.
+type sandboxRunner interface {
+	app.PDFRunner
+	SelfTest(ctx context.Context) error
+}
+
+// newEngines builds a runner for every configured engine and asks it for its renderer version. It returns
+// the reloadable settings of the local runners by engine name, and a function that stops the engines'
+// worker pools and remote node probes.
+func newEngines(cfg *config.Config, obs app.Observability) (*app.EngineRegistry, map[string]*app.ReloadableSettings, func(), error) {
+	logger := obs.Logger()
+	var cgroups *app.CgroupManager
+	if cfg.Render.Limits.Cgroups {
+		manager, err := app.NewCgroupManager()
+		if err != nil {
+			logger.Warn("per-render cgroups are not available, only rlimits apply", "cause", err)
+		} else {
+			cgroups = manager
+		}
+	}
+
+	var engines []app.Engine
+	runnerSettings := map[string]*app.ReloadableSettings{}
+	var closers []func()
+	closeAll := func() {
+		for _, closeRunner := range closers {
+			closeRunner()
+		}
+	}
+	for _, engineConfig := range cfg.Engines() {
+		settings := app.NewReloadableSettings(cfg.RunnerSettings(engineConfig))
+		runner, closeRunner, err := newRunner(cfg, engineConfig, settings, cgroups, obs)
+		if err != nil {
+			closeAll()
+			return nil, nil, nil, fmt.Errorf("engine %s: %w", engineConfig.Name, err)
+		}
+		if engineConfig.Runner != config.RunnerRemote {
+			runnerSettings[engineConfig.Name] = settings
+		}
+		closers = append(closers, closeRunner)
+		engine := app.Engine{Name: engineConfig.Name, Capabilities: engineConfig.Capabilities, Runner: runner}
+		if reporter, ok := runner.(app.VersionReporter); ok {
+			engine.Version = engineVersion(reporter, engine.Name, logger)
+		}
+		engines = append(engines, engine)
+	}
+
+	registry, err := app.NewEngineRegistry(cfg.Render.DefaultEngine, engines...)
+	if err != nil {
+		closeAll()
+		return nil, nil, nil, err
+	}
+	return registry, runnerSettings, closeAll, nil
+}
+
+// engineVersion returns the renderer version of an engine, or an empty string when it cannot be determined.
+func engineVersion(reporter app.VersionReporter, name string, logger *slog.Logger) string {
+	ctx, cancel := context.WithTimeout(context.Background(), engineVersionTimeout)
+	defer cancel()
+
+	version, err := reporter.Version(ctx)
+	if err != nil {
+		logger.Warn("failed to determine engine version", "engine", name, "cause", err)
+		return ""
+	}
+	logger.Info("render engine ready", "engine", name, "version", version)
+	return version
+}
+
+// newRunner builds the runner of an engine and verifies the isolation of a local sandbox before serving.
+// A local runner reads its default stylesheet and limits from settings. The returned function stops the
+// worker pool or the remote node probes, if they are used.
+func newRunner(cfg *config.Config, engine config.EngineConfig, settings *app.ReloadableSettings, cgroups *app.CgroupManager, obs app.Observability) (app.PDFRunner, func(), error) {
+	logger := obs.Logger().With("engine", engine.Name)
+	switch engine.Runner {
+	case config.RunnerLandlock:
+		runner := cfg.LandlockRunner(engine)
+		runner.Reloadable = settings
+		if err := selfTestSandbox(runner, cfg.Render.RequireSandboxSelfTest, logger); err != nil {
+			return nil, nil, err
+		}
+		return runner, func() {}, nil
+	case config.RunnerRemote:
+		runner, err := newRemoteRunner(engine.Remote, obs)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to initialize remote rendering: %w", err)
+		}
+		logger.Info("rendering on remote nodes", "nodes", engine.Remote.Nodes)
+		return runner, runner.Close, nil
+	}
+
+	runner := cfg.WeasyprintRunner(engine)
+	runner.Reloadable = settings
+	runner.Cgroups = cgroups
+	if err := selfTestSandbox(runner, cfg.Render.RequireSandboxSelfTest, logger); err != nil {
+		return nil, nil, err
+	}
+
+	if engine.Pool.Size == 0 {
+		return runner, func() {}, nil
+	}
+	pool := app.NewPooledRunner(runner, cfg.PoolConfig(engine))
+	logger.Info("weasyprint worker pool started", "size", engine.Pool.Size)
+	return pool, pool.Close, nil
+}
+
+func newRemoteRunner(cfg config.RemoteConfig, obs app.Observability) (*app.RemoteRunner, error) {
+	keySet, err := loadHMACKeySet(cfg.HMACKeysFile)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := keySet.Key(cfg.HMACKeyID)
+	if !ok {
+		return nil, fmt.Errorf("hmac key %q not found in %s", cfg.HMACKeyID, cfg.HMACKeysFile)
+	}
+	return app.NewRemoteRunner(cfg.Nodes, key, cfg.HealthInterval, obs)
+}
+
+// selfTestSandbox verifies render isolation before serving. When the test is not required a failure is only
+// logged; /readyz keeps reporting it.
+func selfTestSandbox(runner sandboxRunner, required bool, logger *slog.Logger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sandboxSelfTestTimeout)
+	defer cancel()
+
+	err := runner.SelfTest(ctx)
+	if err == nil {
+		logger.Info("sandbox self-test passed")
+		return nil
+	}
+	if required {
+		return fmt.Errorf("sandbox self-test failed: %w", err)
 	}
-	return value
+	logger.Warn("sandbox self-test failed, continuing because render.require_sandbox_self_test is disabled", "cause", err)
+	return nil
 }
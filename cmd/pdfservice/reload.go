@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/bcc-code/pdf-service/internal/app"
+	"github.com/bcc-code/pdf-service/internal/config"
+)
+
+const reloadDebounce = 500 * time.Millisecond
+
+// reloader re-reads the configuration on SIGHUP or when a watched file changes. Every component is
+// prepared before any is replaced, so an invalid configuration leaves the running one untouched.
+type reloader struct {
+	configPath     string
+	svc            *app.Service
+	validator      *app.OIDCValidator
+	signedRequests *app.HMACValidator
+	certificates   *certificateStore
+	// runnerSettings are the reloadable settings of the local runners by engine name.
+	runnerSettings map[string]*app.ReloadableSettings
+	logger         *slog.Logger
+
+	// active is only replaced by reload; mu guards it for readers on other goroutines.
+	mu     sync.Mutex
+	active *config.Config
+}
+
+// current returns the configuration in effect.
+func (r *reloader) current() *config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.active
+}
+
+func (r *reloader) reload(ctx context.Context) error {
+	next, err := config.Load(r.configPath, os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	if next.Render.RequestTimeout >= r.active.Server.WriteTimeout {
+		return fmt.Errorf("render.request_timeout (%s) must stay below the running server.write_timeout (%s)", next.Render.RequestTimeout, r.active.Server.WriteTimeout)
+	}
+
+	keySet, err := loadHMACKeySet(next.Auth.HMACKeysFile)
+	if err != nil {
+		return err
+	}
+
+	trusted, err := r.validator.FetchIssuers(ctx, next.Issuers())
+	if err != nil {
+		return err
+	}
+
+	var certificate *tls.Certificate
+	if r.certificates != nil && next.TLSEnabled() {
+		certificate, err = loadCertificate(next.Server.TLSCertFile, next.Server.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	runnerSettings := map[*app.ReloadableSettings]app.RunnerSettings{}
+	for _, engine := range next.Engines() {
+		reloadable, ok := r.runnerSettings[engine.Name]
+		if !ok {
+			continue
+		}
+		settings := next.RunnerSettings(engine)
+		if _, err := os.Stat(settings.DefaultStylesheetPath); err != nil {
+			return fmt.Errorf("engine %s: default stylesheet: %w", engine.Name, err)
+		}
+		runnerSettings[reloadable] = settings
+	}
+
+	for _, setting := range config.RestartRequired(r.active, next) {
+		r.logger.WarnContext(ctx, "configuration change requires a restart to take effect", "setting", setting)
+	}
+
+	r.svc.SetConfig(next.ServiceConfig())
+	r.validator.SetIssuers(trusted)
+	r.signedRequests.SetKeys(keySet)
+	for reloadable, settings := range runnerSettings {
+		reloadable.Store(settings)
+	}
+	if certificate != nil {
+		r.certificates.set(certificate)
+	}
+	r.mu.Lock()
+	r.active = next
+	r.mu.Unlock()
+
+	r.logger.InfoContext(ctx, "configuration reloaded",
+		"issuers", trusted.Issuers(),
+		"hmac_keys", keySet.Len(),
+		"max_request_bytes", next.Render.MaxRequestBytes,
+		"request_timeout", next.Render.RequestTimeout)
+	return nil
+}
+
+// run reloads on SIGHUP and on changes to the config, HMAC keys and TLS files until ctx is done.
+func (r *reloader) run(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var fileEvents <-chan fsnotify.Event
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		r.logger.WarnContext(ctx, "file watching disabled, reload with SIGHUP", "cause", err)
+	} else {
+		defer watcher.Close()
+		fileEvents = watcher.Events
+		r.watchDirectories(ctx, watcher)
+	}
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			r.reloadAndLog(ctx, "signal", watcher)
+		case event := <-fileEvents:
+			if r.isWatched(event.Name) {
+				debounce = time.After(reloadDebounce)
+			}
+		case <-debounce:
+			debounce = nil
+			r.reloadAndLog(ctx, "file change", watcher)
+		}
+	}
+}
+
+// reloadAndLog reloads and, when that succeeds, moves watcher to the files of the new configuration.
+func (r *reloader) reloadAndLog(ctx context.Context, trigger string, watcher *fsnotify.Watcher) {
+	if err := r.reload(ctx); err != nil {
+		r.logger.ErrorContext(ctx, "configuration reload rejected, keeping previous configuration", "trigger", trigger, "cause", err)
+		return
+	}
+	if watcher != nil {
+		r.watchDirectories(ctx, watcher)
+	}
+}
+
+func (r *reloader) watchedFiles() []string {
+	files := []string{r.configPath, r.active.Auth.HMACKeysFile, r.active.Server.TLSCertFile, r.active.Server.TLSKeyFile}
+	var watched []string
+	for _, file := range files {
+		if file != "" {
+			watched = append(watched, filepath.Clean(file))
+		}
+	}
+	return watched
+}
+
+func (r *reloader) isWatched(name string) bool {
+	name = filepath.Clean(name)
+	for _, file := range r.watchedFiles() {
+		// Kubernetes updates mounted files by swapping a "..data" symlink in the same directory.
+		if name == file || filepath.Dir(name) == filepath.Dir(file) && filepath.Base(name) == "..data" {
+			return true
+		}
+	}
+	return false
+}
+
+// watchDirectories subscribes watcher to the directories of the watched files, so atomic renames are
+// noticed, and unsubscribes it from directories no longer needed. It runs again after every reload, since
+// the new configuration can move the HMAC keys or the TLS files.
+func (r *reloader) watchDirectories(ctx context.Context, watcher *fsnotify.Watcher) {
+	wanted := map[string]bool{}
+	for _, file := range r.watchedFiles() {
+		wanted[filepath.Dir(file)] = true
+	}
+	for _, dir := range watcher.WatchList() {
+		if !wanted[dir] {
+			_ = watcher.Remove(dir)
+		}
+	}
+	var errs []error
+	for dir := range wanted {
+		if err := watcher.Add(dir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		r.logger.WarnContext(ctx, "file watching incomplete, reload with SIGHUP after changing the unwatched files", "cause", err)
+	}
+}
+
+func loadHMACKeySet(path string) (*app.HMACKeySet, error) {
+	if path == "" {
+		return app.NewHMACKeySet(nil)
+	}
+	keys, err := app.ReadHMACKeysFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hmac keys: %w", err)
+	}
+	return app.NewHMACKeySet(keys)
+}
+
+// certificateStore serves the current TLS certificate and allows it to be replaced at runtime.
+type certificateStore struct {
+	certificate atomic.Pointer[tls.Certificate]
+}
+
+func (s *certificateStore) set(certificate *tls.Certificate) {
+	s.certificate.Store(certificate)
+}
+
+func (s *certificateStore) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.certificate.Load(), nil
+}
+
+func loadCertificate(certFile string, keyFile string) (*tls.Certificate, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tls certificate: %w", err)
+	}
+	return &certificate, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bcc-code/pdf-service/internal/app"
+	"github.com/bcc-code/pdf-service/internal/config"
+)
+
+func TestReloadAppliesValidConfig(t *testing.T) {
+	issuer := newJWKSServer(t)
+	r := newTestReloader(t, issuer)
+
+	writeFile(t, r.configPath, configYAML(issuer, 1024, ""))
+
+	assert.NoError(t, r.reload(context.Background()))
+	assert.Equal(t, int64(1024), r.active.Render.MaxRequestBytes)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, postLargeRequest(t, r.svc))
+}
+
+func TestReloadKeepsPreviousConfigWhenInvalid(t *testing.T) {
+	issuer := newJWKSServer(t)
+	r := newTestReloader(t, issuer)
+	previous := r.active
+
+	writeFile(t, r.configPath, configYAML(issuer, -1, ""))
+	assert.Error(t, r.reload(context.Background()))
+
+	writeFile(t, r.configPath, configYAML(issuer, 1024, "/does/not/exist.json"))
+	assert.Error(t, r.reload(context.Background()))
+
+	writeFile(t, r.configPath, configYAML("http://127.0.0.1:1", 1024, ""))
+	assert.Error(t, r.reload(context.Background()))
+
+	assert.Same(t, previous, r.active)
+	assert.NotEqual(t, http.StatusRequestEntityTooLarge, postLargeRequest(t, r.svc))
+}
+
+func TestReloadMovesFileWatches(t *testing.T) {
+	issuer := newJWKSServer(t)
+	r := newTestReloader(t, issuer)
+	watcher, err := fsnotify.NewWatcher()
+	assert.NoError(t, err)
+	defer watcher.Close()
+	r.watchDirectories(context.Background(), watcher)
+	assert.ElementsMatch(t, []string{filepath.Dir(r.configPath)}, watcher.WatchList())
+
+	keysFile := filepath.Join(t.TempDir(), "hmac-keys.json")
+	writeFile(t, keysFile, "[]")
+	writeFile(t, r.configPath, configYAML(issuer, 1024, keysFile))
+	r.reloadAndLog(context.Background(), "test", watcher)
+	assert.ElementsMatch(t, []string{filepath.Dir(r.configPath), filepath.Dir(keysFile)}, watcher.WatchList())
+
+	writeFile(t, r.configPath, configYAML(issuer, 1024, ""))
+	r.reloadAndLog(context.Background(), "test", watcher)
+	assert.ElementsMatch(t, []string{filepath.Dir(r.configPath)}, watcher.WatchList())
+}
+
+func TestReloadAppliesRunnerSettings(t *testing.T) {
+	issuer := newJWKSServer(t)
+	r := newTestReloader(t, issuer)
+	engine := r.active.Engines()[0]
+	settings := app.NewReloadableSettings(r.active.RunnerSettings(engine))
+	r.runnerSettings = map[string]*app.ReloadableSettings{engine.Name: settings}
+	previous := settings.Load()
+
+	writeFile(t, r.configPath, configYAML(issuer, 1024, "")+"  default_stylesheet_path: /does/not/exist.css\n")
+	assert.Error(t, r.reload(context.Background()))
+	assert.Equal(t, previous, settings.Load())
+
+	stylesheet := filepath.Join(t.TempDir(), "default.css")
+	writeFile(t, stylesheet, "body { margin: 0 }")
+	writeFile(t, r.configPath, configYAML(issuer, 1024, "")+
+		fmt.Sprintf("  default_stylesheet_path: %q\n  limits:\n    memory_bytes: 1073741824\n", stylesheet))
+	assert.NoError(t, r.reload(context.Background()))
+	assert.Equal(t, stylesheet, settings.Load().DefaultStylesheetPath)
+	assert.Equal(t, int64(1<<30), settings.Load().Limits.MemoryBytes)
+}
+
+func newTestReloader(t *testing.T, issuer string) *reloader {
+	t.Helper()
+	t.Setenv("AUTH_AUTHORITY", "")
+	t.Setenv("AUTH_AUDIENCE", "")
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile(t, configPath, configYAML(issuer, 104857600, ""))
+	cfg, err := config.Load(configPath, os.LookupEnv)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	obs := app.NewMockObservabilityProvider()
+	validator, err := app.NewOIDCValidator(context.Background(), cfg.Issuers(), cfg.Auth.Audience, cfg.Auth.RequiredScope, obs)
+	if err != nil {
+		t.Fatalf("failed to create validator: %v", err)
+	}
+	signedRequests, err := app.NewHMACValidator(nil, cfg.Auth.RequiredScope, cfg.Auth.HMACMaxClockSkew)
+	if err != nil {
+		t.Fatalf("failed to create hmac validator: %v", err)
+	}
+
+	return &reloader{
+		configPath:     configPath,
+		active:         cfg,
+		svc:            app.NewService(acceptingValidator{}, newFailingEngines(t), cfg.ServiceConfig(), obs),
+		validator:      validator,
+		signedRequests: signedRequests,
+		logger:         slog.New(slog.DiscardHandler),
+	}
+}
+
+func configYAML(issuer string, maxRequestBytes int64, hmacKeysFile string) string {
+	return fmt.Sprintf("auth:\n  authority: %s\n  audience: api\n  hmac_keys_file: %q\nrender:\n  max_request_bytes: %d\n",
+		issuer, hmacKeysFile, maxRequestBytes)
+}
+
+func postLargeRequest(t *testing.T, svc *app.Service) int {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("html", "index.html")
+	_, _ = part.Write(bytes.Repeat([]byte("x"), 4096))
+	_ = writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/pdf", body)
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rec := httptest.NewRecorder()
+	svc.Routes().ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func newJWKSServer(t *testing.T) string {
+	t.Helper()
+	rawKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	key, err := jwk.FromRaw(rawKey.Public())
+	if err != nil {
+		t.Fatalf("failed to create jwk: %v", err)
+	}
+	keySet := jwk.NewSet()
+	_ = keySet.AddKey(key)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(keySet)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+type acceptingValidator struct{}
+
+func (acceptingValidator) Validate(context.Context, string) (*app.Principal, error) {
+	return &app.Principal{Subject: "test", Scopes: []string{"pdf#create"}}, nil
+}
+
+func newFailingEngines(t *testing.T) *app.EngineRegistry {
+	t.Helper()
+	engines, err := app.NewEngineRegistry("weasyprint", app.Engine{Name: "weasyprint", Runner: failingRunner{}})
+	if err != nil {
+		t.Fatalf("failed to create engines: %v", err)
+	}
+	return engines
+}
+
+type failingRunner struct{}
+
+func (failingRunner) GeneratePDF(context.Context, app.RenderRequest, io.Writer) (app.RenderResult, error) {
+	return app.RenderResult{}, errors.New("not rendering in tests")
+}
@@ -0,0 +1,179 @@
+package app
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Headers in which trusted proxies report the client address, for Config.ForwardedHeader.
+const (
+	ForwardedHeaderXForwardedFor = "x-forwarded-for"
+	ForwardedHeaderForwarded     = "forwarded"
+)
+
+// probeRoutes are polled by load balancers and orchestrators, so their access log records are debug level.
+var probeRoutes = map[string]bool{"GET /livez": true, "GET /readyz": true, "GET /healthcheck": true}
+
+// ParseTrustedProxy parses a trusted proxy given as a CIDR prefix or a single address.
+func ParseTrustedProxy(proxy string) (netip.Prefix, error) {
+	if !strings.Contains(proxy, "/") {
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(proxy)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// accessEntry collects the request details that are only known inside the handler.
+type accessEntry struct {
+	principal *Principal
+}
+
+type accessEntryContextKey struct{}
+
+func accessEntryFromContext(ctx context.Context) *accessEntry {
+	entry, _ := ctx.Value(accessEntryContextKey{}).(*accessEntry)
+	return entry
+}
+
+// logAccess logs every request once it is served. The request's RemoteAddr is replaced by the client
+// address, as reported by trusted proxies, so the handlers, the error log and the audit log see the client
+// rather than the load balancer.
+func (s *Service) logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &accessEntry{}
+		peer := r.RemoteAddr
+		r = r.WithContext(context.WithValue(r.Context(), accessEntryContextKey{}, entry))
+		config := s.currentConfig()
+		r.RemoteAddr = clientAddr(r, config.TrustedProxies, config.ForwardedHeader)
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r)
+
+		// ServeMux sets the pattern of the route it matched on r; it is empty for unmatched requests.
+		attributes := []any{
+			"method", r.Method,
+			"route", r.Pattern,
+			"path", r.URL.Path,
+			"status", recorder.Status(),
+			"bytes_in", body.n,
+			"bytes_out", recorder.Written(),
+			"duration_ms", time.Since(start).Milliseconds(),
+			"remote_addr", r.RemoteAddr,
+		}
+		if r.RemoteAddr != peer {
+			attributes = append(attributes, "peer_addr", peer)
+		}
+		if entry.principal != nil {
+			attributes = append(attributes, "subject", entry.principal.Subject, "client_id", entry.principal.ClientID)
+		}
+		level := slog.LevelInfo
+		if probeRoutes[r.Pattern] {
+			level = slog.LevelDebug
+		}
+		s.obs.Logger().Log(r.Context(), level, "request served", attributes...)
+	})
+}
+
+// clientAddr returns the address of the client that sent r. Unless the direct peer is a trusted proxy that
+// is the peer. Otherwise the hops listed in header, which the trusted proxies write, are walked from the
+// nearest one, and the first that is not a trusted proxy is the client. The other header is ignored: proxies
+// pass it through as the client sent it. A hop that is not an address, such as "unknown" or an obfuscated
+// identifier, ends the walk at the proxy that reported it.
+func clientAddr(r *http.Request, trusted []netip.Prefix, header string) string {
+	peer, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil || !isTrustedProxy(peer.Addr(), trusted) {
+		return r.RemoteAddr
+	}
+
+	hops := forwardedFor(r.Header, header)
+	if len(hops) == 0 {
+		return r.RemoteAddr
+	}
+	client := peer.Addr().Unmap()
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseForwardedAddr(hops[i])
+		if !ok {
+			break
+		}
+		client = addr
+		if !isTrustedProxy(addr, trusted) {
+			break
+		}
+	}
+	return client.String()
+}
+
+func isTrustedProxy(addr netip.Addr, trusted []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the for= parameters of the Forwarded header (RFC 7239), or the entries of
+// X-Forwarded-For, from the client to the nearest proxy.
+func forwardedFor(header http.Header, name string) []string {
+	var hops []string
+	if name == ForwardedHeaderForwarded {
+		for _, value := range header.Values("Forwarded") {
+			for element := range strings.SplitSeq(value, ",") {
+				for pair := range strings.SplitSeq(element, ";") {
+					key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+					if strings.EqualFold(key, "for") {
+						hops = append(hops, strings.Trim(value, `"`))
+					}
+				}
+			}
+		}
+		return hops
+	}
+	for _, value := range header.Values("X-Forwarded-For") {
+		for hop := range strings.SplitSeq(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseForwardedAddr parses a hop with an optional port, such as 192.0.2.60, 192.0.2.60:4711 or
+// [2001:db8::17]:4711.
+func parseForwardedAddr(hop string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// countingReader counts the bytes of a request body the handler read.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
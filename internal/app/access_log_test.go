@@ -0,0 +1,130 @@
+package app
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientAddr(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")}
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers map[string]string
+		want    string
+	}{
+		{name: "untrusted peer", peer: "198.51.100.4:5000", headers: map[string]string{"X-Forwarded-For": "203.0.113.7"}, want: "198.51.100.4:5000"},
+		{name: "trusted peer without headers", peer: "10.0.0.1:5000", want: "10.0.0.1:5000"},
+		{name: "x-forwarded-for", peer: "10.0.0.1:5000", headers: map[string]string{"X-Forwarded-For": "192.0.2.1, 203.0.113.7, 10.0.0.2"}, want: "203.0.113.7"},
+		{name: "only trusted hops", peer: "10.0.0.1:5000", headers: map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{name: "client forwarded header is ignored", peer: "10.0.0.1:5000", headers: map[string]string{
+			"Forwarded":       "for=1.2.3.4",
+			"X-Forwarded-For": "203.0.113.7",
+		}, want: "203.0.113.7"},
+		{name: "unknown hop ends at its proxy", peer: "10.0.0.1:5000", headers: map[string]string{"X-Forwarded-For": "203.0.113.7, unknown, 10.0.0.2"}, want: "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/engines", nil)
+			req.RemoteAddr = tt.peer
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			assert.Equal(t, tt.want, clientAddr(req, trusted, ForwardedHeaderXForwardedFor))
+		})
+	}
+}
+
+func TestClientAddrFromForwarded(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")}
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers map[string]string
+		want    string
+	}{
+		{name: "forwarded", peer: "10.0.0.1:5000", headers: map[string]string{
+			"Forwarded": `for=192.0.2.60;proto=https, for="[2001:db8:cafe::17]:4711"`,
+		}, want: "192.0.2.60"},
+		{name: "client x-forwarded-for is ignored", peer: "10.0.0.1:5000", headers: map[string]string{
+			"Forwarded":       "for=192.0.2.60",
+			"X-Forwarded-For": "1.2.3.4",
+		}, want: "192.0.2.60"},
+		{name: "ipv6 client", peer: "[2001:db8::1]:5000", headers: map[string]string{"Forwarded": `For="[2001:db9::17]:4711"`}, want: "2001:db9::17"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/engines", nil)
+			req.RemoteAddr = tt.peer
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			assert.Equal(t, tt.want, clientAddr(req, trusted, ForwardedHeaderForwarded))
+		})
+	}
+}
+
+func TestAccessLogRecordsEveryRequest(t *testing.T) {
+	var logs strings.Builder
+	obs := loggingObservability{NewMockObservabilityProvider(), newRequestIDLogger(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))}
+	svc := newTestService(fakeValidator{}, &fakeRunner{output: []byte("%PDF-1.7")}, withTestObservability(obs),
+		withTestConfig(func(config *Config) { config.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")} }))
+
+	req := newMultipartRequest(t, "/pdf", []testPart{testHTMLPart})
+	size := req.ContentLength
+	req.RemoteAddr = "10.0.0.1:5000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	svc.Routes().ServeHTTP(httptest.NewRecorder(), req)
+	svc.Routes().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	var records []map[string]any
+	for line := range strings.Lines(logs.String()) {
+		var record map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+		if record["msg"] == "request served" {
+			records = append(records, record)
+		}
+	}
+	if !assert.Len(t, records, 2) {
+		return
+	}
+	render := records[0]
+	assert.Equal(t, "INFO", render["level"])
+	assert.Equal(t, "POST /pdf", render["route"])
+	assert.Equal(t, float64(http.StatusOK), render["status"])
+	assert.Equal(t, float64(size), render["bytes_in"])
+	assert.Equal(t, float64(len("%PDF-1.7")), render["bytes_out"])
+	assert.Equal(t, "203.0.113.7", render["remote_addr"])
+	assert.Equal(t, "10.0.0.1:5000", render["peer_addr"])
+	assert.Equal(t, "test-subject", render["subject"])
+	assert.Equal(t, "test-client", render["client_id"])
+	assert.NotEmpty(t, render["request_id"])
+
+	missing := records[1]
+	assert.Equal(t, "", missing["route"])
+	assert.Equal(t, "/missing", missing["path"])
+	assert.Equal(t, float64(http.StatusNotFound), missing["status"])
+	assert.NotContains(t, missing, "peer_addr")
+	assert.NotContains(t, missing, "subject")
+}
+
+// loggingObservability writes the service's logs to logger.
+type loggingObservability struct {
+	*MockObservabilityProvider
+	logger *slog.Logger
+}
+
+func (o loggingObservability) Logger() *slog.Logger {
+	return o.logger
+}
@@ -0,0 +1,274 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditRecord is one line of the render audit log.
+type AuditRecord struct {
+	Time         time.Time    `json:"time"`
+	RequestID    string       `json:"request_id,omitempty"`
+	Method       string       `json:"method"`
+	Path         string       `json:"path"`
+	RemoteAddr   string       `json:"remote_addr"`
+	Subject      string       `json:"subject,omitempty"`
+	ClientID     string       `json:"client_id,omitempty"`
+	AuthMethod   string       `json:"auth_method,omitempty"`
+	Engine       string       `json:"engine,omitempty"`
+	Inputs       []AuditInput `json:"inputs,omitempty"`
+	InputBytes   int64        `json:"input_bytes"`
+	OutputBytes  int64        `json:"output_bytes"`
+	OutputSHA256 string       `json:"output_sha256,omitempty"`
+	PageCount    int          `json:"page_count"`
+	DurationMS   int64        `json:"duration_ms"`
+	// RenderMS, CPUUserMS, CPUSystemMS and MaxRSSBytes are the resources the render itself used.
+	RenderMS    int64  `json:"render_ms,omitempty"`
+	CPUUserMS   int64  `json:"cpu_user_ms,omitempty"`
+	CPUSystemMS int64  `json:"cpu_system_ms,omitempty"`
+	MaxRSSBytes int64  `json:"max_rss_bytes,omitempty"`
+	Status      int    `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+type AuditInput struct {
+	FormName string `json:"form_name"`
+	Filename string `json:"filename"`
+	Role     string `json:"role"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+}
+
+type AuditSink interface {
+	Record(record AuditRecord) error
+}
+
+// AuditLog is an append-only JSONL file that is rotated when it exceeds maxBytes or the UTC day changes.
+// Rotated files keep the original path with a timestamp suffix. A failed rotation keeps appending to path,
+// and a file that cannot be reopened is retried on the next Record.
+type AuditLog struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	file     *os.File
+	closed   bool
+	size     int64
+	day      string
+	now      func() time.Time
+	rename   func(oldpath, newpath string) error
+}
+
+func NewAuditLog(path string, maxBytes int64) (*AuditLog, error) {
+	if path == "" {
+		return nil, errors.New("audit log path is required")
+	}
+
+	l := &AuditLog{path: path, maxBytes: maxBytes, now: time.Now, rename: os.Rename}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *AuditLog) Record(record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return errors.New("audit log is closed")
+	}
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+
+	var rotateErr error
+	if l.shouldRotate(int64(len(line))) {
+		rotateErr = l.rotate()
+		if l.file == nil {
+			return rotateErr
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return errors.Join(rotateErr, err)
+}
+
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func (l *AuditLog) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	l.file = file
+	l.size = stat.Size()
+	l.day = auditDay(l.now())
+	if l.size > 0 {
+		l.day = auditDay(stat.ModTime())
+	}
+	return nil
+}
+
+func (l *AuditLog) shouldRotate(pending int64) bool {
+	if l.size == 0 {
+		return false
+	}
+	if l.maxBytes > 0 && l.size+pending > l.maxBytes {
+		return true
+	}
+	return auditDay(l.now()) != l.day
+}
+
+// rotate leaves l.file nil only when path cannot be reopened.
+func (l *AuditLog) rotate() error {
+	err := l.file.Close()
+	l.file = nil
+	if err == nil {
+		rotatedPath := l.path + "." + l.now().UTC().Format("20060102T150405.000000000Z")
+		err = l.rename(l.path, rotatedPath)
+	}
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to rotate audit log: %w", err), l.open())
+	}
+	return l.open()
+}
+
+func auditDay(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+// renderAudit collects the request details that are only known inside the handler.
+type renderAudit struct {
+	principal *Principal
+	summary   *renderSummary
+	err       error
+}
+
+type renderAuditContextKey struct{}
+
+func renderAuditFromContext(ctx context.Context) *renderAudit {
+	audit, _ := ctx.Value(renderAuditContextKey{}).(*renderAudit)
+	return audit
+}
+
+// auditRender writes an AuditRecord for every request passing through next, including rejected ones.
+func (s *Service) auditRender(next http.Handler) http.Handler {
+	if s.audit == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		audit := &renderAudit{}
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), renderAuditContextKey{}, audit)))
+
+		record := AuditRecord{
+			Time:       start.UTC(),
+			RequestID:  RequestIDFromContext(r.Context()),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			RemoteAddr: r.RemoteAddr,
+			DurationMS: time.Since(start).Milliseconds(),
+			Status:     recorder.Status(),
+		}
+		if audit.principal != nil {
+			record.Subject = audit.principal.Subject
+			record.ClientID = audit.principal.ClientID
+			record.AuthMethod = audit.principal.Method
+		}
+		if audit.summary != nil {
+			for _, part := range audit.summary.Parts {
+				record.Inputs = append(record.Inputs, AuditInput(part))
+				record.InputBytes += part.Size
+			}
+			record.Engine = audit.summary.Engine
+			record.OutputBytes = audit.summary.OutputBytes
+			record.OutputSHA256 = audit.summary.OutputSHA256
+			record.PageCount = audit.summary.PageCount
+			record.RenderMS = audit.summary.Usage.WallTime.Milliseconds()
+			record.CPUUserMS = audit.summary.Usage.UserCPU.Milliseconds()
+			record.CPUSystemMS = audit.summary.Usage.SystemCPU.Milliseconds()
+			record.MaxRSSBytes = audit.summary.Usage.MaxRSSBytes
+		}
+		if audit.err != nil {
+			record.Error = audit.err.Error()
+		}
+
+		if err := s.audit.Record(record); err != nil {
+			s.obs.Logger().ErrorContext(r.Context(), "failed to write audit record", "cause", err)
+		}
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.written += int64(n)
+	return n, err
+}
+
+func (r *statusRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// Written is the number of body bytes written.
+func (r *statusRecorder) Written() int64 {
+	return r.written
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,182 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditRecordsSuccessfulRender(t *testing.T) {
+	document := buildTestPDF(2)
+	sink := &memoryAuditSink{}
+	usage := ResourceUsage{WallTime: 1500 * time.Millisecond, UserCPU: 1200 * time.Millisecond, SystemCPU: 100 * time.Millisecond, MaxRSSBytes: 64 << 20}
+	svc := newTestService(fakeValidator{}, &fakeRunner{output: document, result: RenderResult{Usage: usage}}, WithAuditLog(sink))
+
+	rec := postMultipart(t, svc, "/pdf", []testPart{testHTMLPart, {field: "attachment.terms", filename: "terms.txt", content: "test-file"}})
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	records := sink.all()
+	if !assert.Len(t, records, 1) {
+		return
+	}
+	record := records[0]
+	assert.Equal(t, http.StatusOK, record.Status)
+	assert.Equal(t, "test-subject", record.Subject)
+	assert.Equal(t, "test-client", record.ClientID)
+	assert.Equal(t, 2, record.PageCount)
+	assert.Equal(t, int64(len(document)), record.OutputBytes)
+	assert.Equal(t, sha256Hex(document), record.OutputSHA256)
+	assert.Equal(t, int64(1500), record.RenderMS)
+	assert.Equal(t, int64(1200), record.CPUUserMS)
+	assert.Equal(t, int64(100), record.CPUSystemMS)
+	assert.Equal(t, int64(64<<20), record.MaxRSSBytes)
+	assert.Len(t, record.Inputs, 2)
+	assert.Contains(t, record.Inputs, AuditInput{
+		FormName: "html",
+		Filename: "html.txt",
+		Role:     PartRoleHTML,
+		Size:     int64(len(testHTMLPart.content)),
+		SHA256:   sha256Hex([]byte(testHTMLPart.content)),
+	})
+}
+
+func TestAuditRecordsRejectedRequest(t *testing.T) {
+	sink := &memoryAuditSink{}
+	svc := newTestService(fakeValidator{err: ErrForbidden}, &fakeRunner{}, WithAuditLog(sink))
+
+	req := httptest.NewRequest(http.MethodPost, "/pdf", strings.NewReader(""))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+
+	svc.Routes().ServeHTTP(rec, req)
+
+	records := sink.all()
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, http.StatusForbidden, records[0].Status)
+		assert.Empty(t, records[0].Subject)
+	}
+}
+
+func TestAuditLogRotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, err := NewAuditLog(path, 200)
+	assert.NoError(t, err)
+	defer log.Close()
+
+	for range 5 {
+		assert.NoError(t, log.Record(AuditRecord{Method: http.MethodPost, Path: "/pdf", Status: http.StatusOK}))
+	}
+
+	rotated, _ := filepath.Glob(path + ".*")
+	assert.NotEmpty(t, rotated)
+	assertJSONLines(t, path)
+}
+
+func TestAuditLogRotatesByDay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, err := NewAuditLog(path, 0)
+	assert.NoError(t, err)
+	defer log.Close()
+
+	now := time.Date(2026, 1, 1, 23, 59, 0, 0, time.UTC)
+	log.now = func() time.Time { return now }
+	log.day = auditDay(now)
+
+	assert.NoError(t, log.Record(AuditRecord{Status: http.StatusOK}))
+	now = now.Add(2 * time.Minute)
+	assert.NoError(t, log.Record(AuditRecord{Status: http.StatusOK}))
+
+	rotated, _ := filepath.Glob(path + ".*")
+	assert.Len(t, rotated, 1)
+	assert.Equal(t, 1, assertJSONLines(t, path))
+}
+
+func TestAuditLogKeepsWritingWhenRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, err := NewAuditLog(path, 200)
+	assert.NoError(t, err)
+	defer log.Close()
+
+	log.rename = func(string, string) error { return os.ErrPermission }
+	var failures int
+	for range 5 {
+		if log.Record(AuditRecord{Method: http.MethodPost, Path: "/pdf", Status: http.StatusOK}) != nil {
+			failures++
+		}
+	}
+
+	assert.Positive(t, failures)
+	assert.Equal(t, 5, assertJSONLines(t, path))
+
+	log.rename = os.Rename
+	assert.NoError(t, log.Record(AuditRecord{Method: http.MethodPost, Path: "/pdf", Status: http.StatusOK}))
+	rotated, _ := filepath.Glob(path + ".*")
+	assert.Len(t, rotated, 1)
+	assert.Equal(t, 1, assertJSONLines(t, path))
+}
+
+func TestAuditLogReopensAfterFailedOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, err := NewAuditLog(path, 200)
+	assert.NoError(t, err)
+	defer log.Close()
+
+	assert.NoError(t, log.Record(AuditRecord{Status: http.StatusOK}))
+	assert.NoError(t, os.Remove(path))
+	assert.NoError(t, os.Mkdir(path, 0o700))
+	log.rename = func(string, string) error { return os.ErrPermission }
+	for range 5 {
+		assert.Error(t, log.Record(AuditRecord{Status: http.StatusOK}))
+	}
+	assert.Nil(t, log.file)
+
+	assert.NoError(t, os.Remove(path))
+	assert.NoError(t, log.Record(AuditRecord{Status: http.StatusOK}))
+	assert.Equal(t, 1, assertJSONLines(t, path))
+}
+
+func assertJSONLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	for _, line := range lines {
+		var record AuditRecord
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+	}
+	return len(lines)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+type memoryAuditSink struct {
+	mu      sync.Mutex
+	records []AuditRecord
+}
+
+func (m *memoryAuditSink) Record(record AuditRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records = append(m.records, record)
+	return nil
+}
+
+func (m *memoryAuditSink) all() []AuditRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]AuditRecord(nil), m.records...)
+}
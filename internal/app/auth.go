@@ -0,0 +1,38 @@
+package app
+
+import (
+	"context"
+	"slices"
+)
+
+const (
+	AuthMethodBearer = "bearer"
+	AuthMethodHMAC   = "hmac"
+)
+
+// Principal identifies the authenticated caller of a request.
+type Principal struct {
+	Subject  string
+	ClientID string
+	Scopes   []string
+	Method   string
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	if p == nil {
+		return false
+	}
+	return slices.Contains(p.Scopes, scope)
+}
+
+type principalContextKey struct{}
+
+func withPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal stored by the auth middleware, or nil.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalContextKey{}).(*Principal)
+	return principal
+}
@@ -0,0 +1,332 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HMACAuthScheme is the Authorization scheme used for signed service-to-service requests:
+//
+//	Authorization: PDF-HMAC-SHA256 KeyId=<id>, Timestamp=<unix seconds>, Nonce=<nonce>, Signature=<base64>
+//	X-Content-Sha256: <hex sha256 of the request body>
+//
+// The signature is HMAC-SHA256, keyed with the shared secret, over the newline separated scheme,
+// method, request URI, timestamp, nonce and hex body digest.
+const HMACAuthScheme = "PDF-HMAC-SHA256"
+
+const HeaderContentSHA256 = "X-Content-Sha256"
+
+const (
+	defaultHMACMaxClockSkew = 5 * time.Minute
+	maxNonceLength          = 128
+	maxBodyEpilogueBytes    = 64 * 1024
+)
+
+var (
+	ErrSignatureMismatch  = errors.New("signature mismatch")
+	ErrBodyDigestMismatch = errors.New("body digest mismatch")
+)
+
+type HMACKey struct {
+	ID       string   `json:"id"`
+	Secret   []byte   `json:"secret"`
+	ClientID string   `json:"client_id"`
+	Scopes   []string `json:"scopes"`
+}
+
+type HMACValidator struct {
+	keys         atomic.Pointer[HMACKeySet]
+	scope        string
+	maxClockSkew time.Duration
+	nonces       *nonceCache
+	now          func() time.Time
+}
+
+// HMACKeySet is a validated, immutable set of shared secrets indexed by key id.
+type HMACKeySet struct {
+	keys map[string]HMACKey
+}
+
+func NewHMACKeySet(keys []HMACKey) (*HMACKeySet, error) {
+	keyMap := make(map[string]HMACKey, len(keys))
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, errors.New("hmac key id is required")
+		}
+		if len(key.Secret) < sha256.Size {
+			return nil, fmt.Errorf("hmac key %q: secret must be at least %d bytes", key.ID, sha256.Size)
+		}
+		if _, exists := keyMap[key.ID]; exists {
+			return nil, fmt.Errorf("hmac key %q: duplicate key id", key.ID)
+		}
+		keyMap[key.ID] = key
+	}
+	return &HMACKeySet{keys: keyMap}, nil
+}
+
+func (s *HMACKeySet) Len() int {
+	return len(s.keys)
+}
+
+// Key returns the key with the given id.
+func (s *HMACKeySet) Key(id string) (HMACKey, bool) {
+	key, ok := s.keys[id]
+	return key, ok
+}
+
+func NewHMACValidator(keys []HMACKey, scope string, maxClockSkew time.Duration) (*HMACValidator, error) {
+	if scope == "" {
+		return nil, errors.New("scope is required")
+	}
+
+	if maxClockSkew <= 0 {
+		maxClockSkew = defaultHMACMaxClockSkew
+	}
+
+	keySet, err := NewHMACKeySet(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	validator := &HMACValidator{
+		scope:        scope,
+		maxClockSkew: maxClockSkew,
+		nonces:       newNonceCache(),
+		now:          time.Now,
+	}
+	validator.SetKeys(keySet)
+	return validator, nil
+}
+
+// SetKeys atomically replaces the accepted keys. Used nonces are kept so a reload cannot reopen a replay window.
+func (v *HMACValidator) SetKeys(keys *HMACKeySet) {
+	v.keys.Store(keys)
+}
+
+// ReadHMACKeysFile reads a JSON array of HMACKey values. Secrets are base64 encoded.
+func ReadHMACKeysFile(path string) ([]HMACKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []HMACKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse hmac keys file: %w", err)
+	}
+	return keys, nil
+}
+
+// ValidateRequest verifies the signature in credentials against r and returns the calling principal.
+// The request body is replaced with a reader that hashes it; the digest is checked by verifyRequestBody
+// once the body has been consumed.
+func (v *HMACValidator) ValidateRequest(r *http.Request, credentials string) (*Principal, error) {
+	params, err := parseHMACCredentials(credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := v.keys.Load().keys[params.keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", params.keyID)
+	}
+
+	timestamp, err := strconv.ParseInt(params.timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid timestamp")
+	}
+	signedAt := time.Unix(timestamp, 0)
+	if skew := v.now().Sub(signedAt).Abs(); skew > v.maxClockSkew {
+		return nil, fmt.Errorf("timestamp outside allowed clock skew (%s)", skew.Round(time.Second))
+	}
+
+	bodyDigest, err := hex.DecodeString(r.Header.Get(HeaderContentSHA256))
+	if err != nil || len(bodyDigest) != sha256.Size {
+		return nil, fmt.Errorf("missing or invalid %s header", HeaderContentSHA256)
+	}
+
+	expected := signRequest(key.Secret, r.Method, r.URL.RequestURI(), params.timestamp, params.nonce, hex.EncodeToString(bodyDigest))
+	if !hmac.Equal(expected, params.signature) {
+		return nil, ErrSignatureMismatch
+	}
+
+	if !v.nonces.add(params.keyID+":"+params.nonce, signedAt.Add(v.maxClockSkew)) {
+		return nil, errors.New("nonce already used")
+	}
+
+	principal := &Principal{
+		Subject:  key.ID,
+		ClientID: key.ClientID,
+		Scopes:   key.Scopes,
+		Method:   AuthMethodHMAC,
+	}
+	if !principal.HasScope(v.scope) {
+		return nil, fmt.Errorf("%w: required scope %q missing", ErrForbidden, v.scope)
+	}
+
+	r.Body = &signedBody{ReadCloser: r.Body, hash: sha256.New(), expected: bodyDigest}
+	return principal, nil
+}
+
+// SignRequest adds HMAC authentication headers to r for the given body. It is used by clients and tests.
+func SignRequest(r *http.Request, key HMACKey, body []byte, now time.Time, nonce string) {
+	bodyDigest := sha256.Sum256(body)
+	signRequestDigest(r, key, hex.EncodeToString(bodyDigest[:]), now, nonce)
+}
+
+// signRequestDigest is SignRequest for a body whose hex SHA-256 digest is already known, such as a body streamed from disk.
+func signRequestDigest(r *http.Request, key HMACKey, digestHex string, now time.Time, nonce string) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := signRequest(key.Secret, r.Method, r.URL.RequestURI(), timestamp, nonce, digestHex)
+
+	r.Header.Set(HeaderContentSHA256, digestHex)
+	r.Header.Set("Authorization", fmt.Sprintf("%s KeyId=%s, Timestamp=%s, Nonce=%s, Signature=%s",
+		HMACAuthScheme, key.ID, timestamp, nonce, base64.StdEncoding.EncodeToString(signature)))
+}
+
+func signRequest(secret []byte, method string, requestURI string, timestamp string, nonce string, bodyDigestHex string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = io.WriteString(mac, strings.Join([]string{
+		HMACAuthScheme,
+		strings.ToUpper(method),
+		requestURI,
+		timestamp,
+		nonce,
+		bodyDigestHex,
+	}, "\n"))
+	return mac.Sum(nil)
+}
+
+type hmacCredentials struct {
+	keyID     string
+	timestamp string
+	nonce     string
+	signature []byte
+}
+
+func parseHMACCredentials(credentials string) (hmacCredentials, error) {
+	var params hmacCredentials
+	for field := range strings.SplitSeq(credentials, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return params, errors.New("malformed signature parameters")
+		}
+		switch strings.ToLower(name) {
+		case "keyid":
+			params.keyID = value
+		case "timestamp":
+			params.timestamp = value
+		case "nonce":
+			params.nonce = value
+		case "signature":
+			signature, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return params, errors.New("malformed signature")
+			}
+			params.signature = signature
+		}
+	}
+
+	if params.keyID == "" || params.timestamp == "" || params.nonce == "" || len(params.signature) == 0 {
+		return params, errors.New("missing signature parameters")
+	}
+	if len(params.nonce) > maxNonceLength {
+		return params, errors.New("nonce too long")
+	}
+	return params, nil
+}
+
+type signedBody struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected []byte
+}
+
+func (b *signedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	return n, err
+}
+
+// verify drains what is left of the body (normally only the multipart epilogue) and compares digests.
+func (b *signedBody) verify() error {
+	n, err := io.Copy(io.Discard, io.LimitReader(b, maxBodyEpilogueBytes+1))
+	if err != nil {
+		return err
+	}
+	if n > maxBodyEpilogueBytes {
+		return errors.New("unexpected data after multipart body")
+	}
+	if !bytes.Equal(b.hash.Sum(nil), b.expected) {
+		return ErrBodyDigestMismatch
+	}
+	return nil
+}
+
+type signedBodyContextKey struct{}
+
+func withSignedBody(ctx context.Context, body *signedBody) context.Context {
+	return context.WithValue(ctx, signedBodyContextKey{}, body)
+}
+
+// verifyRequestBody checks the body digest of a signed request. It is a no-op for other requests.
+func verifyRequestBody(ctx context.Context) error {
+	body, ok := ctx.Value(signedBodyContextKey{}).(*signedBody)
+	if !ok {
+		return nil
+	}
+	if err := body.verify(); err != nil {
+		return NewUnauthorizedError("Unauthorized", err)
+	}
+	return nil
+}
+
+const noncePurgeInterval = time.Minute
+
+type nonceCache struct {
+	mu         sync.Mutex
+	entries    map[string]time.Time
+	lastPurged time.Time
+	now        func() time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{entries: make(map[string]time.Time), now: time.Now}
+}
+
+// add records nonce until expiresAt and reports whether it was unused.
+func (c *nonceCache) add(nonce string, expiresAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if expiry, ok := c.entries[nonce]; ok && expiry.After(now) {
+		return false
+	}
+	if now.Sub(c.lastPurged) > noncePurgeInterval {
+		for key, expiry := range c.entries {
+			if !expiry.After(now) {
+				delete(c.entries, key)
+			}
+		}
+		c.lastPurged = now
+	}
+	c.entries[nonce] = expiresAt
+	return true
+}
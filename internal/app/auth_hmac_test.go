@@ -0,0 +1,159 @@
+package app
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testHMACKey = HMACKey{
+	ID:       "legacy-system",
+	Secret:   bytes.Repeat([]byte("s"), 32),
+	ClientID: "legacy-client",
+	Scopes:   []string{"pdf#create"},
+}
+
+func TestSignedRequestSucceeds(t *testing.T) {
+	runner := &fakeRunner{output: []byte("%PDF-1.4")}
+	svc := newTestService(fakeValidator{}, runner, WithSignedRequests(newTestHMACValidator(t, testHMACKey)))
+
+	req, _ := newSignedPDFRequest(t, testHMACKey, time.Now(), "nonce-1")
+	rec := httptest.NewRecorder()
+
+	svc.Routes().ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code, "body: %q", rec.Body.String())
+	assert.Equal(t, "%PDF-1.4", rec.Body.String())
+}
+
+func TestSignedRequestRejectsTamperedBody(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{}, WithSignedRequests(newTestHMACValidator(t, testHMACKey)))
+
+	signed, body := newSignedPDFRequest(t, testHMACKey, time.Now(), "nonce-1")
+	req := httptest.NewRequest(http.MethodPost, "/pdf", bytes.NewReader(bytes.Replace(body, []byte("ok"), []byte("no"), 1)))
+	req.Header = signed.Header
+	rec := httptest.NewRecorder()
+
+	svc.Routes().ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestSignedRequestRejectsWrongSecret(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{}, WithSignedRequests(newTestHMACValidator(t, testHMACKey)))
+
+	wrongKey := testHMACKey
+	wrongKey.Secret = bytes.Repeat([]byte("x"), 32)
+	req, _ := newSignedPDFRequest(t, wrongKey, time.Now(), "nonce-1")
+	rec := httptest.NewRecorder()
+
+	svc.Routes().ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestSignedRequestRejectsClockSkew(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{}, WithSignedRequests(newTestHMACValidator(t, testHMACKey)))
+
+	req, _ := newSignedPDFRequest(t, testHMACKey, time.Now().Add(-10*time.Minute), "nonce-1")
+	rec := httptest.NewRecorder()
+
+	svc.Routes().ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestSignedRequestRejectsNonceReplay(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{}, WithSignedRequests(newTestHMACValidator(t, testHMACKey)))
+	now := time.Now()
+
+	first, _ := newSignedPDFRequest(t, testHMACKey, now, "nonce-1")
+	firstRec := httptest.NewRecorder()
+	svc.Routes().ServeHTTP(firstRec, first)
+
+	replay, _ := newSignedPDFRequest(t, testHMACKey, now, "nonce-1")
+	replayRec := httptest.NewRecorder()
+	svc.Routes().ServeHTTP(replayRec, replay)
+
+	assert.Equal(t, http.StatusOK, firstRec.Code)
+	assert.Equal(t, http.StatusUnauthorized, replayRec.Code)
+}
+
+func TestSignedRequestForbiddenWhenKeyLacksScope(t *testing.T) {
+	key := testHMACKey
+	key.Scopes = []string{"pdf#read"}
+	svc := newTestService(fakeValidator{}, &fakeRunner{}, WithSignedRequests(newTestHMACValidator(t, key)))
+
+	req, _ := newSignedPDFRequest(t, key, time.Now(), "nonce-1")
+	rec := httptest.NewRecorder()
+
+	svc.Routes().ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestSignedRequestRejectedWhenNotConfigured(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{})
+
+	req, _ := newSignedPDFRequest(t, testHMACKey, time.Now(), "nonce-1")
+	rec := httptest.NewRecorder()
+
+	svc.Routes().ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestNewHMACValidatorRejectsShortSecret(t *testing.T) {
+	key := testHMACKey
+	key.Secret = []byte("short")
+
+	_, err := NewHMACValidator([]HMACKey{key}, "pdf#create", time.Minute)
+
+	assert.Error(t, err)
+}
+
+func newTestHMACValidator(t *testing.T, keys ...HMACKey) *HMACValidator {
+	t.Helper()
+	validator, err := NewHMACValidator(keys, "pdf#create", 5*time.Minute)
+	if err != nil {
+		t.Fatalf("failed to create hmac validator: %v", err)
+	}
+	return validator
+}
+
+func newSignedPDFRequest(t *testing.T, key HMACKey, now time.Time, nonce string) (*http.Request, []byte) {
+	t.Helper()
+	req := newMultipartRequest(t, "/pdf", []testPart{testHTMLPart})
+	payload, err := io.ReadAll(req.Body)
+	assert.NoError(t, err)
+	req.Body = io.NopCloser(bytes.NewReader(payload))
+	SignRequest(req, key, payload, now, nonce)
+	return req, payload
+}
+
+func TestHMACValidatorSetKeysReplacesKeys(t *testing.T) {
+	validator := newTestHMACValidator(t, testHMACKey)
+	svc := newTestService(fakeValidator{}, &fakeRunner{}, WithSignedRequests(validator))
+
+	rotated := testHMACKey
+	rotated.ID = "legacy-system-2"
+	keySet, err := NewHMACKeySet([]HMACKey{rotated})
+	assert.NoError(t, err)
+	validator.SetKeys(keySet)
+
+	oldReq, _ := newSignedPDFRequest(t, testHMACKey, time.Now(), "nonce-1")
+	oldRec := httptest.NewRecorder()
+	svc.Routes().ServeHTTP(oldRec, oldReq)
+
+	newReq, _ := newSignedPDFRequest(t, rotated, time.Now(), "nonce-2")
+	newRec := httptest.NewRecorder()
+	svc.Routes().ServeHTTP(newRec, newReq)
+
+	assert.Equal(t, http.StatusUnauthorized, oldRec.Code)
+	assert.Equal(t, http.StatusOK, newRec.Code)
+}
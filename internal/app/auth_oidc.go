@@ -4,27 +4,38 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"net/http"
 	"slices"
 	"strings"
+	"sync/atomic"
 
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/lestrrat-go/jwx/v2/jwt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 )
 
 var ErrForbidden = errors.New("forbidden")
 
 type OIDCValidator struct {
-	issuer   string
-	audience string
-	scope    string
-	keySet   jwk.Set
+	audience   string
+	scope      string
+	httpClient *http.Client
+	issuers    atomic.Pointer[TrustedIssuers]
+	refreshes  metric.Int64Counter
 }
 
-func NewOIDCValidator(ctx context.Context, issuer string, audience string, scope string, obs Observability) (*OIDCValidator, error) {
-	if issuer == "" {
-		return nil, errors.New("authority is required")
-	}
+// TrustedIssuers maps normalized issuer URLs to their JWKS. It is immutable once fetched.
+type TrustedIssuers struct {
+	keySets map[string]jwk.Set
+}
+
+func (t *TrustedIssuers) Issuers() []string {
+	return slices.Sorted(maps.Keys(t.keySets))
+}
 
+func NewOIDCValidator(ctx context.Context, issuers []string, audience string, scope string, obs Observability) (*OIDCValidator, error) {
 	if audience == "" {
 		return nil, errors.New("audience is required")
 	}
@@ -33,64 +44,136 @@ func NewOIDCValidator(ctx context.Context, issuer string, audience string, scope
 		return nil, errors.New("scope is required")
 	}
 
-	normalizedIssuer := strings.TrimRight(issuer, "/")
-	jwksURI := normalizedIssuer + "/.well-known/jwks.json"
+	refreshes, _ := obs.Meter().Int64Counter("pdf.auth.jwks_refreshes",
+		metric.WithDescription("JWKS fetches, by issuer and result (success, failure or empty)."))
+	validator := &OIDCValidator{
+		audience:   audience,
+		scope:      scope,
+		httpClient: obs.HttpClient(nil),
+		refreshes:  refreshes,
+	}
 
-	keySet, err := jwk.Fetch(ctx, jwksURI, jwk.WithHTTPClient(obs.HttpClient(nil)))
+	trusted, err := validator.FetchIssuers(ctx, issuers)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch jwks: %w", err)
+		return nil, err
 	}
-	if keySet.Len() == 0 {
-		return nil, errors.New("jwks is empty")
+	validator.SetIssuers(trusted)
+
+	return validator, nil
+}
+
+// FetchIssuers loads the JWKS of every issuer. It fails if any issuer cannot be loaded, so a bad
+// reload never replaces a working set.
+func (v *OIDCValidator) FetchIssuers(ctx context.Context, issuers []string) (*TrustedIssuers, error) {
+	if len(issuers) == 0 {
+		return nil, errors.New("authority is required")
 	}
 
-	validator := &OIDCValidator{
-		issuer:   normalizedIssuer,
-		audience: audience,
-		scope:    scope,
-		keySet:   keySet,
+	trusted := &TrustedIssuers{keySets: make(map[string]jwk.Set, len(issuers))}
+	for _, issuer := range issuers {
+		normalizedIssuer := normalizeIssuer(issuer)
+		if normalizedIssuer == "" {
+			return nil, errors.New("authority is required")
+		}
+		jwksURI := normalizedIssuer + "/.well-known/jwks.json"
+
+		keySet, err := jwk.Fetch(ctx, jwksURI, jwk.WithHTTPClient(v.httpClient))
+		if err != nil {
+			v.recordRefresh(ctx, normalizedIssuer, "failure")
+			return nil, fmt.Errorf("failed to fetch jwks for %s: %w", normalizedIssuer, err)
+		}
+		if keySet.Len() == 0 {
+			v.recordRefresh(ctx, normalizedIssuer, "empty")
+			return nil, fmt.Errorf("jwks for %s is empty", normalizedIssuer)
+		}
+		v.recordRefresh(ctx, normalizedIssuer, "success")
+		trusted.keySets[normalizedIssuer] = keySet
 	}
 
-	return validator, nil
+	return trusted, nil
+}
+
+func (v *OIDCValidator) recordRefresh(ctx context.Context, issuer string, result string) {
+	v.refreshes.Add(ctx, 1, metric.WithAttributes(attribute.String("issuer", issuer), attribute.String("result", result)))
 }
 
-func (v *OIDCValidator) Validate(ctx context.Context, token string) error {
+// SetIssuers atomically replaces the trusted issuers. Validations already in progress keep the old set.
+func (v *OIDCValidator) SetIssuers(trusted *TrustedIssuers) {
+	v.issuers.Store(trusted)
+}
+
+// CheckHealth reports whether a key set is loaded for every trusted issuer.
+func (v *OIDCValidator) CheckHealth(context.Context) error {
+	trusted := v.issuers.Load()
+	if trusted == nil || len(trusted.keySets) == 0 {
+		return errors.New("no jwks loaded")
+	}
+	for issuer, keySet := range trusted.keySets {
+		if keySet.Len() == 0 {
+			return fmt.Errorf("jwks for %s is empty", issuer)
+		}
+	}
+	return nil
+}
+
+func (v *OIDCValidator) Validate(ctx context.Context, token string) (*Principal, error) {
+	unverified, err := jwt.ParseInsecure([]byte(token))
+	if err != nil {
+		return nil, fmt.Errorf("token verification failed: %w", err)
+	}
+
+	issuer := normalizeIssuer(unverified.Issuer())
+	keySet, ok := v.issuers.Load().keySets[issuer]
+	if !ok {
+		return nil, fmt.Errorf("token claims validation failed: untrusted issuer %q", issuer)
+	}
+
 	parsedToken, err := jwt.Parse(
 		[]byte(token),
-		jwt.WithKeySet(v.keySet),
+		jwt.WithKeySet(keySet),
 		jwt.WithValidate(true),
 	)
 	if err != nil {
-		return fmt.Errorf("token verification failed: %w", err)
+		return nil, fmt.Errorf("token verification failed: %w", err)
 	}
 
 	if err := jwt.Validate(parsedToken, jwt.WithAudience(v.audience)); err != nil {
-		return fmt.Errorf("token claims validation failed: %w", err)
+		return nil, fmt.Errorf("token claims validation failed: %w", err)
 	}
 
-	if normalizeIssuer(parsedToken.Issuer()) != v.issuer {
-		return errors.New("token claims validation failed: issuer mismatch")
+	if normalizeIssuer(parsedToken.Issuer()) != issuer {
+		return nil, errors.New("token claims validation failed: issuer mismatch")
 	}
 
-	if !hasRequiredScope(parsedToken, v.scope) {
-		return fmt.Errorf("%w: required scope %q missing", ErrForbidden, v.scope)
+	principal := &Principal{
+		Subject:  parsedToken.Subject(),
+		ClientID: stringClaim(parsedToken, "client_id"),
+		Scopes:   strings.Fields(stringClaim(parsedToken, "scope")),
+		Method:   AuthMethodBearer,
+	}
+	if principal.ClientID == "" {
+		principal.ClientID = stringClaim(parsedToken, "azp")
 	}
 
-	return nil
+	if !principal.HasScope(v.scope) {
+		return nil, fmt.Errorf("%w: required scope %q missing", ErrForbidden, v.scope)
+	}
+
+	return principal, nil
 }
 
-func hasRequiredScope(token jwt.Token, required string) bool {
-	rawScope, ok := token.Get("scope")
+func stringClaim(token jwt.Token, name string) string {
+	raw, ok := token.Get(name)
 	if !ok {
-		return false
+		return ""
 	}
 
-	scope, cast := rawScope.(string)
+	value, cast := raw.(string)
 	if !cast {
-		return false
+		return ""
 	}
 
-	return slices.Contains(strings.Fields(scope), required)
+	return value
 }
 
 func normalizeIssuer(issuer string) string {
@@ -0,0 +1,128 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOIDCValidatorAcceptsAnyTrustedIssuer(t *testing.T) {
+	first := newTestIssuer(t)
+	second := newTestIssuer(t)
+	validator, err := NewOIDCValidator(context.Background(), []string{first.URL(), second.URL() + "/"}, "api", "pdf#create", NewMockObservabilityProvider())
+	assert.NoError(t, err)
+
+	principal, err := validator.Validate(context.Background(), second.token(t, "pdf#create"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", principal.Subject)
+	assert.Equal(t, "client-1", principal.ClientID)
+	assert.Equal(t, AuthMethodBearer, principal.Method)
+}
+
+func TestOIDCValidatorRejectsIssuerRemovedBySetIssuers(t *testing.T) {
+	first := newTestIssuer(t)
+	second := newTestIssuer(t)
+	validator, err := NewOIDCValidator(context.Background(), []string{first.URL(), second.URL()}, "api", "pdf#create", NewMockObservabilityProvider())
+	assert.NoError(t, err)
+
+	trusted, err := validator.FetchIssuers(context.Background(), []string{first.URL()})
+	assert.NoError(t, err)
+	validator.SetIssuers(trusted)
+
+	_, err = validator.Validate(context.Background(), second.token(t, "pdf#create"))
+	assert.ErrorContains(t, err, "untrusted issuer")
+	_, err = validator.Validate(context.Background(), first.token(t, "pdf#create"))
+	assert.NoError(t, err)
+}
+
+func TestOIDCValidatorForbiddenWithoutScope(t *testing.T) {
+	issuer := newTestIssuer(t)
+	validator, err := NewOIDCValidator(context.Background(), []string{issuer.URL()}, "api", "pdf#create", NewMockObservabilityProvider())
+	assert.NoError(t, err)
+
+	_, err = validator.Validate(context.Background(), issuer.token(t, "pdf#read"))
+
+	assert.ErrorIs(t, err, ErrForbidden)
+}
+
+func TestOIDCValidatorFetchIssuersFailsForUnreachableIssuer(t *testing.T) {
+	issuer := newTestIssuer(t)
+	validator, err := NewOIDCValidator(context.Background(), []string{issuer.URL()}, "api", "pdf#create", NewMockObservabilityProvider())
+	assert.NoError(t, err)
+
+	_, err = validator.FetchIssuers(context.Background(), []string{issuer.URL(), "http://127.0.0.1:1"})
+
+	assert.Error(t, err)
+	_, err = validator.Validate(context.Background(), issuer.token(t, "pdf#create"))
+	assert.NoError(t, err)
+}
+
+type testIssuer struct {
+	server *httptest.Server
+	key    jwk.Key
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	rawKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	key, err := jwk.FromRaw(rawKey)
+	if err != nil {
+		t.Fatalf("failed to create jwk: %v", err)
+	}
+	_ = key.Set(jwk.KeyIDKey, "test-key")
+	_ = key.Set(jwk.AlgorithmKey, jwa.RS256)
+
+	publicKey, err := key.PublicKey()
+	if err != nil {
+		t.Fatalf("failed to derive public key: %v", err)
+	}
+	keySet := jwk.NewSet()
+	_ = keySet.AddKey(publicKey)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(keySet)
+	}))
+	t.Cleanup(server.Close)
+
+	return &testIssuer{server: server, key: key}
+}
+
+func (i *testIssuer) URL() string {
+	return i.server.URL
+}
+
+func (i *testIssuer) token(t *testing.T, scope string) string {
+	t.Helper()
+	token, err := jwt.NewBuilder().
+		Issuer(i.URL()).
+		Audience([]string{"api"}).
+		Subject("user-1").
+		IssuedAt(time.Now()).
+		Expiration(time.Now().Add(time.Hour)).
+		Claim("scope", scope).
+		Claim("client_id", "client-1").
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build token: %v", err)
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256, i.key))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return string(signed)
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	cgroupRoot      = "/sys/fs/cgroup"
+	cgroupCPUPeriod = 100000
+)
+
+// CgroupManager places every render in its own cgroup v2 child with memory.max and cpu.max set.
+// It needs a delegated cgroup: the service's cgroup must be writable and offer the memory and cpu
+// controllers.
+type CgroupManager struct {
+	dir string
+}
+
+// NewCgroupManager moves the service into a "service" leaf of its own cgroup, so the memory and cpu
+// controllers can be enabled for per-render children next to it. It fails when delegation is not available.
+func NewCgroupManager() (*CgroupManager, error) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cgroup: %w", err)
+	}
+	relative, ok := unifiedCgroupPath(data)
+	if !ok {
+		return nil, errors.New("cgroup v2 is not available")
+	}
+	dir := filepath.Join(cgroupRoot, relative)
+
+	controllers, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cgroup controllers: %w", err)
+	}
+	available := strings.Fields(string(controllers))
+	for _, controller := range []string{"memory", "cpu"} {
+		if !slices.Contains(available, controller) {
+			return nil, fmt.Errorf("cgroup controller %s is not delegated", controller)
+		}
+	}
+
+	// A cgroup with processes cannot enable controllers for its children, so the service moves into a leaf first.
+	leaf := filepath.Join(dir, "service")
+	if err := os.Mkdir(leaf, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("failed to create service cgroup: %w", err)
+	}
+	if err := writeCgroupFile(leaf, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+		return nil, err
+	}
+	if err := writeCgroupFile(dir, "cgroup.subtree_control", "+memory +cpu"); err != nil {
+		return nil, err
+	}
+
+	return &CgroupManager{dir: dir}, nil
+}
+
+func unifiedCgroupPath(procSelfCgroup []byte) (string, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(procSelfCgroup))
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// renderCgroup is the cgroup of a single render. Processes are started in it through its directory fd.
+type renderCgroup struct {
+	dir string
+	fd  *os.File
+}
+
+func (m *CgroupManager) create(limits ResourceLimits) (*renderCgroup, error) {
+	dir, err := os.MkdirTemp(m.dir, "render-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create render cgroup: %w", err)
+	}
+	cgroup := &renderCgroup{dir: dir}
+
+	if limits.MemoryBytes > 0 {
+		if err := writeCgroupFile(dir, "memory.max", strconv.FormatInt(limits.MemoryBytes, 10)); err != nil {
+			cgroup.close()
+			return nil, err
+		}
+		// Without this the kernel swaps instead of enforcing memory.max. Not every host has swap accounting.
+		_ = writeCgroupFile(dir, "memory.swap.max", "0")
+	}
+	if limits.CPUQuota > 0 {
+		quota := int64(limits.CPUQuota * cgroupCPUPeriod)
+		if err := writeCgroupFile(dir, "cpu.max", fmt.Sprintf("%d %d", quota, cgroupCPUPeriod)); err != nil {
+			cgroup.close()
+			return nil, err
+		}
+	}
+
+	cgroup.fd, err = os.Open(dir)
+	if err != nil {
+		cgroup.close()
+		return nil, fmt.Errorf("failed to open render cgroup: %w", err)
+	}
+	return cgroup, nil
+}
+
+// oomKilled reports whether the kernel killed a process in the cgroup for exceeding memory.max.
+func (c *renderCgroup) oomKilled() bool {
+	data, err := os.ReadFile(filepath.Join(c.dir, "memory.events"))
+	if err != nil {
+		return false
+	}
+	for line := range strings.Lines(string(data)) {
+		if count, ok := strings.CutPrefix(strings.TrimSpace(line), "oom_kill "); ok {
+			return count != "0"
+		}
+	}
+	return false
+}
+
+// close removes the cgroup. It must only be called after every process in it has exited.
+func (c *renderCgroup) close() {
+	if c.fd != nil {
+		_ = c.fd.Close()
+	}
+	_ = os.Remove(c.dir)
+}
+
+func writeCgroupFile(dir string, name string, value string) error {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0o644); err != nil {
+		return fmt.Errorf("failed to write cgroup %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package app
+
+import (
+	"bufio"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// renderMaxDiagnostics bounds the diagnostics kept from a single render.
+	renderMaxDiagnostics = 100
+	// diagnosticMaxBytes bounds the message and URL of a diagnostic; tracebacks and data URLs are cut.
+	diagnosticMaxBytes = 1024
+	// renderStderrLimit is how much of a render's stderr is kept, from the end.
+	renderStderrLimit = 64 * 1024
+)
+
+// The diagnostics of a render are summarised in response headers: X-Render-Diagnostics lists as many as
+// fit in diagnosticsHeaderMaxBytes, X-Render-Diagnostics-Count counts all of them.
+const (
+	diagnosticsHeader         = "X-Render-Diagnostics"
+	diagnosticsCountHeader    = "X-Render-Diagnostics-Count"
+	diagnosticsHeaderMaxBytes = 4096
+)
+
+const (
+	DiagnosticLevelDebug   = "debug"
+	DiagnosticLevelInfo    = "info"
+	DiagnosticLevelWarning = "warning"
+	DiagnosticLevelError   = "error"
+)
+
+// Diagnostic is a message the renderer logged about a document, such as an image it could not load or a
+// CSS property it ignored.
+type Diagnostic struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+	// URL is the resource the message is about.
+	URL string `json:"url,omitempty"`
+	// Selector is the CSS selector the message is about.
+	Selector string `json:"selector,omitempty"`
+	// Declaration is the CSS the renderer ignored, at Line and Column of its stylesheet.
+	Declaration string `json:"declaration,omitempty"`
+	Line        int    `json:"line,omitempty"`
+	Column      int    `json:"column,omitempty"`
+	// RequestID is the request whose render logged the message.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+var (
+	diagnosticLevelPattern       = regexp.MustCompile(`^(DEBUG|INFO|WARNING|ERROR|CRITICAL): ?(.*)$`)
+	diagnosticURLPattern         = regexp.MustCompile(`\b(?:https?|file|data):[^\s'"]+`)
+	diagnosticSelectorPattern    = regexp.MustCompile(`selector ['"]([^'"]+)['"]`)
+	diagnosticDeclarationPattern = regexp.MustCompile("`([^`]+)`(?: at (\\d+):(\\d+))?")
+	diagnosticsHeaderItemPattern = regexp.MustCompile(`([a-z]+);message="((?:[^"\\]|\\.)*)"`)
+)
+
+// parseDiagnostics parses what WeasyPrint logged during the render of request requestID. A line starting with
+// a level ("WARNING: ...") starts a diagnostic and the lines up to the next one continue it; output without a
+// level is a warning.
+func parseDiagnostics(output string, requestID string) []Diagnostic {
+	var diagnostics []Diagnostic
+	var level string
+	var message strings.Builder
+	flush := func() {
+		if message.Len() > 0 && len(diagnostics) < renderMaxDiagnostics {
+			diagnostic := newDiagnostic(level, message.String())
+			diagnostic.RequestID = requestID
+			diagnostics = append(diagnostics, diagnostic)
+		}
+		message.Reset()
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if match := diagnosticLevelPattern.FindStringSubmatch(line); match != nil {
+			flush()
+			level = match[1]
+			message.WriteString(match[2])
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if message.Len() == 0 {
+			level = ""
+		} else {
+			message.WriteByte('\n')
+		}
+		message.WriteString(line)
+	}
+	flush()
+	return diagnostics
+}
+
+// newDiagnostic extracts the URL, selector and declaration from a message logged at level.
+func newDiagnostic(level string, message string) Diagnostic {
+	diagnostic := Diagnostic{Message: truncateUTF8(message, diagnosticMaxBytes)}
+	switch level {
+	case "DEBUG":
+		diagnostic.Level = DiagnosticLevelDebug
+	case "INFO":
+		diagnostic.Level = DiagnosticLevelInfo
+	case "ERROR", "CRITICAL":
+		diagnostic.Level = DiagnosticLevelError
+	default:
+		diagnostic.Level = DiagnosticLevelWarning
+	}
+
+	if url := diagnosticURLPattern.FindString(message); url != "" {
+		diagnostic.URL = truncateUTF8(strings.TrimRight(url, ".,:;)"), diagnosticMaxBytes)
+	}
+	if match := diagnosticSelectorPattern.FindStringSubmatch(message); match != nil {
+		diagnostic.Selector = match[1]
+	}
+	if match := diagnosticDeclarationPattern.FindStringSubmatch(message); match != nil {
+		diagnostic.Declaration = match[1]
+		diagnostic.Line, _ = strconv.Atoi(match[2])
+		diagnostic.Column, _ = strconv.Atoi(match[3])
+	}
+	return diagnostic
+}
+
+func truncateUTF8(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	for limit > 0 && !utf8.RuneStart(s[limit]) {
+		limit--
+	}
+	return s[:limit]
+}
+
+// setDiagnosticsHeaders summarises diagnostics in header, as a list of level;message="..." items. Characters a
+// header cannot carry are replaced by "?".
+func setDiagnosticsHeaders(header http.Header, diagnostics []Diagnostic) {
+	if len(diagnostics) == 0 {
+		return
+	}
+	var value strings.Builder
+	for _, diagnostic := range diagnostics {
+		item := diagnostic.Level + `;message="` + escapeHeaderString(diagnostic.Message) + `"`
+		if value.Len() > 0 {
+			item = ", " + item
+		}
+		if value.Len()+len(item) > diagnosticsHeaderMaxBytes {
+			break
+		}
+		value.WriteString(item)
+	}
+	if value.Len() > 0 {
+		header.Set(diagnosticsHeader, value.String())
+	}
+	header.Set(diagnosticsCountHeader, strconv.Itoa(len(diagnostics)))
+}
+
+func escapeHeaderString(s string) string {
+	var escaped strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			escaped.WriteByte('\\')
+			escaped.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			escaped.WriteByte('?')
+		default:
+			escaped.WriteRune(r)
+		}
+	}
+	return escaped.String()
+}
+
+// parseDiagnosticsHeader reads back the diagnostics summarised by setDiagnosticsHeaders.
+func parseDiagnosticsHeader(header http.Header) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, match := range diagnosticsHeaderItemPattern.FindAllStringSubmatch(header.Get(diagnosticsHeader), renderMaxDiagnostics) {
+		message := strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(match[2])
+		diagnostic := newDiagnostic("", message)
+		diagnostic.Level = match[1]
+		diagnostics = append(diagnostics, diagnostic)
+	}
+	return diagnostics
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	output := strings.Join([]string{
+		"WARNING: Ignored `margin-botom: 1cm` at 3:5, unknown property.",
+		"WARNING: Failed to load image at 'https://cdn.example.com/logo.png': HTTP Error 404: Not Found",
+		"",
+		"WARNING: Invalid or unsupported selector 'p:has(img)', unknown pseudo-class",
+		"ERROR: Failed to render document",
+		"Traceback (most recent call last):",
+		"  File \"render.py\", line 1",
+		"Fontconfig warning: ignoring UTF-8: not a valid region tag",
+	}, "\n")
+
+	assert.Equal(t, []Diagnostic{
+		{Level: DiagnosticLevelWarning, Message: "Ignored `margin-botom: 1cm` at 3:5, unknown property.", Declaration: "margin-botom: 1cm", Line: 3, Column: 5},
+		{Level: DiagnosticLevelWarning, Message: "Failed to load image at 'https://cdn.example.com/logo.png': HTTP Error 404: Not Found", URL: "https://cdn.example.com/logo.png"},
+		{Level: DiagnosticLevelWarning, Message: "Invalid or unsupported selector 'p:has(img)', unknown pseudo-class", Selector: "p:has(img)"},
+		{Level: DiagnosticLevelError, Message: "Failed to render document\nTraceback (most recent call last):\n  File \"render.py\", line 1\nFontconfig warning: ignoring UTF-8: not a valid region tag"},
+	}, parseDiagnostics(output, ""))
+
+	assert.Equal(t, []Diagnostic{{Level: DiagnosticLevelWarning, Message: "Fontconfig warning: ignoring UTF-8"}},
+		parseDiagnostics("Fontconfig warning: ignoring UTF-8\n", ""))
+	assert.Len(t, parseDiagnostics(strings.Repeat("WARNING: again\n", 2*renderMaxDiagnostics), ""), renderMaxDiagnostics)
+}
+
+func TestDiagnosticsHeadersRoundTrip(t *testing.T) {
+	diagnostics := []Diagnostic{
+		{Level: DiagnosticLevelWarning, Message: `Failed to load image at "logo.png"`},
+		{Level: DiagnosticLevelError, Message: `C:\fonts\Ünicode.ttf`},
+	}
+	header := http.Header{}
+
+	setDiagnosticsHeaders(header, diagnostics)
+
+	assert.Equal(t, `warning;message="Failed to load image at \"logo.png\"", error;message="C:\\fonts\\?nicode.ttf"`, header.Get(diagnosticsHeader))
+	assert.Equal(t, "2", header.Get(diagnosticsCountHeader))
+	assert.Equal(t, []Diagnostic{
+		{Level: DiagnosticLevelWarning, Message: `Failed to load image at "logo.png"`},
+		{Level: DiagnosticLevelError, Message: `C:\fonts\?nicode.ttf`},
+	}, parseDiagnosticsHeader(header))
+}
+
+func TestDiagnosticsHeaderIsCapped(t *testing.T) {
+	var diagnostics []Diagnostic
+	for i := range renderMaxDiagnostics {
+		diagnostics = append(diagnostics, Diagnostic{Level: DiagnosticLevelWarning, Message: "Failed to load image " + strconv.Itoa(i) + strings.Repeat(".", 100)})
+	}
+	header := http.Header{}
+
+	setDiagnosticsHeaders(header, diagnostics)
+
+	assert.LessOrEqual(t, len(header.Get(diagnosticsHeader)), diagnosticsHeaderMaxBytes)
+	assert.Less(t, len(parseDiagnosticsHeader(header)), renderMaxDiagnostics)
+	assert.Equal(t, strconv.Itoa(renderMaxDiagnostics), header.Get(diagnosticsCountHeader))
+}
+
+func TestRenderPDFReturnsDiagnosticsHeaders(t *testing.T) {
+	runner := &fakeRunner{result: RenderResult{Diagnostics: []Diagnostic{{Level: DiagnosticLevelWarning, Message: "Ignored unknown property"}}}}
+	svc := newTestService(fakeValidator{}, runner)
+
+	rec := postMultipart(t, svc, "/pdf", []testPart{testHTMLPart})
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `warning;message="Ignored unknown property"`, rec.Header().Get(diagnosticsHeader))
+	assert.Equal(t, "1", rec.Header().Get(diagnosticsCountHeader))
+}
+
+func TestRemoteRunnerReturnsNodeDiagnostics(t *testing.T) {
+	diagnostics := []Diagnostic{{Level: DiagnosticLevelWarning, Message: "Failed to load image at 'https://cdn.example.com/logo.png'", URL: "https://cdn.example.com/logo.png"}}
+	node := newRenderNode(t, &fakeRunner{result: RenderResult{Diagnostics: diagnostics}})
+	runner := newTestRemoteRunner(t, NewMockObservabilityProvider(), node.URL)
+
+	result, err := runner.GeneratePDF(context.Background(), remoteWorkspaceRequest(t), &bytes.Buffer{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, diagnostics, result.Diagnostics)
+}
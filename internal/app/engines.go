@@ -0,0 +1,193 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// EngineQueryParameter selects the engine of a render: POST /pdf?engine=<name>.
+const EngineQueryParameter = "engine"
+
+// Capabilities are the request features an engine may support. A request that uses a feature its engine
+// does not support is rejected before it is rendered.
+const (
+	// CapabilityStylesheet is a css part replacing the default stylesheet.
+	CapabilityStylesheet = "stylesheet"
+	// CapabilityAttachments are attachment.* and file.* parts embedded in the PDF.
+	CapabilityAttachments = "attachments"
+	// CapabilityAssets are other parts the HTML can reference.
+	CapabilityAssets = "assets"
+	// CapabilityBaseURL is a RenderRequest.BaseURL resolving the relative URLs of the HTML.
+	CapabilityBaseURL = "base_url"
+	// CapabilityOptions are RenderRequest.Options.
+	CapabilityOptions = "options"
+	// CapabilityLimits are RenderRequest.Limits tightening every limit of the runner.
+	CapabilityLimits = "limits"
+)
+
+// AllCapabilities are the capabilities of an engine that does not list its own.
+var AllCapabilities = []string{CapabilityStylesheet, CapabilityAttachments, CapabilityAssets, CapabilityBaseURL,
+	CapabilityOptions, CapabilityLimits}
+
+// Engine is a named renderer that requests can select.
+type Engine struct {
+	Name string
+	// Version is the renderer version reported at startup; empty when it is unknown.
+	Version string
+	// Capabilities lists the supported request features. Nil means AllCapabilities. Capabilities the
+	// runner does not support, as reported by a CapabilityReporter, are left out either way.
+	Capabilities []string
+	Runner       PDFRunner
+}
+
+func (e Engine) capabilities() []string {
+	capabilities := e.Capabilities
+	if capabilities == nil {
+		capabilities = AllCapabilities
+	}
+	reporter, ok := e.Runner.(CapabilityReporter)
+	if !ok {
+		return capabilities
+	}
+	supported := reporter.Capabilities()
+	return slices.DeleteFunc(slices.Clone(capabilities), func(capability string) bool {
+		return !slices.Contains(supported, capability)
+	})
+}
+
+// Supports reports whether the engine supports capability.
+func (e Engine) Supports(capability string) bool {
+	return slices.Contains(e.capabilities(), capability)
+}
+
+// VersionReporter is implemented by runners that can report the version of their renderer.
+type VersionReporter interface {
+	Version(ctx context.Context) (string, error)
+}
+
+// CapabilityReporter is implemented by runners that support only some of AllCapabilities.
+type CapabilityReporter interface {
+	Capabilities() []string
+}
+
+// EngineRegistry holds the engines of a Service and the one used when a request does not choose.
+type EngineRegistry struct {
+	engines     map[string]Engine
+	defaultName string
+}
+
+func NewEngineRegistry(defaultName string, engines ...Engine) (*EngineRegistry, error) {
+	registry := &EngineRegistry{engines: map[string]Engine{}, defaultName: defaultName}
+	for _, engine := range engines {
+		if engine.Name == "" {
+			return nil, errors.New("engine name is required")
+		}
+		if _, exists := registry.engines[engine.Name]; exists {
+			return nil, fmt.Errorf("engine %q: duplicate name", engine.Name)
+		}
+		if engine.Runner == nil {
+			return nil, fmt.Errorf("engine %q: runner is required", engine.Name)
+		}
+		for _, capability := range engine.Capabilities {
+			if !slices.Contains(AllCapabilities, capability) {
+				return nil, fmt.Errorf("engine %q: unknown capability %q", engine.Name, capability)
+			}
+		}
+		registry.engines[engine.Name] = engine
+	}
+	if _, ok := registry.engines[defaultName]; !ok {
+		return nil, fmt.Errorf("default engine %q is not registered", defaultName)
+	}
+	return registry, nil
+}
+
+// Lookup returns the engine called name, or the default engine when name is empty.
+func (r *EngineRegistry) Lookup(name string) (Engine, bool) {
+	if name == "" {
+		name = r.defaultName
+	}
+	engine, ok := r.engines[name]
+	return engine, ok
+}
+
+// Default returns the name of the default engine.
+func (r *EngineRegistry) Default() string {
+	return r.defaultName
+}
+
+// Engines returns every engine, ordered by name.
+func (r *EngineRegistry) Engines() []Engine {
+	var engines []Engine
+	for _, name := range slices.Sorted(maps.Keys(r.engines)) {
+		engines = append(engines, r.engines[name])
+	}
+	return engines
+}
+
+// unsupportedCapability returns the first capability that request, made of parts, needs and engine lacks.
+func unsupportedCapability(engine Engine, parts []PartInfo, request RenderRequest) (string, bool) {
+	for _, part := range parts {
+		var capability string
+		switch part.Role {
+		case PartRoleCSS:
+			capability = CapabilityStylesheet
+		case PartRoleAttachment:
+			capability = CapabilityAttachments
+		case PartRoleAsset:
+			capability = CapabilityAssets
+		default:
+			continue
+		}
+		if !engine.Supports(capability) {
+			return capability, true
+		}
+	}
+	for _, feature := range []struct {
+		capability string
+		used       bool
+	}{
+		{CapabilityBaseURL, request.BaseURL != ""},
+		{CapabilityOptions, request.Options != (RenderOptions{})},
+		{CapabilityLimits, request.Limits != (ResourceLimits{})},
+	} {
+		if feature.used && !engine.Supports(feature.capability) {
+			return feature.capability, true
+		}
+	}
+	return "", false
+}
+
+type engineInfo struct {
+	Name         string   `json:"name"`
+	Version      string   `json:"version,omitempty"`
+	Capabilities []string `json:"capabilities"`
+	Default      bool     `json:"default"`
+}
+
+// listEngines describes the engines requests can select.
+func (s *Service) listEngines(w http.ResponseWriter, _ *http.Request) {
+	var engines []engineInfo
+	for _, engine := range s.engines.Engines() {
+		engines = append(engines, engineInfo{
+			Name:         engine.Name,
+			Version:      engine.Version,
+			Capabilities: engine.capabilities(),
+			Default:      engine.Name == s.engines.Default(),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string][]engineInfo{"engines": engines})
+}
+
+// parseWeasyprintVersion extracts the version from the output of weasyprint --version.
+func parseWeasyprintVersion(output []byte) string {
+	version := strings.TrimSpace(string(output))
+	version, _ = strings.CutPrefix(version, "WeasyPrint version ")
+	return version
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewEngineRegistryValidatesEngines(t *testing.T) {
+	runner := &fakeRunner{}
+	tests := []struct {
+		name        string
+		defaultName string
+		engines     []Engine
+		wantErr     string
+	}{
+		{name: "duplicate name", defaultName: "a", engines: []Engine{{Name: "a", Runner: runner}, {Name: "a", Runner: runner}}, wantErr: "duplicate name"},
+		{name: "missing default", defaultName: "b", engines: []Engine{{Name: "a", Runner: runner}}, wantErr: `default engine "b" is not registered`},
+		{name: "unknown capability", defaultName: "a", engines: []Engine{{Name: "a", Runner: runner, Capabilities: []string{"javascript"}}}, wantErr: "unknown capability"},
+		{name: "missing runner", defaultName: "a", engines: []Engine{{Name: "a"}}, wantErr: "runner is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewEngineRegistry(tt.defaultName, tt.engines...)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRenderPDFSelectsEngine(t *testing.T) {
+	current := &fakeRunner{output: []byte("%PDF-current")}
+	next := &fakeRunner{output: []byte("%PDF-next")}
+	svc := newTestService(fakeValidator{}, nil, withTestEngines(
+		Engine{Name: "weasyprint", Version: "61.2", Runner: current},
+		Engine{Name: "weasyprint-62", Version: "62.3", Runner: next},
+	))
+
+	tests := []struct {
+		path        string
+		wantBody    string
+		wantEngine  string
+		wantVersion string
+	}{
+		{path: "/pdf", wantBody: "%PDF-current", wantEngine: "weasyprint", wantVersion: "61.2"},
+		{path: "/pdf?engine=weasyprint-62", wantBody: "%PDF-next", wantEngine: "weasyprint-62", wantVersion: "62.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := postMultipart(t, svc, tt.path, []testPart{testHTMLPart})
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tt.wantBody, rec.Body.String())
+			assert.Equal(t, tt.wantEngine, rec.Header().Get("X-Render-Engine"))
+			assert.Equal(t, tt.wantVersion, rec.Header().Get("X-Render-Engine-Version"))
+		})
+	}
+}
+
+func TestRenderPDFRejectsUnknownEngine(t *testing.T) {
+	runner := &fakeRunner{}
+	svc := newTestService(fakeValidator{}, runner)
+
+	rec := postMultipart(t, svc, "/pdf?engine=chrome", []testPart{testHTMLPart})
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	message, _, _ := strings.Cut(rec.Body.String(), "\n")
+	assert.Equal(t, "Unknown engine.", message)
+	assert.Empty(t, runner.lastRequest.HTMLFilename)
+}
+
+func TestRenderPDFRejectsUnsupportedCapabilities(t *testing.T) {
+	runner := &fakeRunner{}
+	svc := newTestService(fakeValidator{}, nil, withTestEngines(
+		Engine{Name: "weasyprint", Runner: &fakeRunner{}},
+		Engine{Name: "minimal", Runner: runner, Capabilities: []string{CapabilityStylesheet}},
+	))
+
+	rec := postMultipart(t, svc, "/pdf?engine=minimal", []testPart{testHTMLPart, {field: "attachment.invoice", filename: "invoice.xml", content: "test-file"}})
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `Engine "minimal" does not support attachments.`)
+	assert.Empty(t, runner.lastRequest.HTMLFilename)
+}
+
+func TestUnsupportedCapabilityChecksRequestFeatures(t *testing.T) {
+	pool := Engine{Name: "pool", Runner: &PooledRunner{}}
+	remote := Engine{Name: "remote", Runner: &RemoteRunner{}, Capabilities: []string{CapabilityStylesheet, CapabilityOptions}}
+	parts := []PartInfo{{FormName: "html", Role: PartRoleHTML}}
+
+	tests := []struct {
+		name    string
+		engine  Engine
+		request RenderRequest
+		want    string
+	}{
+		{name: "plain request", engine: remote, request: RenderRequest{HTMLFilename: "html.txt"}},
+		{name: "options on pool", engine: pool, request: RenderRequest{Options: RenderOptions{PDFVariant: "pdf/a-3b"}}},
+		{name: "limits on pool", engine: pool, request: RenderRequest{Limits: ResourceLimits{MemoryBytes: 1 << 20}}, want: CapabilityLimits},
+		{name: "options on remote", engine: remote, request: RenderRequest{Options: RenderOptions{PresentationalHints: true}}, want: CapabilityOptions},
+		{name: "base url on remote", engine: remote, request: RenderRequest{BaseURL: "https://example.com/"}, want: CapabilityBaseURL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			capability, unsupported := unsupportedCapability(tt.engine, parts, tt.request)
+			assert.Equal(t, tt.want != "", unsupported)
+			assert.Equal(t, tt.want, capability)
+		})
+	}
+	assert.Equal(t, []string{CapabilityStylesheet}, remote.capabilities())
+}
+
+func TestListEnginesReportsVersionsAndCapabilities(t *testing.T) {
+	svc := newTestService(fakeValidator{}, nil, withTestEngines(
+		Engine{Name: "weasyprint", Version: "61.2", Runner: &fakeRunner{}},
+		Engine{Name: "minimal", Runner: &fakeRunner{}, Capabilities: []string{CapabilityStylesheet}},
+	))
+	req := httptest.NewRequest(http.MethodGet, "/engines", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+
+	svc.Routes().ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var body struct {
+		Engines []engineInfo `json:"engines"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, []engineInfo{
+		{Name: "minimal", Capabilities: []string{CapabilityStylesheet}},
+		{Name: "weasyprint", Version: "61.2", Capabilities: AllCapabilities, Default: true},
+	}, body.Engines)
+}
+
+func TestParseWeasyprintVersion(t *testing.T) {
+	assert.Equal(t, "62.3", parseWeasyprintVersion([]byte("WeasyPrint version 62.3\n")))
+}
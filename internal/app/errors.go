@@ -54,6 +54,14 @@ func NewMethodNotAllowedError(message string, cause error) error {
 	return &AppError{StatusCode: http.StatusMethodNotAllowed, Message: message, Cause: cause}
 }
 
+func NewUnprocessableEntityError(message string, cause error) error {
+	return &AppError{StatusCode: http.StatusUnprocessableEntity, Message: message, Cause: cause}
+}
+
+func NewServiceUnavailableError(message string, cause error) error {
+	return &AppError{StatusCode: http.StatusServiceUnavailable, Message: message, Cause: cause}
+}
+
 func NewInternalError(message string, cause error) error {
 	return &AppError{StatusCode: http.StatusInternalServerError, Message: message, Cause: cause}
 }
@@ -80,12 +88,21 @@ func writeHTTPError(ctx context.Context, logger *slog.Logger, w http.ResponseWri
 			level = slog.LevelWarn
 		}
 		logger.Log(ctx, level, "request failed", attributes...)
-		http.Error(w, appErr.Message, appErr.StatusCode)
+		http.Error(w, errorBody(ctx, appErr.Message), appErr.StatusCode)
 		return
 	}
 
 	attributes = append(attributes, "status", http.StatusInternalServerError)
 
 	logger.ErrorContext(ctx, "request failed with unexpected error type", attributes...)
-	http.Error(w, "Failed to process request.", http.StatusInternalServerError)
+	http.Error(w, errorBody(ctx, "Failed to process request."), http.StatusInternalServerError)
+}
+
+// errorBody adds the request ID to the message of an error response, so callers can quote it when reporting a
+// failure. The message stays on the first line.
+func errorBody(ctx context.Context, message string) string {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return message + "\nRequest ID: " + id
+	}
+	return message
 }
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// FailedRender is a render that failed, as kept by WithRecentFailures for the admin listener.
+type FailedRender struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Engine    string    `json:"engine,omitempty"`
+	ClientID  string    `json:"client_id,omitempty"`
+	Status    int       `json:"status"`
+	// Error is the full cause, including what the renderer wrote to stderr, unlike the message the client got.
+	Error       string       `json:"error"`
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+	Inputs      []AuditInput `json:"inputs,omitempty"`
+	DurationMS  int64        `json:"duration_ms"`
+	RenderMS    int64        `json:"render_ms,omitempty"`
+	CPUUserMS   int64        `json:"cpu_user_ms,omitempty"`
+	CPUSystemMS int64        `json:"cpu_system_ms,omitempty"`
+	MaxRSSBytes int64        `json:"max_rss_bytes,omitempty"`
+}
+
+// failureLog is a ring buffer of the most recent failed renders.
+type failureLog struct {
+	mu      sync.Mutex
+	entries []FailedRender
+	next    int
+	full    bool
+}
+
+func newFailureLog(capacity int) *failureLog {
+	return &failureLog{entries: make([]FailedRender, capacity)}
+}
+
+func (l *failureLog) add(failure FailedRender) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[l.next] = failure
+	l.next = (l.next + 1) % len(l.entries)
+	l.full = l.full || l.next == 0
+}
+
+// recent returns the failures kept, newest first.
+func (l *failureLog) recent() []FailedRender {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count := l.next
+	if l.full {
+		count = len(l.entries)
+	}
+	failures := make([]FailedRender, 0, count)
+	for i := range count {
+		failures = append(failures, l.entries[(l.next-1-i+len(l.entries))%len(l.entries)])
+	}
+	return failures
+}
+
+// WithRecentFailures keeps the last capacity failed renders in memory, with their error, diagnostics and
+// timing, to be inspected with RecentFailuresHandler.
+func WithRecentFailures(capacity int) ServiceOption {
+	return func(s *Service) {
+		if capacity > 0 {
+			s.failures = newFailureLog(capacity)
+		}
+	}
+}
+
+// RecentFailuresHandler serves the kept failed renders as JSON, newest first. It is meant for the admin
+// listener: the errors include details that are not shown to clients.
+func (s *Service) RecentFailuresHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failures := []FailedRender{}
+		if s.failures != nil {
+			failures = s.failures.recent()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(failures)
+	})
+}
+
+// recordFailure keeps a failed render in the recent failures.
+func (s *Service) recordFailure(ctx context.Context, start time.Time, summary *renderSummary, err error) {
+	if s.failures == nil || err == nil {
+		return
+	}
+	failure := FailedRender{
+		Time:       start.UTC(),
+		RequestID:  RequestIDFromContext(ctx),
+		Status:     http.StatusInternalServerError,
+		Error:      err.Error(),
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	var appErr *AppError
+	if errors.As(err, &appErr) {
+		failure.Status = appErr.StatusCode
+	}
+	if principal := PrincipalFromContext(ctx); principal != nil {
+		failure.ClientID = principal.ClientID
+	}
+	if summary != nil {
+		failure.Engine = summary.Engine
+		failure.Diagnostics = summary.Diagnostics
+		for _, part := range summary.Parts {
+			failure.Inputs = append(failure.Inputs, AuditInput(part))
+		}
+		failure.RenderMS = summary.Usage.WallTime.Milliseconds()
+		failure.CPUUserMS = summary.Usage.UserCPU.Milliseconds()
+		failure.CPUSystemMS = summary.Usage.SystemCPU.Milliseconds()
+		failure.MaxRSSBytes = summary.Usage.MaxRSSBytes
+	}
+	s.failures.add(failure)
+}
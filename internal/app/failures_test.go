@@ -0,0 +1,61 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailureLogKeepsMostRecentFirst(t *testing.T) {
+	log := newFailureLog(3)
+	assert.Empty(t, log.recent())
+
+	for _, engine := range []string{"a", "b", "c", "d"} {
+		log.add(FailedRender{Engine: engine})
+	}
+
+	var engines []string
+	for _, failure := range log.recent() {
+		engines = append(engines, failure.Engine)
+	}
+	assert.Equal(t, []string{"d", "c", "b"}, engines)
+}
+
+func TestRenderPDFRecordsRecentFailures(t *testing.T) {
+	runner := &fakeRunner{
+		runErr: errors.New("weasyprint failed: exit status 1: Traceback"),
+		result: RenderResult{Usage: testUsage, Diagnostics: []Diagnostic{{Level: DiagnosticLevelWarning, Message: "Failed to load image"}}},
+	}
+	svc := newTestService(fakeValidator{}, runner, WithRecentFailures(10))
+
+	rec := postMultipart(t, svc, "/pdf", []testPart{testHTMLPart})
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	failuresRec := httptest.NewRecorder()
+	svc.RecentFailuresHandler().ServeHTTP(failuresRec, httptest.NewRequest(http.MethodGet, "/debug/failures", nil))
+	var failures []FailedRender
+	assert.NoError(t, json.Unmarshal(failuresRec.Body.Bytes(), &failures))
+	if assert.Len(t, failures, 1) {
+		failure := failures[0]
+		assert.Equal(t, "weasyprint", failure.Engine)
+		assert.Equal(t, "test-client", failure.ClientID)
+		assert.Equal(t, rec.Header().Get(RequestIDHeader), failure.RequestID)
+		assert.Contains(t, failure.Error, "Traceback")
+		assert.Equal(t, runner.result.Diagnostics, failure.Diagnostics)
+		assert.Equal(t, testUsage.WallTime.Milliseconds(), failure.RenderMS)
+		assert.Len(t, failure.Inputs, 1)
+	}
+}
+
+func TestRecentFailuresHandlerWithoutFailureLog(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{})
+
+	rec := httptest.NewRecorder()
+	svc.RecentFailuresHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/failures", nil))
+
+	assert.Equal(t, "[]\n", rec.Body.String())
+}
@@ -0,0 +1,190 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	readinessTimeout  = 5 * time.Second
+	readinessCacheTTL = 15 * time.Second
+)
+
+// HealthChecker is implemented by dependencies that can report whether they are able to serve.
+// The validator and runner passed to NewService are checked automatically when they implement it.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+type HealthCheckFunc func(ctx context.Context) error
+
+func (f HealthCheckFunc) CheckHealth(ctx context.Context) error {
+	return f(ctx)
+}
+
+type readinessCheck struct {
+	name    string
+	checker HealthChecker
+}
+
+type CheckResult struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+type ReadinessReport struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// WithReadinessCheck adds a named dependency check to GET /readyz.
+func WithReadinessCheck(name string, checker HealthChecker) ServiceOption {
+	return func(s *Service) {
+		s.readinessChecks = append(s.readinessChecks, readinessCheck{name: name, checker: checker})
+	}
+}
+
+func (s *Service) defaultReadinessChecks() []readinessCheck {
+	checks := []readinessCheck{
+		{name: "draining", checker: HealthCheckFunc(s.checkNotDraining)},
+		{name: "render_queue", checker: HealthCheckFunc(s.checkQueue)},
+		{name: "workdir", checker: HealthCheckFunc(s.checkWorkDir)},
+	}
+	if checker, ok := s.validator.(HealthChecker); ok {
+		checks = append(checks, readinessCheck{name: "jwks", checker: checker})
+	}
+	for _, engine := range s.engines.Engines() {
+		if checker, ok := engine.Runner.(HealthChecker); ok {
+			checks = append(checks, readinessCheck{name: "engine:" + engine.Name, checker: newCachedHealthCheck(checker, readinessCacheTTL)})
+		}
+	}
+	return checks
+}
+
+func (s *Service) livez(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("OK"))
+}
+
+func (s *Service) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	report := s.checkReadiness(ctx)
+
+	status := http.StatusOK
+	if report.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+func (s *Service) checkReadiness(ctx context.Context) ReadinessReport {
+	checks := s.readinessChecks
+	results := make([]CheckResult, len(checks))
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Go(func() {
+			start := time.Now()
+			err := check.checker.CheckHealth(ctx)
+			results[i] = CheckResult{Name: check.name, Status: "ok", DurationMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				results[i].Status = "error"
+				results[i].Error = err.Error()
+			}
+		})
+	}
+	wg.Wait()
+
+	report := ReadinessReport{Status: "ready", Checks: results}
+	for _, result := range results {
+		if result.Status != "ok" {
+			report.Status = "not_ready"
+			s.obs.Logger().WarnContext(ctx, "readiness check failed", "check", result.Name, "cause", result.Error)
+		}
+	}
+	return report
+}
+
+func (s *Service) checkNotDraining(context.Context) error {
+	if s.draining.Load() {
+		return ErrShuttingDown
+	}
+	return nil
+}
+
+func (s *Service) checkQueue(context.Context) error {
+	stats := s.queue.stats()
+	if stats.Active >= stats.MaxConcurrent && stats.Waiting >= stats.MaxQueued {
+		return fmt.Errorf("%w: %d active, %d waiting", ErrQueueFull, stats.Active, stats.Waiting)
+	}
+	return nil
+}
+
+// checkWorkDir verifies that request workspaces can be created and that enough disk space is left for them.
+func (s *Service) checkWorkDir(context.Context) error {
+	dir := os.TempDir()
+
+	probe, err := os.CreateTemp(dir, "pdf-service-readyz-*")
+	if err != nil {
+		return fmt.Errorf("work directory %s is not writable: %w", dir, err)
+	}
+	_ = probe.Close()
+	_ = os.Remove(probe.Name())
+
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		return fmt.Errorf("failed to stat work directory %s: %w", dir, err)
+	}
+	free := int64(stat.Bavail) * int64(stat.Bsize)
+	if minFree := s.currentConfig().MinFreeWorkDirBytes; free < minFree {
+		return fmt.Errorf("work directory %s has %d bytes free, need %d", dir, free, minFree)
+	}
+	return nil
+}
+
+// cachedHealthCheck limits how often an expensive check (such as starting processes) runs.
+type cachedHealthCheck struct {
+	checker HealthChecker
+	ttl     time.Duration
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+func newCachedHealthCheck(checker HealthChecker, ttl time.Duration) *cachedHealthCheck {
+	return &cachedHealthCheck{checker: checker, ttl: ttl}
+}
+
+func (c *cachedHealthCheck) CheckHealth(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.checkedAt.IsZero() && time.Since(c.checkedAt) < c.ttl {
+		return c.err
+	}
+
+	err := c.checker.CheckHealth(ctx)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		// Do not cache a result that only reflects this probe's deadline.
+		return err
+	}
+	c.checkedAt = time.Now()
+	c.err = err
+	return err
+}
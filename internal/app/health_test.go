@@ -0,0 +1,124 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadyzReportsEveryCheck(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{})
+
+	rec := httptest.NewRecorder()
+	svc.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	report := decodeReadinessReport(t, rec)
+	assert.Equal(t, "ready", report.Status)
+	assert.ElementsMatch(t, []string{"draining", "render_queue", "workdir"}, checkNames(report))
+}
+
+func TestReadyzFailsWhenDependencyFails(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{},
+		WithReadinessCheck("dependency", HealthCheckFunc(func(context.Context) error {
+			return errors.New("unreachable")
+		})),
+	)
+
+	rec := httptest.NewRecorder()
+	svc.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	report := decodeReadinessReport(t, rec)
+	assert.Equal(t, "not_ready", report.Status)
+	assert.Contains(t, report.Checks, CheckResult{Name: "dependency", Status: "error", Error: "unreachable"})
+}
+
+func TestReadyzFailsWhenQueueSaturated(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{})
+	release, err := svc.queue.acquire(context.Background())
+	assert.NoError(t, err)
+	defer release()
+
+	rec := httptest.NewRecorder()
+	svc.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestReadyzFailsWhenWorkDirIsFull(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{})
+	config := svc.currentConfig()
+	config.MinFreeWorkDirBytes = math.MaxInt64
+	svc.SetConfig(config)
+
+	rec := httptest.NewRecorder()
+	svc.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestLivezStaysUpWhileDraining(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{})
+	svc.Drain()
+
+	for _, path := range []string{"/livez", "/healthcheck"} {
+		rec := httptest.NewRecorder()
+		svc.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+	}
+}
+
+func TestReadyzChecksRunnerAndValidator(t *testing.T) {
+	runner := WeasyprintRunner{BwrapPath: "missing-bwrap-binary", WeasyprintPath: "weasyprint"}
+	svc := newTestService(&OIDCValidator{}, runner)
+
+	report := svc.checkReadiness(context.Background())
+
+	assert.Equal(t, "not_ready", report.Status)
+	for _, result := range report.Checks {
+		switch result.Name {
+		case "jwks", "engine:weasyprint":
+			assert.Equal(t, "error", result.Status, result.Name)
+		}
+	}
+	assert.Contains(t, checkNames(report), "jwks")
+	assert.Contains(t, checkNames(report), "engine:weasyprint")
+}
+
+func TestCachedHealthCheckReusesResult(t *testing.T) {
+	calls := 0
+	check := newCachedHealthCheck(HealthCheckFunc(func(context.Context) error {
+		calls++
+		return nil
+	}), time.Minute)
+
+	assert.NoError(t, check.CheckHealth(context.Background()))
+	assert.NoError(t, check.CheckHealth(context.Background()))
+	assert.Equal(t, 1, calls)
+}
+
+func decodeReadinessReport(t *testing.T, rec *httptest.ResponseRecorder) ReadinessReport {
+	t.Helper()
+	var report ReadinessReport
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	for i := range report.Checks {
+		report.Checks[i].DurationMS = 0
+	}
+	return report
+}
+
+func checkNames(report ReadinessReport) []string {
+	names := make([]string, 0, len(report.Checks))
+	for _, result := range report.Checks {
+		names = append(names, result.Name)
+	}
+	return names
+}
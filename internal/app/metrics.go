@@ -0,0 +1,96 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Reasons a request failed authentication, recorded on pdf.auth.failures.
+const (
+	AuthFailureMissingCredentials = "missing_credentials"
+	AuthFailureInvalidScheme      = "invalid_scheme"
+	AuthFailureInvalidCredentials = "invalid_credentials"
+	AuthFailureSignatureMismatch  = "signature_mismatch"
+	AuthFailureBodyDigestMismatch = "body_digest_mismatch"
+	AuthFailureForbidden          = "forbidden"
+)
+
+// serviceMetrics are the instruments of the render path. Renders are recorded by engine and outcome, and
+// the resources they used also by client, so heavy producers and expensive templates can be found.
+type serviceMetrics struct {
+	renderDuration  metric.Float64Histogram
+	renderCPUTime   metric.Float64Histogram
+	renderMemory    metric.Int64Histogram
+	outputBytes     metric.Int64Histogram
+	requestBytes    metric.Int64Histogram
+	queueWait       metric.Float64Histogram
+	activeSandboxes metric.Int64UpDownCounter
+	authFailures    metric.Int64Counter
+}
+
+func newServiceMetrics(meter metric.Meter) *serviceMetrics {
+	m := &serviceMetrics{}
+	m.renderDuration, _ = meter.Float64Histogram("pdf.render.duration", metric.WithUnit("s"),
+		metric.WithDescription("Wall time of renders in the sandbox, by engine and outcome."))
+	m.renderCPUTime, _ = meter.Float64Histogram("pdf.render.cpu_time", metric.WithUnit("s"),
+		metric.WithDescription("CPU time of renders, by cpu.mode (user or system)."))
+	m.renderMemory, _ = meter.Int64Histogram("pdf.render.memory", metric.WithUnit("By"),
+		metric.WithDescription("Peak resident memory of renders."))
+	m.outputBytes, _ = meter.Int64Histogram("pdf.render.output_size", metric.WithUnit("By"),
+		metric.WithDescription("Size of the rendered PDFs."))
+	m.requestBytes, _ = meter.Int64Histogram("pdf.request.size", metric.WithUnit("By"),
+		metric.WithDescription("Total size of the parts of render requests, by engine."))
+	m.queueWait, _ = meter.Float64Histogram("pdf.queue.wait_time", metric.WithUnit("s"),
+		metric.WithDescription("Time renders waited for a sandbox slot, by result (acquired, rejected or timeout)."))
+	m.activeSandboxes, _ = meter.Int64UpDownCounter("pdf.sandboxes.active",
+		metric.WithDescription("Renders running in a sandbox, including shadow renders, by engine."))
+	m.authFailures, _ = meter.Int64Counter("pdf.auth.failures",
+		metric.WithDescription("Requests rejected by authentication or authorization, by reason."))
+	return m
+}
+
+// recordQueueWait records how long a render waited for a slot, and whether it got one.
+func (m *serviceMetrics) recordQueueWait(ctx context.Context, engine string, started time.Time, err error) {
+	result := "acquired"
+	switch {
+	case errors.Is(err, ErrQueueFull), errors.Is(err, ErrShuttingDown):
+		result = "rejected"
+	case err != nil:
+		result = "timeout"
+	}
+	m.queueWait.Record(ctx, time.Since(started).Seconds(),
+		metric.WithAttributes(attribute.String("engine", engine), attribute.String("result", result)))
+}
+
+// startSandbox counts a render as running on engine until the returned function is called.
+func (m *serviceMetrics) startSandbox(ctx context.Context, engine string) func() {
+	attributes := metric.WithAttributes(attribute.String("engine", engine))
+	m.activeSandboxes.Add(ctx, 1, attributes)
+	return func() { m.activeSandboxes.Add(ctx, -1, attributes) }
+}
+
+func (m *serviceMetrics) recordAuthFailure(ctx context.Context, reason string) {
+	m.authFailures.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", reason)))
+}
+
+// authFailureReason classifies an error returned by authentication.
+func authFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrForbidden):
+		return AuthFailureForbidden
+	case errors.Is(err, errMissingAuthorization):
+		return AuthFailureMissingCredentials
+	case errors.Is(err, errInvalidAuthorizationScheme):
+		return AuthFailureInvalidScheme
+	case errors.Is(err, ErrSignatureMismatch):
+		return AuthFailureSignatureMismatch
+	case errors.Is(err, ErrBodyDigestMismatch):
+		return AuthFailureBodyDigestMismatch
+	default:
+		return AuthFailureInvalidCredentials
+	}
+}
@@ -0,0 +1,128 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestRenderPDFRecordsMetrics(t *testing.T) {
+	obs := NewMockObservabilityProvider()
+	runner := &fakeRunner{output: []byte("%PDF-1.7"), result: RenderResult{Usage: testUsage}}
+	svc := newTestService(fakeValidator{}, runner, withTestObservability(obs))
+
+	rec := postMultipart(t, svc, "/pdf", []testPart{testHTMLPart})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	metrics := obs.Metrics()
+	success := attribute.NewSet(attribute.String("engine", "weasyprint"), attribute.String("client_id", "test-client"),
+		attribute.String("outcome", "success"))
+	assert.Equal(t, uint64(1), histogramCount(t, metrics, "pdf.render.duration", success))
+	assert.Equal(t, float64(8), histogramSum(t, metrics, "pdf.render.output_size", success))
+	assert.Equal(t, float64(len(testHTMLPart.content)),
+		histogramSum(t, metrics, "pdf.request.size", attribute.NewSet(attribute.String("engine", "weasyprint"))))
+	assert.Equal(t, uint64(1), histogramCount(t, metrics, "pdf.queue.wait_time",
+		attribute.NewSet(attribute.String("engine", "weasyprint"), attribute.String("result", "acquired"))))
+	assert.Equal(t, int64(0), sumValue(t, metrics, "pdf.sandboxes.active", attribute.NewSet(attribute.String("engine", "weasyprint"))))
+}
+
+func TestRequireAuthRecordsFailureReasons(t *testing.T) {
+	obs := NewMockObservabilityProvider()
+	svc := newTestService(fakeValidator{err: fmt.Errorf("%w: required scope missing", ErrForbidden)}, &fakeRunner{},
+		withTestObservability(obs))
+
+	for _, authorization := range []string{"", "Basic dXNlcjpwYXNz", "Bearer token"} {
+		req := httptest.NewRequest(http.MethodGet, "/engines", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		svc.Routes().ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	metrics := obs.Metrics()
+	for _, reason := range []string{AuthFailureMissingCredentials, AuthFailureInvalidScheme, AuthFailureForbidden} {
+		assert.Equal(t, int64(1), sumValue(t, metrics, "pdf.auth.failures", attribute.NewSet(attribute.String("reason", reason))), reason)
+	}
+}
+
+func TestOIDCValidatorRecordsJWKSRefreshes(t *testing.T) {
+	obs := NewMockObservabilityProvider()
+	issuer := newTestIssuer(t)
+	validator, err := NewOIDCValidator(context.Background(), []string{issuer.URL()}, "api", "pdf#create", obs)
+	assert.NoError(t, err)
+
+	_, err = validator.FetchIssuers(context.Background(), []string{issuer.URL(), "http://127.0.0.1:1"})
+	assert.Error(t, err)
+
+	metrics := obs.Metrics()
+	assert.Equal(t, int64(2), sumValue(t, metrics, "pdf.auth.jwks_refreshes",
+		attribute.NewSet(attribute.String("issuer", normalizeIssuer(issuer.URL())), attribute.String("result", "success"))))
+	assert.Equal(t, int64(1), sumValue(t, metrics, "pdf.auth.jwks_refreshes",
+		attribute.NewSet(attribute.String("issuer", "http://127.0.0.1:1"), attribute.String("result", "failure"))))
+}
+
+func findMetric(t *testing.T, metrics metricdata.ResourceMetrics, name string) metricdata.Aggregation {
+	t.Helper()
+	for _, scope := range metrics.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if m.Name == name {
+				return m.Data
+			}
+		}
+	}
+	t.Fatalf("metric %s not recorded", name)
+	return nil
+}
+
+func sumValue(t *testing.T, metrics metricdata.ResourceMetrics, name string, attributes attribute.Set) int64 {
+	t.Helper()
+	sum, ok := findMetric(t, metrics, name).(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("metric %s is not an int64 sum", name)
+	}
+	for _, point := range sum.DataPoints {
+		if point.Attributes.Equals(&attributes) {
+			return point.Value
+		}
+	}
+	t.Fatalf("metric %s has no data point for %v", name, attributes.ToSlice())
+	return 0
+}
+
+func histogramCount(t *testing.T, metrics metricdata.ResourceMetrics, name string, attributes attribute.Set) uint64 {
+	t.Helper()
+	count, _ := histogramPoint(t, metrics, name, attributes)
+	return count
+}
+
+func histogramSum(t *testing.T, metrics metricdata.ResourceMetrics, name string, attributes attribute.Set) float64 {
+	t.Helper()
+	_, sum := histogramPoint(t, metrics, name, attributes)
+	return sum
+}
+
+func histogramPoint(t *testing.T, metrics metricdata.ResourceMetrics, name string, attributes attribute.Set) (uint64, float64) {
+	t.Helper()
+	switch histogram := findMetric(t, metrics, name).(type) {
+	case metricdata.Histogram[float64]:
+		for _, point := range histogram.DataPoints {
+			if point.Attributes.Equals(&attributes) {
+				return point.Count, point.Sum
+			}
+		}
+	case metricdata.Histogram[int64]:
+		for _, point := range histogram.DataPoints {
+			if point.Attributes.Equals(&attributes) {
+				return point.Count, float64(point.Sum)
+			}
+		}
+	}
+	t.Fatalf("metric %s has no histogram data point for %v", name, attributes.ToSlice())
+	return 0, 0
+}
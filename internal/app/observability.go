@@ -1,25 +1,85 @@
 package app
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
 	"os"
+
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 )
 
+// instrumentationName identifies the service's own metrics and spans.
+const instrumentationName = "github.com/bcc-code/pdf-service"
+
 type Observability interface {
 	Logger() *slog.Logger
+	// Meter creates the service's instruments.
+	Meter() metric.Meter
 	HttpClient(base http.RoundTripper) *http.Client
 	HttpHandler(operation string, handler http.Handler) http.Handler
 	Shutdown()
 }
 
+// ObservabilityOption configures NewObservabilityProvider and NewMockObservabilityProvider.
+type ObservabilityOption func(*observabilityOptions)
+
+type observabilityOptions struct {
+	readers  []sdkmetric.Reader
+	jsonLogs bool
+}
+
+// WithJSONLogs makes the mock provider log JSON lines instead of text, so local logs can be machine-read.
+// The OpenTelemetry provider exports its logs and ignores it.
+func WithJSONLogs() ObservabilityOption {
+	return func(o *observabilityOptions) {
+		o.jsonLogs = true
+	}
+}
+
+// WithPrometheus also exposes the metrics through exporter.
+func WithPrometheus(exporter *PrometheusExporter) ObservabilityOption {
+	return func(o *observabilityOptions) {
+		o.readers = append(o.readers, exporter.reader)
+	}
+}
+
+func newObservabilityOptions(opts []ObservabilityOption) observabilityOptions {
+	var options observabilityOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// meterProviderOptions returns the readers configured by opts as MeterProvider options, followed by extra.
+func meterProviderOptions(opts []ObservabilityOption, extra ...sdkmetric.Option) []sdkmetric.Option {
+	for _, reader := range newObservabilityOptions(opts).readers {
+		extra = append(extra, sdkmetric.WithReader(reader))
+	}
+	return extra
+}
+
+// MockObservabilityProvider logs to stderr and keeps metrics in memory, where Metrics reads them.
 type MockObservabilityProvider struct {
 	logger *slog.Logger
+	reader *sdkmetric.ManualReader
+	mp     *sdkmetric.MeterProvider
 }
 
-func NewMockObservabilityProvider() *MockObservabilityProvider {
+func NewMockObservabilityProvider(opts ...ObservabilityOption) *MockObservabilityProvider {
+	reader := sdkmetric.NewManualReader()
+	handlerOptions := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, handlerOptions)
+	if newObservabilityOptions(opts).jsonLogs {
+		handler = slog.NewJSONHandler(os.Stderr, handlerOptions)
+	}
 	return &MockObservabilityProvider{
-		logger: slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		logger: newRequestIDLogger(handler),
+		reader: reader,
+		mp:     sdkmetric.NewMeterProvider(meterProviderOptions(opts, sdkmetric.WithReader(reader))...),
 	}
 }
 
@@ -27,6 +87,17 @@ func (m *MockObservabilityProvider) Logger() *slog.Logger {
 	return m.logger
 }
 
+func (m *MockObservabilityProvider) Meter() metric.Meter {
+	return m.mp.Meter(instrumentationName)
+}
+
+// Metrics returns everything recorded on the provider's meters so far.
+func (m *MockObservabilityProvider) Metrics() metricdata.ResourceMetrics {
+	var metrics metricdata.ResourceMetrics
+	_ = m.reader.Collect(context.Background(), &metrics)
+	return metrics
+}
+
 func (m *MockObservabilityProvider) HttpClient(base http.RoundTripper) *http.Client {
 	return &http.Client{Transport: base}
 }
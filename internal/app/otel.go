@@ -1,40 +1,105 @@
 package app
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
 
 	"go.opentelemetry.io/contrib/bridges/otelslog"
 	"go.opentelemetry.io/contrib/detectors/gcp"
 
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/propagation"
 	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
 )
 
+const (
+	// TelemetryExporterOTLP sends traces, metrics and logs to an OTLP collector.
+	TelemetryExporterOTLP = "otlp"
+	// TelemetryExporterStdout writes them to stdout as JSON, for local debugging.
+	TelemetryExporterStdout = "stdout"
+	// TelemetryExporterFile appends them to ObservabilityConfig.File as JSON.
+	TelemetryExporterFile = "file"
+)
+
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http/protobuf"
+)
+
+// exportErrorInterval is how often failed exports are reported, so an unreachable collector does not flood
+// the log.
+const exportErrorInterval = time.Minute
+
+// defaultExportTimeout applies when ObservabilityConfig.ExportTimeout is not set.
+const defaultExportTimeout = 10 * time.Second
+
+// ObservabilityConfig selects the exporters and sampling of NewObservabilityProvider. The OTLP endpoint,
+// headers and TLS settings are read by the exporters from the standard OTEL_EXPORTER_OTLP_* variables.
+type ObservabilityConfig struct {
+	ServiceName string
+	// Exporter is TelemetryExporterOTLP, TelemetryExporterStdout or TelemetryExporterFile.
+	Exporter string
+	// OTLPProtocol is OTLPProtocolGRPC or OTLPProtocolHTTP.
+	OTLPProtocol string
+	File         string
+	// SampleRatio is the share of new traces that are sampled. Spans continuing a remote trace follow the
+	// caller's decision.
+	SampleRatio float64
+	// UntracedPaths are request paths that get no spans or HTTP metrics, such as health probes.
+	UntracedPaths []string
+	// ExportTimeout bounds every export and the flush at shutdown.
+	ExportTimeout time.Duration
+}
+
 type ObservabilityProvider struct {
-	serviceName string
-	logger      *slog.Logger
-	lp          *sdklog.LoggerProvider
-	tp          *sdktrace.TracerProvider
-	propagator  propagation.TextMapPropagator
+	serviceName   string
+	logger        *slog.Logger
+	lp            *sdklog.LoggerProvider
+	tp            *sdktrace.TracerProvider
+	mp            *sdkmetric.MeterProvider
+	propagator    propagation.TextMapPropagator
+	untracedPaths []string
+	exportTimeout time.Duration
+	file          io.Closer
 }
 
 func (t *ObservabilityProvider) Logger() *slog.Logger {
 	return t.logger
 }
 
+func (t *ObservabilityProvider) Meter() metric.Meter {
+	return t.mp.Meter(instrumentationName)
+}
+
 func (t *ObservabilityProvider) HttpClient(base http.RoundTripper) *http.Client {
 	return &http.Client{
 		Transport: otelhttp.NewTransport(base,
 			otelhttp.WithTracerProvider(t.tp),
+			otelhttp.WithMeterProvider(t.mp),
 			otelhttp.WithPropagators(t.propagator)),
 	}
 }
@@ -42,57 +107,193 @@ func (t *ObservabilityProvider) HttpClient(base http.RoundTripper) *http.Client
 func (t *ObservabilityProvider) HttpHandler(operation string, h http.Handler) http.Handler {
 	return otelhttp.NewHandler(h, operation,
 		otelhttp.WithTracerProvider(t.tp),
-		otelhttp.WithPropagators(t.propagator))
+		otelhttp.WithMeterProvider(t.mp),
+		otelhttp.WithPropagators(t.propagator),
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return !slices.Contains(t.untracedPaths, r.URL.Path)
+		}))
 }
 
+// Shutdown flushes the exporters. It gives up after the export timeout, so an unreachable collector does not
+// hold up the exit.
 func (t *ObservabilityProvider) Shutdown() {
-	_ = t.lp.Shutdown(context.Background())
-	_ = t.tp.Shutdown(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), t.exportTimeout)
+	defer cancel()
+	if t.lp != nil {
+		_ = t.lp.Shutdown(ctx)
+	}
+	_ = t.tp.Shutdown(ctx)
+	_ = t.mp.Shutdown(ctx)
+	if t.file != nil {
+		_ = t.file.Close()
+	}
 }
 
-func NewObservabilityProvider(serviceName string) *ObservabilityProvider {
+// NewObservabilityProvider creates the exporters selected by config. Exporters connect lazily, so an
+// unreachable collector does not fail it: failed exports are dropped and logged to stderr at most once per
+// exportErrorInterval. A resource detector that fails only leaves its attributes out.
+func NewObservabilityProvider(config ObservabilityConfig, opts ...ObservabilityOption) (*ObservabilityProvider, error) {
 	ctx := context.Background()
-
-	logExporter, err := otlploggrpc.New(ctx)
-	if err != nil {
-		panic(fmt.Errorf("otlploggrpc.New: %w", err))
-	}
-
-	exporter, err := otlptracegrpc.New(ctx)
-	if err != nil {
-		panic(fmt.Errorf("otlptracegrpc.New: %w", err))
-	}
+	config.ExportTimeout = cmp.Or(config.ExportTimeout, defaultExportTimeout)
+	stderr := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	otel.SetErrorHandler(&exportErrorHandler{logger: stderr, interval: exportErrorInterval})
 
 	res, err := resource.New(ctx,
 		resource.WithDetectors(gcp.NewDetector()),
 		resource.WithTelemetrySDK(),
 		resource.WithFromEnv(),
+		resource.WithAttributes(attribute.String("service.name", config.ServiceName)),
 	)
-
 	if err != nil {
-		panic(fmt.Errorf("cannot create resource: %w", err))
+		if res == nil {
+			return nil, fmt.Errorf("cannot create resource: %w", err)
+		}
+		stderr.Warn("telemetry resource is incomplete", "cause", err)
 	}
 
-	lp := sdklog.NewLoggerProvider(
-		sdklog.WithProcessor(sdklog.NewBatchProcessor(logExporter)),
-		sdklog.WithResource(res),
-	)
+	provider := &ObservabilityProvider{
+		serviceName:   config.ServiceName,
+		propagator:    BCCPropagator{},
+		untracedPaths: config.UntracedPaths,
+		exportTimeout: config.ExportTimeout,
+	}
+	sampler := sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio)))
 
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(exporter),
-		sdktrace.WithResource(res),
-		sdktrace.WithSampler(sdktrace.AlwaysSample()),
-	)
-	propagator := BCCPropagator{}
-	logger := slog.New(otelslog.NewHandler(serviceName, otelslog.WithLoggerProvider(lp)))
-
-	return &ObservabilityProvider{
-		serviceName: serviceName,
-		logger:      logger,
-		lp:          lp,
-		tp:          tp,
-		propagator:  propagator,
+	switch config.Exporter {
+	case TelemetryExporterOTLP:
+		exporters, err := newOTLPExporters(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		provider.lp = sdklog.NewLoggerProvider(
+			sdklog.WithProcessor(sdklog.NewBatchProcessor(exporters.logs, sdklog.WithExportTimeout(config.ExportTimeout))),
+			sdklog.WithResource(res),
+		)
+		provider.tp = sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporters.traces, sdktrace.WithExportTimeout(config.ExportTimeout)),
+			sdktrace.WithResource(res),
+			sampler,
+		)
+		provider.mp = sdkmetric.NewMeterProvider(meterProviderOptions(opts,
+			sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporters.metrics, sdkmetric.WithTimeout(config.ExportTimeout))),
+			sdkmetric.WithResource(res),
+		)...)
+		provider.logger = newRequestIDLogger(otelslog.NewHandler(config.ServiceName, otelslog.WithLoggerProvider(provider.lp)))
+
+	case TelemetryExporterStdout, TelemetryExporterFile:
+		var output io.Writer = os.Stdout
+		if config.Exporter == TelemetryExporterFile {
+			file, err := os.OpenFile(config.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+			if err != nil {
+				return nil, fmt.Errorf("cannot open telemetry file: %w", err)
+			}
+			provider.file = file
+			output = file
+		}
+		// Concurrent exports share the writer, so lines are written whole.
+		output = &syncWriter{w: output}
+		traceExporter, err := stdouttrace.New(stdouttrace.WithWriter(output))
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("stdouttrace.New: %w", err), provider.closeFile())
+		}
+		metricExporter, err := stdoutmetric.New(stdoutmetric.WithWriter(output))
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("stdoutmetric.New: %w", err), provider.closeFile())
+		}
+		provider.tp = sdktrace.NewTracerProvider(sdktrace.WithSyncer(traceExporter), sdktrace.WithResource(res), sampler)
+		provider.mp = sdkmetric.NewMeterProvider(meterProviderOptions(opts,
+			sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
+			sdkmetric.WithResource(res),
+		)...)
+		// Logs are written by slog directly, next to the spans and metrics.
+		provider.logger = newRequestIDLogger(slog.NewJSONHandler(output, &slog.HandlerOptions{Level: slog.LevelDebug})).
+			With("service.name", config.ServiceName)
+
+	default:
+		return nil, fmt.Errorf("unknown telemetry exporter %q", config.Exporter)
+	}
+
+	return provider, nil
+}
+
+func (t *ObservabilityProvider) closeFile() error {
+	if t.file == nil {
+		return nil
+	}
+	return t.file.Close()
+}
+
+type otlpExporters struct {
+	traces  sdktrace.SpanExporter
+	metrics sdkmetric.Exporter
+	logs    sdklog.Exporter
+}
+
+// newOTLPExporters creates the trace, metric and log exporters for config.OTLPProtocol.
+func newOTLPExporters(ctx context.Context, config ObservabilityConfig) (exporters otlpExporters, err error) {
+	switch config.OTLPProtocol {
+	case OTLPProtocolGRPC:
+		if exporters.traces, err = otlptracegrpc.New(ctx, otlptracegrpc.WithTimeout(config.ExportTimeout)); err != nil {
+			return exporters, fmt.Errorf("otlptracegrpc.New: %w", err)
+		}
+		if exporters.metrics, err = otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithTimeout(config.ExportTimeout)); err != nil {
+			return exporters, fmt.Errorf("otlpmetricgrpc.New: %w", err)
+		}
+		if exporters.logs, err = otlploggrpc.New(ctx, otlploggrpc.WithTimeout(config.ExportTimeout)); err != nil {
+			return exporters, fmt.Errorf("otlploggrpc.New: %w", err)
+		}
+	case OTLPProtocolHTTP:
+		if exporters.traces, err = otlptracehttp.New(ctx, otlptracehttp.WithTimeout(config.ExportTimeout)); err != nil {
+			return exporters, fmt.Errorf("otlptracehttp.New: %w", err)
+		}
+		if exporters.metrics, err = otlpmetrichttp.New(ctx, otlpmetrichttp.WithTimeout(config.ExportTimeout)); err != nil {
+			return exporters, fmt.Errorf("otlpmetrichttp.New: %w", err)
+		}
+		if exporters.logs, err = otlploghttp.New(ctx, otlploghttp.WithTimeout(config.ExportTimeout)); err != nil {
+			return exporters, fmt.Errorf("otlploghttp.New: %w", err)
+		}
+	default:
+		return exporters, fmt.Errorf("unknown otlp protocol %q", config.OTLPProtocol)
+	}
+	return exporters, nil
+}
+
+// exportErrorHandler logs the errors of the OpenTelemetry SDK, such as failed exports, at most once per
+// interval, with the number of errors left out since the last one.
+type exportErrorHandler struct {
+	logger   *slog.Logger
+	interval time.Duration
+
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+func (h *exportErrorHandler) Handle(err error) {
+	h.mu.Lock()
+	if time.Since(h.last) < h.interval {
+		h.suppressed++
+		h.mu.Unlock()
+		return
 	}
+	suppressed := h.suppressed
+	h.last = time.Now()
+	h.suppressed = 0
+	h.mu.Unlock()
+
+	h.logger.Warn("telemetry export failed", "cause", err, "suppressed", suppressed)
+}
+
+// syncWriter serializes writes to w.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
 }
 
 type BCCPropagator struct{}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObservabilityProviderWritesFileAndSkipsUntracedPaths(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telemetry.jsonl")
+	provider, err := NewObservabilityProvider(ObservabilityConfig{
+		ServiceName:   "pdf-service-test",
+		Exporter:      TelemetryExporterFile,
+		File:          path,
+		SampleRatio:   1,
+		UntracedPaths: []string{"/healthcheck"},
+	})
+	assert.NoError(t, err)
+	handler := func(operation string) http.Handler {
+		return provider.HttpHandler(operation, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	}
+
+	handler("POST /pdf").ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/pdf", nil))
+	handler("GET /healthcheck").ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthcheck", nil))
+	provider.Logger().Info("render finished")
+	provider.Shutdown()
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"Name":"POST /pdf"`)
+	assert.NotContains(t, string(data), "GET /healthcheck")
+	assert.Contains(t, string(data), `"msg":"render finished"`)
+}
+
+func TestObservabilityProviderSamplesByRatioUnlessTheParentIsSampled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telemetry.jsonl")
+	provider, err := NewObservabilityProvider(ObservabilityConfig{
+		ServiceName: "pdf-service-test",
+		Exporter:    TelemetryExporterFile,
+		File:        path,
+		SampleRatio: 0,
+	})
+	assert.NoError(t, err)
+	handler := provider.HttpHandler("POST /pdf", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/pdf", nil))
+	sampled := httptest.NewRequest(http.MethodPost, "/pdf", nil)
+	sampled.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), sampled)
+	provider.Shutdown()
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), `"Name":"POST /pdf"`))
+	assert.Contains(t, string(data), "4bf92f3577b34da6a3ce929d0e0e4736")
+}
+
+func TestObservabilityProviderRejectsUnknownExporter(t *testing.T) {
+	_, err := NewObservabilityProvider(ObservabilityConfig{ServiceName: "pdf-service-test", Exporter: "zipkin"})
+
+	assert.ErrorContains(t, err, `unknown telemetry exporter "zipkin"`)
+}
+
+func TestExportErrorHandlerLimitsLogRate(t *testing.T) {
+	var logs strings.Builder
+	handler := &exportErrorHandler{logger: slog.New(slog.NewTextHandler(&logs, nil)), interval: time.Hour}
+
+	for range 3 {
+		handler.Handle(errors.New("connection refused"))
+	}
+	handler.last = time.Now().Add(-2 * time.Hour)
+	handler.Handle(errors.New("connection refused"))
+
+	assert.Equal(t, 2, strings.Count(logs.String(), "telemetry export failed"))
+	assert.Contains(t, logs.String(), "suppressed=2")
+}
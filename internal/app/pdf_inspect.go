@@ -0,0 +1,80 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+type PDFInfo struct {
+	PageCount int
+	// PageSizes are the media box dimensions of each page.
+	PageSizes []PageSize
+}
+
+// PageSize is a page's width and height in points.
+type PageSize struct {
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// inspectPDF reads document structure from a generated PDF. The parser panics on some malformed
+// input, so panics are converted to errors.
+func inspectPDF(file io.ReaderAt, size int64) (info PDFInfo, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("failed to parse pdf: %v", recovered)
+		}
+	}()
+
+	reader, err := pdf.NewReader(file, size)
+	if err != nil {
+		return info, fmt.Errorf("failed to parse pdf: %w", err)
+	}
+
+	info.PageCount = reader.NumPage()
+	for i := 1; i <= info.PageCount; i++ {
+		box := mediaBox(reader.Page(i))
+		info.PageSizes = append(info.PageSizes, PageSize{
+			Width:  box.Index(2).Float64() - box.Index(0).Float64(),
+			Height: box.Index(3).Float64() - box.Index(1).Float64(),
+		})
+	}
+	return info, nil
+}
+
+// extractPDFText returns the plain text of every page of a PDF. Runs of whitespace are collapsed to a
+// single space, since the extracted line breaks depend on how the text was positioned.
+func extractPDFText(file io.ReaderAt, size int64) (pages []string, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("failed to extract pdf text: %v", recovered)
+		}
+	}()
+
+	reader, err := pdf.NewReader(file, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pdf: %w", err)
+	}
+
+	for i := 1; i <= reader.NumPage(); i++ {
+		text, err := reader.Page(i).GetPlainText(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract text of page %d: %w", i, err)
+		}
+		pages = append(pages, strings.Join(strings.Fields(text), " "))
+	}
+	return pages, nil
+}
+
+// mediaBox returns the MediaBox of a page, which may be inherited from its page tree.
+func mediaBox(page pdf.Page) pdf.Value {
+	for v := page.V; !v.IsNull(); v = v.Key("Parent") {
+		if box := v.Key("MediaBox"); !box.IsNull() {
+			return box
+		}
+	}
+	return pdf.Value{}
+}
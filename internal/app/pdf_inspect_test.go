@@ -0,0 +1,82 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspectPDFCountsPages(t *testing.T) {
+	document := buildTestPDF(3)
+
+	info, err := inspectPDF(bytes.NewReader(document), int64(len(document)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, info.PageCount)
+	assert.Equal(t, []PageSize{{595, 842}, {595, 842}, {595, 842}}, info.PageSizes)
+}
+
+func TestExtractPDFTextReadsEveryPage(t *testing.T) {
+	document := buildTestPDFPages(PageSize{Width: 612, Height: 792}, "Invoice 1001", "Total due")
+
+	pages, err := extractPDFText(bytes.NewReader(document), int64(len(document)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Invoice 1001", "Total due"}, pages)
+}
+
+func TestInspectPDFRejectsGarbage(t *testing.T) {
+	document := []byte("%PDF-1.4 not really a pdf")
+
+	_, err := inspectPDF(bytes.NewReader(document), int64(len(document)))
+
+	assert.Error(t, err)
+}
+
+// buildTestPDF writes a minimal uncompressed PDF with the given number of empty A4 pages.
+func buildTestPDF(pages int) []byte {
+	return buildTestPDFPages(PageSize{Width: 595, Height: 842}, make([]string, pages)...)
+}
+
+// buildTestPDFPages writes a minimal uncompressed PDF with one page of the given size per text.
+func buildTestPDFPages(size PageSize, texts ...string) []byte {
+	const firstPage = 4
+	kids := make([]string, 0, len(texts))
+	for i := range texts {
+		kids = append(kids, fmt.Sprintf("%d 0 R", firstPage+2*i))
+	}
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(texts)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	}
+	for i, text := range texts {
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %g %g] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+				size.Width, size.Height, firstPage+2*i+1),
+		)
+		content := ""
+		if text != "" {
+			content = fmt.Sprintf("BT /F1 12 Tf 72 720 Td (%s) Tj ET", text)
+		}
+		objects = append(objects, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xrefOffset := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xrefOffset)
+	return buf.Bytes()
+}
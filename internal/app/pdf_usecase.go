@@ -2,59 +2,239 @@ package app
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
 	"mime/multipart"
+	"net/http"
 	"os"
 	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const defaultStylesheetPath = "/defaults/default.css"
 
-func (s *Service) generatePDFToWriter(ctx context.Context, reader *multipart.Reader, writer io.Writer) error {
-	workDir, err := os.MkdirTemp("", "pdf-service-*")
-	if err != nil {
-		return NewInternalError("Failed to process request.", err)
-	}
-	defer func() { _ = os.RemoveAll(workDir) }()
+// A 422 response names the limit a render exceeded. RemoteRunner parses it back into a ResourceLimitError.
+const (
+	resourceLimitMessagePrefix = "Document exceeded the "
+	resourceLimitMessageSuffix = " limit for rendering."
+)
+
+const (
+	PartRoleHTML       = "html"
+	PartRoleCSS        = "css"
+	PartRoleAttachment = "attachment"
+	PartRoleAsset      = "asset"
+)
+
+// PartInfo describes one uploaded multipart file.
+type PartInfo struct {
+	FormName string
+	Filename string
+	Role     string
+	Size     int64
+	SHA256   string
+}
+
+// renderSummary describes the inputs and output of a render. It is filled in as far as the render got.
+type renderSummary struct {
+	Engine       string
+	Parts        []PartInfo
+	OutputBytes  int64
+	OutputSHA256 string
+	PageCount    int
+	Diagnostics  []Diagnostic
+	Usage        ResourceUsage
+}
+
+func (s *Service) generatePDFToWriter(ctx context.Context, engine Engine, reader *multipart.Reader, writer io.Writer) (*renderSummary, error) {
+	summary := &renderSummary{Engine: engine.Name}
 
-	root, err := os.OpenRoot(workDir)
+	_, span := startSpan(ctx, "pdf.workspace.setup")
+	workDir, root, output, err := createWorkspace()
+	endSpan(span, err)
 	if err != nil {
-		return NewInternalError("Failed to process request.", err)
+		return summary, NewInternalError("Failed to process request.", err)
 	}
-	defer root.Close()
+	defer func() {
+		_ = root.Close()
+		_ = os.RemoveAll(workDir)
+		_ = output.Close()
+		_ = os.Remove(output.Name())
+	}()
 
 	pp := &PartProcessor{
 		reader: reader,
 		root:   root,
 	}
-	if err := pp.ProcessParts(); err != nil {
-		return err
+	err = pp.ProcessParts(ctx)
+	summary.Parts = pp.parts
+	if err != nil {
+		return summary, err
 	}
 
-	renderCtx, cancel := context.WithTimeout(ctx, s.config.RequestTimeout)
+	if err := verifyRequestBody(ctx); err != nil {
+		s.metrics.recordAuthFailure(ctx, AuthFailureBodyDigestMismatch)
+		return summary, err
+	}
+
+	request := pp.renderRequest(workDir)
+	if capability, ok := unsupportedCapability(engine, pp.parts, request); ok {
+		return summary, NewBadRequestError(fmt.Sprintf("Engine %q does not support %s.", engine.Name, capability), nil)
+	}
+
+	config := s.currentConfig()
+	renderCtx, cancel := context.WithTimeout(ctx, config.RequestTimeout)
 	defer cancel()
 
-	if err := s.runner.GeneratePDF(renderCtx, workDir, pp.htmlFilename, pp.cssFilename, pp.attachmentFilenames, writer); err != nil {
-		return NewInternalError("PDF generation failed.", err)
+	_, span = startSpan(ctx, "pdf.queue.wait", attribute.Int("pdf.queue.waiting", s.queue.stats().Waiting))
+	queued := time.Now()
+	release, err := s.queue.acquire(renderCtx)
+	s.metrics.recordQueueWait(ctx, engine.Name, queued, err)
+	endSpan(span, err)
+	switch {
+	case errors.Is(err, ErrQueueFull), errors.Is(err, ErrShuttingDown):
+		return summary, NewServiceUnavailableError("Service unavailable, retry later.", err)
+	case err != nil:
+		return summary, NewServiceUnavailableError("Timed out waiting for a render slot.", err)
+	}
+	defer release()
+
+	// Runners annotate the sandbox span, for example with the exit code of the renderer process.
+	sandboxCtx, span := startSpan(renderCtx, "pdf.sandbox.run",
+		attribute.String("pdf.engine", engine.Name), attribute.String("pdf.engine.version", engine.Version))
+	stopSandbox := s.metrics.startSandbox(ctx, engine.Name)
+	result, err := engine.Runner.GeneratePDF(sandboxCtx, request, output)
+	stopSandbox()
+	span.SetAttributes(attribute.Int("pdf.diagnostics.count", len(result.Diagnostics)))
+	endSpan(span, err)
+	summary.Usage = result.Usage
+	summary.Diagnostics = result.Diagnostics
+	// The diagnostics also explain failed renders, so they are set before the outcome is known.
+	response, isResponse := writer.(http.ResponseWriter)
+	if isResponse {
+		setDiagnosticsHeaders(response.Header(), result.Diagnostics)
+	}
+	if err != nil {
+		var limitErr *ResourceLimitError
+		if errors.As(err, &limitErr) {
+			return summary, NewUnprocessableEntityError(resourceLimitMessagePrefix+limitErr.Resource+resourceLimitMessageSuffix, err)
+		}
+		return summary, NewInternalError("PDF generation failed.", err)
+	}
+
+	postCtx, span := startSpan(ctx, "pdf.postprocess")
+	err = s.inspectOutput(postCtx, output, summary)
+	if result.PageCount > 0 {
+		summary.PageCount = result.PageCount
+	}
+	span.SetAttributes(attribute.Int64("pdf.output.bytes", summary.OutputBytes), attribute.Int("pdf.output.pages", summary.PageCount))
+	endSpan(span, err)
+	if err != nil {
+		return summary, NewInternalError("Failed to process request.", err)
+	}
+
+	if isResponse && config.UsageHeaders {
+		setUsageHeaders(response.Header(), summary)
+	}
+
+	_, span = startSpan(ctx, "pdf.response.write")
+	written, err := writeOutput(writer, output)
+	span.SetAttributes(attribute.Int64("pdf.response.bytes", written))
+	endSpan(span, err)
+	if err != nil {
+		return summary, NewInternalError("Failed to write response.", err)
+	}
+
+	s.shadowRender(ctx, engine, pp, workDir, output, summary)
+	return summary, nil
+}
+
+// createWorkspace creates the directory the files of a request are saved to, and the file the PDF is spooled
+// to. The PDF is spooled outside the workspace so it can be inspected before it is sent.
+func createWorkspace() (workDir string, root *os.Root, output *os.File, err error) {
+	workDir, err = os.MkdirTemp("", "pdf-service-*")
+	if err != nil {
+		return "", nil, nil, err
+	}
+	root, err = os.OpenRoot(workDir)
+	if err != nil {
+		_ = os.RemoveAll(workDir)
+		return "", nil, nil, err
+	}
+	output, err = os.CreateTemp("", "pdf-output-*.pdf")
+	if err != nil {
+		_ = root.Close()
+		_ = os.RemoveAll(workDir)
+		return "", nil, nil, err
+	}
+	return workDir, root, output, nil
+}
+
+// writeOutput copies the spooled PDF to writer.
+func writeOutput(writer io.Writer, output *os.File) (int64, error) {
+	if _, err := output.Seek(0, io.SeekStart); err != nil {
+		return 0, err
 	}
+	return io.Copy(writer, output)
+}
 
+func (s *Service) inspectOutput(ctx context.Context, output *os.File, summary *renderSummary) error {
+	if _, err := output.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	digest := sha256.New()
+	size, err := io.Copy(digest, output)
+	if err != nil {
+		return err
+	}
+	summary.OutputBytes = size
+	summary.OutputSHA256 = hex.EncodeToString(digest.Sum(nil))
+
+	info, err := inspectPDF(output, size)
+	if err != nil {
+		// The document is still delivered; only the derived metadata is missing.
+		s.obs.Logger().WarnContext(ctx, "failed to inspect generated pdf", "cause", err)
+		return nil
+	}
+	summary.PageCount = info.PageCount
 	return nil
 }
 
 type PartProcessor struct {
-	reader              *multipart.Reader
-	root                *os.Root
-	htmlFilename        string
-	cssFilename         string
-	attachmentFilenames []string
+	reader       *multipart.Reader
+	root         *os.Root
+	htmlFilename string
+	stylesheets  []string
+	attachments  []RenderAttachment
+	parts        []PartInfo
 }
 
-func (p *PartProcessor) ProcessParts() error {
+func (p *PartProcessor) ProcessParts(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "pdf.multipart.parse")
+	defer func() {
+		var size int64
+		for _, part := range p.parts {
+			size += part.Size
+		}
+		span.SetAttributes(attribute.Int("pdf.parts.count", len(p.parts)), attribute.Int64("pdf.request.bytes", size))
+		endSpan(span, err)
+	}()
+
 	for {
-		err := p.ProcessPart()
+		err := p.ProcessPart(ctx)
 		if err == io.EOF {
 			break
 		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return NewRequestTooLargeError("Request too large.", err)
+		}
 		if err != nil {
 			return err
 		}
@@ -64,43 +244,75 @@ func (p *PartProcessor) ProcessParts() error {
 		return NewBadRequestError("No html file provided.", nil)
 	}
 
-	if p.cssFilename == "" {
-		p.cssFilename = defaultStylesheetPath
+	if len(p.stylesheets) == 0 {
+		p.stylesheets = []string{defaultStylesheetPath}
 	}
 
 	return nil
 }
 
-func (p *PartProcessor) ProcessPart() error {
+// renderRequest describes the processed parts, saved in workDir, to a PDFRunner.
+func (p *PartProcessor) renderRequest(workDir string) RenderRequest {
+	return RenderRequest{
+		WorkDir:      workDir,
+		HTMLFilename: p.htmlFilename,
+		Stylesheets:  p.stylesheets,
+		Attachments:  p.attachments,
+	}
+}
+
+func (p *PartProcessor) ProcessPart(ctx context.Context) error {
 	part, err := p.reader.NextPart()
 	if err != nil {
 		return err
 	}
 	defer part.Close()
 
-	saveErr := p.savePart(part)
+	info := PartInfo{FormName: part.FormName(), Filename: part.FileName(), Role: PartRoleAsset}
+	switch {
+	case part.FormName() == "html":
+		info.Role = PartRoleHTML
+	case part.FormName() == "css":
+		info.Role = PartRoleCSS
+	case strings.HasPrefix(part.FormName(), "attachment."), strings.HasPrefix(part.FormName(), "file."):
+		info.Role = PartRoleAttachment
+	}
+
+	_, span := startSpan(ctx, "pdf.multipart.part",
+		attribute.String("pdf.part.form_name", info.FormName), attribute.String("pdf.part.role", info.Role))
+	digest := sha256.New()
+	size, saveErr := p.savePart(part, digest)
+	span.SetAttributes(attribute.Int64("pdf.part.size", size))
+	endSpan(span, saveErr)
 	if saveErr != nil {
 		return NewInternalError("Failed to process request.", saveErr)
 	}
+	info.Size = size
+	info.SHA256 = hex.EncodeToString(digest.Sum(nil))
+	p.parts = append(p.parts, info)
 
-	switch {
-	case part.FormName() == "html":
+	switch info.Role {
+	case PartRoleHTML:
 		p.htmlFilename = part.FileName()
-	case part.FormName() == "css":
-		p.cssFilename = part.FileName()
-	case strings.HasPrefix(part.FormName(), "attachment."), strings.HasPrefix(part.FormName(), "file."):
-		p.attachmentFilenames = append(p.attachmentFilenames, part.FileName())
+	case PartRoleCSS:
+		p.stylesheets = append(p.stylesheets, part.FileName())
+	case PartRoleAttachment:
+		p.attachments = append(p.attachments, RenderAttachment{
+			Filename:    part.FileName(),
+			FormName:    part.FormName(),
+			ContentType: part.Header.Get("Content-Type"),
+			Size:        size,
+		})
 	}
 	return nil
 }
 
-func (p *PartProcessor) savePart(part *multipart.Part) error {
+func (p *PartProcessor) savePart(part *multipart.Part, digest hash.Hash) (int64, error) {
 	file, err := p.root.OpenFile(part.FileName(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer file.Close()
 
-	_, err = io.Copy(file, part)
-	return err
+	return io.Copy(file, io.TeeReader(part, digest))
 }
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"errors"
-	"mime"
 	"mime/multipart"
 	"testing"
 
@@ -15,10 +14,9 @@ func TestGeneratePDFToWriterRequiresHTMLPart(t *testing.T) {
 	runner := &fakeRunner{}
 	svc := newTestService(fakeValidator{}, runner)
 
-	body, contentType := newMultipartBody(t, map[string]string{"css": "body{color:red;}"}, nil)
-	reader := newMultipartReaderFromBody(t, body, contentType)
+	reader := newMultipartReader(t, []testPart{{field: "css", filename: "css.txt", content: "body{color:red;}"}})
 
-	err := svc.generatePDFToWriter(context.Background(), reader, &bytes.Buffer{})
+	_, err := svc.generatePDFToWriter(context.Background(), defaultEngine(svc), reader, &bytes.Buffer{})
 
 	var appErr *AppError
 	assert.Error(t, err)
@@ -31,45 +29,56 @@ func TestGeneratePDFToWriterUsesDefaultStylesheetWhenCssMissing(t *testing.T) {
 	runner := &fakeRunner{}
 	svc := newTestService(fakeValidator{}, runner)
 
-	body, contentType := newMultipartBody(t, map[string]string{"html": "<html><body>ok</body></html>"}, nil)
-	reader := newMultipartReaderFromBody(t, body, contentType)
+	reader := newMultipartReader(t, []testPart{testHTMLPart})
 
-	err := svc.generatePDFToWriter(context.Background(), reader, &bytes.Buffer{})
+	_, err := svc.generatePDFToWriter(context.Background(), defaultEngine(svc), reader, &bytes.Buffer{})
 
 	assert.NoError(t, err)
-	assert.Equal(t, defaultStylesheetPath, runner.lastCSS)
-	assert.NotEmpty(t, runner.lastHTML)
+	assert.Equal(t, []string{defaultStylesheetPath}, runner.lastRequest.Stylesheets)
+	assert.NotEmpty(t, runner.lastRequest.HTMLFilename)
 }
 
 func TestGeneratePDFToWriterForwardsAttachmentAndFileParts(t *testing.T) {
 	runner := &fakeRunner{}
 	svc := newTestService(fakeValidator{}, runner)
 
-	body, contentType := newMultipartBody(
-		t,
-		map[string]string{"html": "<html><body>ok</body></html>"},
-		map[string]string{
-			"attachment.invoice": "invoice.pdf",
-			"file.terms":         "terms.txt",
-		},
-	)
-	reader := newMultipartReaderFromBody(t, body, contentType)
+	reader := newMultipartReader(t, []testPart{
+		testHTMLPart,
+		{field: "attachment.invoice", filename: "invoice.pdf", content: "test-file"},
+		{field: "file.terms", filename: "terms.txt", content: "test-file"},
+	})
 
-	err := svc.generatePDFToWriter(context.Background(), reader, &bytes.Buffer{})
+	_, err := svc.generatePDFToWriter(context.Background(), defaultEngine(svc), reader, &bytes.Buffer{})
 
 	assert.NoError(t, err)
-	assert.Len(t, runner.lastAttachments, 2)
-	assert.ElementsMatch(t, []string{"invoice.pdf", "terms.txt"}, runner.lastAttachments)
+	assert.Len(t, runner.lastRequest.Attachments, 2)
+	assert.ElementsMatch(t, []string{"invoice.pdf", "terms.txt"}, runner.lastRequest.attachmentFilenames())
+}
+
+func TestGeneratePDFToWriterAppliesStylesheetsInOrder(t *testing.T) {
+	runner := &fakeRunner{result: RenderResult{PageCount: 3}}
+	svc := newTestService(fakeValidator{}, runner)
+
+	reader := newMultipartReader(t, []testPart{
+		{field: "html", filename: "index.html", content: "body {}"},
+		{field: "css", filename: "base.css", content: "body {}"},
+		{field: "css", filename: "print.css", content: "body {}"},
+	})
+
+	summary, err := svc.generatePDFToWriter(context.Background(), defaultEngine(svc), reader, &bytes.Buffer{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"base.css", "print.css"}, runner.lastRequest.Stylesheets)
+	assert.Equal(t, 3, summary.PageCount)
 }
 
 func TestGeneratePDFToWriterMapsRunnerErrorToInternalError(t *testing.T) {
 	runner := &fakeRunner{runErr: errors.New("boom")}
 	svc := newTestService(fakeValidator{}, runner)
 
-	body, contentType := newMultipartBody(t, map[string]string{"html": "<html><body>ok</body></html>"}, nil)
-	reader := newMultipartReaderFromBody(t, body, contentType)
+	reader := newMultipartReader(t, []testPart{testHTMLPart})
 
-	err := svc.generatePDFToWriter(context.Background(), reader, &bytes.Buffer{})
+	_, err := svc.generatePDFToWriter(context.Background(), defaultEngine(svc), reader, &bytes.Buffer{})
 
 	var appErr *AppError
 	assert.Error(t, err)
@@ -79,11 +88,10 @@ func TestGeneratePDFToWriterMapsRunnerErrorToInternalError(t *testing.T) {
 	assert.EqualError(t, appErr.Cause, "boom")
 }
 
-func newMultipartReaderFromBody(t *testing.T, body *bytes.Buffer, contentType string) *multipart.Reader {
+// newMultipartReader returns a reader for a request body holding parts.
+func newMultipartReader(t *testing.T, parts []testPart) *multipart.Reader {
 	t.Helper()
-	_, params, err := mime.ParseMediaType(contentType)
-	if err != nil {
-		t.Fatalf("failed to parse media type: %v", err)
-	}
-	return multipart.NewReader(body, params["boundary"])
+	reader, err := newMultipartRequest(t, "/pdf", parts).MultipartReader()
+	assert.NoError(t, err)
+	return reader
 }
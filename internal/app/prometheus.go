@@ -0,0 +1,38 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	otelprometheus "go.opentelemetry.io/otel/exporters/prometheus"
+)
+
+// PrometheusExporter serves the service's metrics, together with the Go runtime and process metrics, in the
+// Prometheus exposition format, for clusters that scrape rather than push. It is installed with WithPrometheus.
+type PrometheusExporter struct {
+	registry *prometheus.Registry
+	reader   *otelprometheus.Exporter
+}
+
+func NewPrometheusExporter() (*PrometheusExporter, error) {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collectors.NewGoCollector()); err != nil {
+		return nil, fmt.Errorf("failed to register go collector: %w", err)
+	}
+	if err := registry.Register(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{})); err != nil {
+		return nil, fmt.Errorf("failed to register process collector: %w", err)
+	}
+	reader, err := otelprometheus.New(otelprometheus.WithRegisterer(registry))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create prometheus exporter: %w", err)
+	}
+	return &PrometheusExporter{registry: registry, reader: reader}, nil
+}
+
+// Handler serves GET /metrics.
+func (e *PrometheusExporter) Handler() http.Handler {
+	return promhttp.HandlerFor(e.registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,31 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestPrometheusExporterServesServiceAndRuntimeMetrics(t *testing.T) {
+	exporter, err := NewPrometheusExporter()
+	assert.NoError(t, err)
+	obs := NewMockObservabilityProvider(WithPrometheus(exporter))
+	runner := &fakeRunner{output: []byte("%PDF-1.7"), result: RenderResult{Usage: testUsage}}
+	svc := newTestService(fakeValidator{}, runner, withTestObservability(obs))
+	assert.Equal(t, http.StatusOK, postMultipart(t, svc, "/pdf", []testPart{testHTMLPart}).Code)
+
+	rec := httptest.NewRecorder()
+	exporter.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, `pdf_render_duration_seconds_count{client_id="test-client",engine="weasyprint"`)
+	assert.Contains(t, body, "pdf_queue_wait_time_seconds_bucket")
+	assert.Contains(t, body, "go_goroutines ")
+	// The in-memory reader of the mock provider still sees the same metrics.
+	assert.Equal(t, uint64(1), histogramCount(t, obs.Metrics(), "pdf.queue.wait_time",
+		attribute.NewSet(attribute.String("engine", "weasyprint"), attribute.String("result", "acquired"))))
+}
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+)
+
+var (
+	ErrQueueFull    = errors.New("render queue is full")
+	ErrShuttingDown = errors.New("service is shutting down")
+)
+
+// renderQueue bounds the number of concurrent sandboxes. Up to maxQueued further renders wait for a
+// slot; beyond that new renders are rejected immediately. Background work only takes a slot nobody waits
+// for, and gives it up as soon as a render has to wait.
+type renderQueue struct {
+	slots     chan struct{}
+	maxQueued int
+
+	mu      sync.Mutex
+	waiting int
+	// background holds the background work in slots that has not been preempted yet, oldest first.
+	background []*backgroundSlot
+	closed     chan struct{}
+	once       sync.Once
+}
+
+type backgroundSlot struct {
+	preempt func()
+}
+
+func newRenderQueue(maxConcurrent int, maxQueued int) *renderQueue {
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	return &renderQueue{
+		slots:     make(chan struct{}, maxConcurrent),
+		maxQueued: maxQueued,
+		closed:    make(chan struct{}),
+	}
+}
+
+// acquire waits for a render slot. The returned release function must be called when the render is done.
+func (q *renderQueue) acquire(ctx context.Context) (func(), error) {
+	select {
+	case <-q.closed:
+		return nil, ErrShuttingDown
+	default:
+	}
+
+	// Fast path: a slot is free, no need to queue.
+	select {
+	case q.slots <- struct{}{}:
+		return q.release, nil
+	default:
+	}
+
+	// A render waiting for a slot that background work gives up does not count against maxQueued.
+	q.mu.Lock()
+	if !q.preemptBackground() && q.waiting >= q.maxQueued {
+		q.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+	q.waiting++
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		q.waiting--
+		q.mu.Unlock()
+	}()
+
+	select {
+	case q.slots <- struct{}{}:
+		return q.release, nil
+	case <-q.closed:
+		return nil, ErrShuttingDown
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// tryAcquireBackground takes a free render slot for background work, without waiting or queueing. It reports
+// false when no slot is free, a render is waiting or the queue is closed. preempt is called once a render
+// has to wait while the slot is held; the work should then stop and call the returned release function.
+func (q *renderQueue) tryAcquireBackground(preempt func()) (func(), bool) {
+	select {
+	case <-q.closed:
+		return nil, false
+	default:
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.waiting > 0 {
+		return nil, false
+	}
+	select {
+	case q.slots <- struct{}{}:
+	default:
+		return nil, false
+	}
+
+	slot := &backgroundSlot{preempt: preempt}
+	q.background = append(q.background, slot)
+	return func() {
+		q.mu.Lock()
+		q.background = slices.DeleteFunc(q.background, func(held *backgroundSlot) bool { return held == slot })
+		q.mu.Unlock()
+		q.release()
+	}, true
+}
+
+// preemptBackground asks the oldest background work to give up its slot. q.mu must be held.
+func (q *renderQueue) preemptBackground() bool {
+	if len(q.background) == 0 {
+		return false
+	}
+	slot := q.background[0]
+	q.background = q.background[1:]
+	slot.preempt()
+	return true
+}
+
+func (q *renderQueue) release() {
+	<-q.slots
+}
+
+// close rejects queued and future renders. Renders holding a slot are not affected.
+func (q *renderQueue) close() {
+	q.once.Do(func() { close(q.closed) })
+}
+
+type queueStats struct {
+	Active        int
+	Waiting       int
+	MaxConcurrent int
+	MaxQueued     int
+}
+
+func (q *renderQueue) stats() queueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return queueStats{
+		Active:        len(q.slots),
+		Waiting:       q.waiting,
+		MaxConcurrent: cap(q.slots),
+		MaxQueued:     q.maxQueued,
+	}
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderQueueRejectsWhenQueueFull(t *testing.T) {
+	queue := newRenderQueue(1, 0)
+
+	release, err := queue.acquire(context.Background())
+	assert.NoError(t, err)
+
+	_, err = queue.acquire(context.Background())
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	release()
+	release, err = queue.acquire(context.Background())
+	assert.NoError(t, err)
+	release()
+}
+
+func TestRenderQueueWaitsForSlot(t *testing.T) {
+	queue := newRenderQueue(1, 1)
+	release, err := queue.acquire(context.Background())
+	assert.NoError(t, err)
+
+	acquired := make(chan error, 1)
+	go func() {
+		next, err := queue.acquire(context.Background())
+		if err == nil {
+			next()
+		}
+		acquired <- err
+	}()
+
+	assert.Eventually(t, func() bool { return queue.stats().Waiting == 1 }, time.Second, time.Millisecond)
+	release()
+	assert.NoError(t, <-acquired)
+}
+
+func TestRenderQueueCloseCancelsWaiters(t *testing.T) {
+	queue := newRenderQueue(1, 1)
+	release, err := queue.acquire(context.Background())
+	assert.NoError(t, err)
+	defer release()
+
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := queue.acquire(context.Background())
+		acquired <- err
+	}()
+
+	assert.Eventually(t, func() bool { return queue.stats().Waiting == 1 }, time.Second, time.Millisecond)
+	queue.close()
+	assert.ErrorIs(t, <-acquired, ErrShuttingDown)
+
+	_, err = queue.acquire(context.Background())
+	assert.ErrorIs(t, err, ErrShuttingDown)
+}
+
+func TestRenderQueueHonoursContext(t *testing.T) {
+	queue := newRenderQueue(1, 1)
+	release, err := queue.acquire(context.Background())
+	assert.NoError(t, err)
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = queue.acquire(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRenderQueuePreemptsBackgroundWork(t *testing.T) {
+	queue := newRenderQueue(1, 0)
+	preempted := make(chan struct{})
+	releaseBackground, ok := queue.tryAcquireBackground(func() { close(preempted) })
+	assert.True(t, ok)
+
+	acquired := make(chan error, 1)
+	go func() {
+		release, err := queue.acquire(context.Background())
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+
+	<-preempted
+	_, ok = queue.tryAcquireBackground(func() {})
+	assert.False(t, ok, "background work must not take a slot a render waits for")
+	releaseBackground()
+	assert.NoError(t, <-acquired)
+
+	_, err := queue.acquire(context.Background())
+	assert.NoError(t, err)
+	_, ok = queue.tryAcquireBackground(func() {})
+	assert.False(t, ok)
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RenderRequest is a prepared workspace for a PDFRunner to render.
+type RenderRequest struct {
+	// WorkDir holds the uploaded files. The file names below are relative to it.
+	WorkDir      string
+	HTMLFilename string
+	// Stylesheets are applied in order. defaultStylesheetPath stands for the runner's default stylesheet,
+	// which is the only stylesheet of a request that did not upload one.
+	Stylesheets []string
+	Attachments []RenderAttachment
+	// BaseURL resolves relative URLs in the HTML. Empty resolves them against the HTML file.
+	BaseURL string
+	Options RenderOptions
+	// Limits tighten the runner's own ResourceLimits for this render. Zero values keep the runner's limit.
+	Limits ResourceLimits
+}
+
+// RenderAttachment is a file embedded in the PDF.
+type RenderAttachment struct {
+	Filename string
+	// FormName is the multipart field the attachment was uploaded in.
+	FormName    string
+	ContentType string
+	Size        int64
+}
+
+// RenderOptions are renderer settings of a single render.
+type RenderOptions struct {
+	// PDFVariant produces a PDF/A or PDF/UA document, for example "pdf/a-3b". Empty produces a plain PDF.
+	PDFVariant string
+	// PresentationalHints follows presentational HTML attributes such as width and align.
+	PresentationalHints bool
+}
+
+// RenderResult describes a successful render.
+type RenderResult struct {
+	// Diagnostics are the messages the renderer logged, at most renderMaxDiagnostics.
+	Diagnostics []Diagnostic
+	// PageCount is zero when the runner does not know it; the service then counts the pages itself.
+	PageCount int
+	Usage     ResourceUsage
+}
+
+// ResourceUsage is what a render consumed. Fields a runner cannot measure are zero.
+type ResourceUsage struct {
+	WallTime    time.Duration
+	UserCPU     time.Duration
+	SystemCPU   time.Duration
+	MaxRSSBytes int64
+}
+
+func (r RenderRequest) attachmentFilenames() []string {
+	filenames := []string{}
+	for _, attachment := range r.Attachments {
+		filenames = append(filenames, attachment.Filename)
+	}
+	return filenames
+}
+
+// tighten returns the stricter of l and other for every limit; a zero value does not limit.
+func (l ResourceLimits) tighten(other ResourceLimits) ResourceLimits {
+	return ResourceLimits{
+		MemoryBytes:  stricterLimit(l.MemoryBytes, other.MemoryBytes),
+		CPUTime:      stricterLimit(l.CPUTime, other.CPUTime),
+		CPUQuota:     stricterLimit(l.CPUQuota, other.CPUQuota),
+		MaxProcesses: stricterLimit(l.MaxProcesses, other.MaxProcesses),
+		MaxFileBytes: stricterLimit(l.MaxFileBytes, other.MaxFileBytes),
+	}
+}
+
+func stricterLimit[T int | int64 | float64 | time.Duration](a T, b T) T {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// processUsage reads the usage of an exited process, including the descendants it waited for.
+func processUsage(state *os.ProcessState, wallTime time.Duration) ResourceUsage {
+	usage := ResourceUsage{WallTime: wallTime}
+	if state == nil {
+		return usage
+	}
+	usage.UserCPU = state.UserTime()
+	usage.SystemCPU = state.SystemTime()
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		usage.MaxRSSBytes = rusage.Maxrss * 1024
+	}
+	return usage
+}
+
+// recordProcessExit sets the exit code of a renderer process on the span in ctx; -1 means it was killed by a
+// signal.
+func recordProcessExit(ctx context.Context, state *os.ProcessState) {
+	if state != nil {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("process.exit.code", state.ExitCode()))
+	}
+}
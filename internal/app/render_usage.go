@@ -0,0 +1,119 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Usage headers, set on successful renders when Config.UsageHeaders is on. Times are in milliseconds.
+const (
+	usageWallTimeHeader    = "X-Render-Wall-Ms"
+	usageUserCPUHeader     = "X-Render-CPU-User-Ms"
+	usageSystemCPUHeader   = "X-Render-CPU-System-Ms"
+	usageMaxRSSHeader      = "X-Render-Max-RSS-Bytes"
+	usageOutputBytesHeader = "X-Render-Output-Bytes"
+)
+
+// accountRender reports the resources of a render on the request span, in the render metrics and in the
+// log. Requests rejected before they reached a runner are not accounted.
+func (s *Service) accountRender(ctx context.Context, summary *renderSummary, err error) {
+	if summary == nil || summary.Usage.WallTime == 0 {
+		return
+	}
+	usage := summary.Usage
+	clientID := ""
+	if principal := PrincipalFromContext(ctx); principal != nil {
+		clientID = principal.ClientID
+	}
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("pdf.engine", summary.Engine),
+		attribute.Float64("pdf.render.wall_time", usage.WallTime.Seconds()),
+		attribute.Float64("pdf.render.cpu.user", usage.UserCPU.Seconds()),
+		attribute.Float64("pdf.render.cpu.system", usage.SystemCPU.Seconds()),
+		attribute.Int64("pdf.render.max_rss", usage.MaxRSSBytes),
+		attribute.Int64("pdf.output.bytes", summary.OutputBytes),
+		attribute.Int("pdf.output.pages", summary.PageCount),
+	)
+
+	attributes := []attribute.KeyValue{
+		attribute.String("engine", summary.Engine),
+		attribute.String("client_id", clientID),
+		attribute.String("outcome", outcome),
+	}
+	m := s.metrics
+	m.renderDuration.Record(ctx, usage.WallTime.Seconds(), metric.WithAttributes(attributes...))
+	m.renderCPUTime.Record(ctx, usage.UserCPU.Seconds(), metric.WithAttributes(append(attributes, attribute.String("cpu.mode", "user"))...))
+	m.renderCPUTime.Record(ctx, usage.SystemCPU.Seconds(), metric.WithAttributes(append(attributes, attribute.String("cpu.mode", "system"))...))
+	if usage.MaxRSSBytes > 0 {
+		m.renderMemory.Record(ctx, usage.MaxRSSBytes, metric.WithAttributes(attributes...))
+	}
+	if err == nil {
+		m.outputBytes.Record(ctx, summary.OutputBytes, metric.WithAttributes(attributes...))
+	}
+
+	s.obs.Logger().InfoContext(ctx, "pdf rendered",
+		"engine", summary.Engine,
+		"client_id", clientID,
+		"outcome", outcome,
+		"wall_ms", usage.WallTime.Milliseconds(),
+		"cpu_user_ms", usage.UserCPU.Milliseconds(),
+		"cpu_system_ms", usage.SystemCPU.Milliseconds(),
+		"max_rss_bytes", usage.MaxRSSBytes,
+		"output_bytes", summary.OutputBytes,
+		"pages", summary.PageCount,
+	)
+}
+
+// accountRequest records the size of the parts of a render request, as far as they were read.
+func (s *Service) accountRequest(ctx context.Context, summary *renderSummary) {
+	if summary == nil || len(summary.Parts) == 0 {
+		return
+	}
+	var size int64
+	for _, part := range summary.Parts {
+		size += part.Size
+	}
+	s.metrics.requestBytes.Record(ctx, size, metric.WithAttributes(attribute.String("engine", summary.Engine)))
+}
+
+// setUsageHeaders reports the resources of a successful render in Server-Timing and X-Render-* headers.
+func setUsageHeaders(header http.Header, summary *renderSummary) {
+	usage := summary.Usage
+	header.Set("Server-Timing", fmt.Sprintf("render;dur=%.1f, cpu-user;dur=%.1f, cpu-system;dur=%.1f",
+		milliseconds(usage.WallTime), milliseconds(usage.UserCPU), milliseconds(usage.SystemCPU)))
+	header.Set(usageWallTimeHeader, strconv.FormatInt(usage.WallTime.Milliseconds(), 10))
+	header.Set(usageUserCPUHeader, strconv.FormatInt(usage.UserCPU.Milliseconds(), 10))
+	header.Set(usageSystemCPUHeader, strconv.FormatInt(usage.SystemCPU.Milliseconds(), 10))
+	header.Set(usageMaxRSSHeader, strconv.FormatInt(usage.MaxRSSBytes, 10))
+	header.Set(usageOutputBytesHeader, strconv.FormatInt(summary.OutputBytes, 10))
+}
+
+// parseUsageHeaders reads back the CPU time and memory a render node reported with setUsageHeaders. Values
+// the node did not report are left as they are.
+func parseUsageHeaders(header http.Header, usage *ResourceUsage) {
+	if ms, err := strconv.ParseInt(header.Get(usageUserCPUHeader), 10, 64); err == nil {
+		usage.UserCPU = time.Duration(ms) * time.Millisecond
+	}
+	if ms, err := strconv.ParseInt(header.Get(usageSystemCPUHeader), 10, 64); err == nil {
+		usage.SystemCPU = time.Duration(ms) * time.Millisecond
+	}
+	if bytes, err := strconv.ParseInt(header.Get(usageMaxRSSHeader), 10, 64); err == nil {
+		usage.MaxRSSBytes = bytes
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
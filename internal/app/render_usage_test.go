@@ -0,0 +1,71 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var testUsage = ResourceUsage{WallTime: 1500 * time.Millisecond, UserCPU: 1200 * time.Millisecond, SystemCPU: 100 * time.Millisecond, MaxRSSBytes: 64 << 20}
+
+func TestRenderPDFSetsUsageHeaders(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{output: []byte("%PDF-1.7"), result: RenderResult{Usage: testUsage}})
+
+	rec := postMultipart(t, svc, "/pdf", []testPart{testHTMLPart})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Server-Timing"))
+	assert.Empty(t, rec.Header().Get(usageUserCPUHeader))
+
+	svc.SetConfig(Config{MaxRequestBytes: 5 * 1024 * 1024, RequestTimeout: 3 * time.Second, UsageHeaders: true})
+	rec = postMultipart(t, svc, "/pdf", []testPart{testHTMLPart})
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "render;dur=1500.0, cpu-user;dur=1200.0, cpu-system;dur=100.0", rec.Header().Get("Server-Timing"))
+	assert.Equal(t, "1500", rec.Header().Get(usageWallTimeHeader))
+	assert.Equal(t, "1200", rec.Header().Get(usageUserCPUHeader))
+	assert.Equal(t, "100", rec.Header().Get(usageSystemCPUHeader))
+	assert.Equal(t, "67108864", rec.Header().Get(usageMaxRSSHeader))
+	assert.Equal(t, "8", rec.Header().Get(usageOutputBytesHeader))
+}
+
+func TestAccountRenderSetsSpanAttributes(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	ctx, span := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test").Start(context.Background(), "POST /pdf")
+	svc := newTestService(fakeValidator{}, &fakeRunner{})
+
+	svc.accountRender(ctx, &renderSummary{Engine: "weasyprint", Usage: testUsage, OutputBytes: 2048, PageCount: 3}, nil)
+	span.End()
+
+	if assert.Len(t, recorder.Ended(), 1) {
+		attributes := recorder.Ended()[0].Attributes()
+		assert.Contains(t, attributes, attribute.String("pdf.engine", "weasyprint"))
+		assert.Contains(t, attributes, attribute.Float64("pdf.render.cpu.user", 1.2))
+		assert.Contains(t, attributes, attribute.Int64("pdf.render.max_rss", 64<<20))
+		assert.Contains(t, attributes, attribute.Int64("pdf.output.bytes", 2048))
+		assert.Contains(t, attributes, attribute.Int("pdf.output.pages", 3))
+	}
+}
+
+func TestRemoteRunnerReadsNodeUsage(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{result: RenderResult{Usage: testUsage}}, WithSignedRequests(newTestHMACValidator(t, testHMACKey)))
+	svc.SetConfig(Config{MaxRequestBytes: 5 * 1024 * 1024, RequestTimeout: 3 * time.Second, UsageHeaders: true})
+	node := httptest.NewServer(svc.Routes())
+	t.Cleanup(node.Close)
+	runner := newTestRemoteRunner(t, NewMockObservabilityProvider(), node.URL)
+
+	result, err := runner.GeneratePDF(context.Background(), remoteWorkspaceRequest(t), &bytes.Buffer{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, testUsage.UserCPU, result.Usage.UserCPU)
+	assert.Equal(t, testUsage.SystemCPU, result.Usage.SystemCPU)
+	assert.Equal(t, testUsage.MaxRSSBytes, result.Usage.MaxRSSBytes)
+	assert.Positive(t, result.Usage.WallTime)
+}
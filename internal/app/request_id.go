@@ -0,0 +1,91 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the ID of a request. A valid ID sent by the caller is kept, so a request can be
+// followed across services; otherwise one is generated. It is returned on every response.
+const RequestIDHeader = "X-Request-Id"
+
+// sandboxRequestIDEnv carries the request ID into the environment of a local sandbox.
+const sandboxRequestIDEnv = "REQUEST_ID"
+
+// requestIDAttribute names the request ID on spans.
+const requestIDAttribute = "request.id"
+
+// requestIDPattern accepts UUIDs, trace IDs and similar tokens, but nothing that could break a log line or a
+// header.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:/+=@-]{1,128}$`)
+
+type requestIDContextKey struct{}
+
+func contextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// RequestIDFromContext returns the ID of the request ctx belongs to, or "".
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// withRequestID assigns every request its ID and returns it in the response headers.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(contextWithRequestID(r.Context(), id)))
+	})
+}
+
+// tagRequestSpan sets the request ID on the otelhttp span of the request.
+func tagRequestSpan(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := RequestIDFromContext(r.Context()); id != "" {
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String(requestIDAttribute, id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestIDHandler adds the request ID to every record logged with the context of a request.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func newRequestIDLogger(handler slog.Handler) *slog.Logger {
+	return slog.New(requestIDHandler{handler})
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
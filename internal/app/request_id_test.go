@@ -0,0 +1,99 @@
+package app
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRequestIDIsAcceptedOrGenerated(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{})
+
+	for _, tt := range []struct {
+		name     string
+		incoming string
+		want     string
+	}{
+		{name: "accepts a valid id", incoming: "3f2c9a1e-7b4d-4e0a-9c8f-1d2e3f4a5b6c", want: "3f2c9a1e-7b4d-4e0a-9c8f-1d2e3f4a5b6c"},
+		{name: "generates a missing id"},
+		{name: "replaces an invalid id", incoming: "bad id\r\nX-Injected: 1"},
+		{name: "replaces an overlong id", incoming: strings.Repeat("a", 129)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/livez", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			svc.Routes().ServeHTTP(rec, req)
+
+			id := rec.Header().Get(RequestIDHeader)
+			if tt.want != "" {
+				assert.Equal(t, tt.want, id)
+				return
+			}
+			assert.Regexp(t, `^[0-9a-f]{32}$`, id)
+		})
+	}
+}
+
+func TestErrorResponsesIncludeRequestID(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{})
+
+	req := httptest.NewRequest(http.MethodGet, "/engines", nil)
+	req.Header.Set(RequestIDHeader, "req-123")
+	rec := httptest.NewRecorder()
+	svc.Routes().ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "Unauthorized\nRequest ID: req-123\n", rec.Body.String())
+}
+
+func TestRequestIDIsAddedToSpansAndLogs(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	ctx, request := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test").Start(context.Background(), "POST /pdf")
+	svc := newTestService(fakeValidator{}, &fakeRunner{})
+
+	req := newMultipartRequest(t, "/pdf", []testPart{testHTMLPart})
+	req.Header.Set(RequestIDHeader, "req-123")
+	svc.Routes().ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+	request.End()
+
+	spans := recorder.Ended()
+	if assert.NotEmpty(t, spans) {
+		for _, span := range spans {
+			assert.Contains(t, span.Attributes(), attribute.String(requestIDAttribute, "req-123"), span.Name())
+		}
+	}
+
+	var logs strings.Builder
+	logger := newRequestIDLogger(slog.NewTextHandler(&logs, nil)).With("engine", "weasyprint")
+	logger.InfoContext(contextWithRequestID(context.Background(), "req-123"), "pdf rendered")
+	logger.Info("no request")
+	assert.Contains(t, logs.String(), "msg=\"pdf rendered\" engine=weasyprint request_id=req-123\n")
+	assert.NotContains(t, logs.String(), "msg=\"no request\" engine=weasyprint request_id")
+}
+
+func TestRemoteRunnerForwardsRequestID(t *testing.T) {
+	var forwarded []string
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = append(forwarded, r.Header.Get(RequestIDHeader))
+		_, _ = w.Write([]byte("%PDF"))
+	}))
+	t.Cleanup(node.Close)
+
+	runner := newTestRemoteRunner(t, NewMockObservabilityProvider(), node.URL)
+	_, err := runner.GeneratePDF(contextWithRequestID(context.Background(), "req-123"), remoteWorkspaceRequest(t), io.Discard)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"req-123"}, forwarded)
+}
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// sandboxRunnerFactory builds a runner that executes weasyprintPath with the default stylesheet at stylesheetPath.
+type sandboxRunnerFactory func(weasyprintPath string, stylesheetPath string) PDFRunner
+
+func TestWeasyprintRunnerContract(t *testing.T) {
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		t.Skip("bwrap not available in PATH")
+	}
+	testRunnerContract(t, func(weasyprintPath string, stylesheetPath string) PDFRunner {
+		return WeasyprintRunner{BwrapPath: "bwrap", WeasyprintPath: weasyprintPath, DefaultStylesheetPath: stylesheetPath}
+	})
+}
+
+func TestLandlockRunnerContract(t *testing.T) {
+	if _, err := landlockABI(); err != nil {
+		t.Skip(err)
+	}
+	testRunnerContract(t, func(weasyprintPath string, stylesheetPath string) PDFRunner {
+		return LandlockRunner{WeasyprintPath: weasyprintPath, DefaultStylesheetPath: stylesheetPath}
+	})
+}
+
+func TestLandlockRunnerSelfTest(t *testing.T) {
+	if _, err := landlockABI(); err != nil {
+		t.Skip(err)
+	}
+	if _, err := os.Stat("/usr/bin/python3"); err != nil {
+		t.Skip("python3 not available in /usr/bin")
+	}
+
+	runner := LandlockRunner{WeasyprintPath: "weasyprint", DefaultStylesheetPath: "../../assets/default.css"}
+
+	assert.NoError(t, runner.SelfTest(context.Background()))
+}
+
+// testRunnerContract checks the behaviour every sandboxed runner shares. Most cases replace WeasyPrint with
+// sh, which runs the HTML file as a script with WeasyPrint's arguments, so they run without WeasyPrint installed.
+func testRunnerContract(t *testing.T, newRunner sandboxRunnerFactory) {
+	defaultCSS := "@page { size: A4; margin: 2cm; }"
+	stylesheetPath := filepath.Join(t.TempDir(), "default.css")
+	assert.NoError(t, os.WriteFile(stylesheetPath, []byte(defaultCSS), 0o600))
+
+	runScriptContext := func(ctx context.Context, t *testing.T, script string, request RenderRequest) (string, RenderResult, error) {
+		t.Helper()
+		request.WorkDir = t.TempDir()
+		request.HTMLFilename = "index.html"
+		assert.NoError(t, os.WriteFile(filepath.Join(request.WorkDir, "index.html"), []byte(script), 0o600))
+		assert.NoError(t, os.WriteFile(filepath.Join(request.WorkDir, "style.css"), []byte("body {}"), 0o600))
+
+		var output bytes.Buffer
+		result, err := newRunner("sh", stylesheetPath).GeneratePDF(ctx, request, &output)
+		return output.String(), result, err
+	}
+	runScript := func(t *testing.T, script string, request RenderRequest) (string, RenderResult, error) {
+		t.Helper()
+		return runScriptContext(context.Background(), t, script, request)
+	}
+	styled := RenderRequest{Stylesheets: []string{"style.css"}}
+
+	t.Run("streams stdout to the output", func(t *testing.T) {
+		output, _, err := runScript(t, `printf '%%PDF-1.7'`, styled)
+		assert.NoError(t, err)
+		assert.Equal(t, "%PDF-1.7", output)
+	})
+
+	t.Run("passes stylesheet and attachments", func(t *testing.T) {
+		output, _, err := runScript(t, `echo "$@"`, RenderRequest{
+			Stylesheets: []string{"style.css"},
+			Attachments: []RenderAttachment{{Filename: "a.txt"}, {Filename: "b.txt"}},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "- --stylesheet style.css --attachment a.txt --attachment b.txt", strings.TrimSpace(output))
+	})
+
+	t.Run("passes the base url and render options", func(t *testing.T) {
+		output, _, err := runScript(t, `echo "$@"`, RenderRequest{
+			BaseURL: "https://example.com/invoices/",
+			Options: RenderOptions{PDFVariant: "pdf/a-3b", PresentationalHints: true},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "- --base-url https://example.com/invoices/ --pdf-variant pdf/a-3b --presentational-hints", strings.TrimSpace(output))
+	})
+
+	t.Run("reports diagnostics and resource usage", func(t *testing.T) {
+		output, result, err := runScript(t, `echo "WARNING: Failed to load image at 'https://example.com/logo.png'" >&2; printf '%%PDF-1.7'`, styled)
+		assert.NoError(t, err)
+		assert.Equal(t, "%PDF-1.7", output)
+		assert.Equal(t, []Diagnostic{{
+			Level:   DiagnosticLevelWarning,
+			Message: "Failed to load image at 'https://example.com/logo.png'",
+			URL:     "https://example.com/logo.png",
+		}}, result.Diagnostics)
+		assert.Positive(t, result.Usage.WallTime)
+		assert.Positive(t, result.Usage.MaxRSSBytes)
+	})
+
+	t.Run("makes the default stylesheet readable", func(t *testing.T) {
+		output, _, err := runScript(t, `cat "$3"`, RenderRequest{Stylesheets: []string{defaultStylesheetPath}})
+		assert.NoError(t, err)
+		assert.Equal(t, defaultCSS, output)
+	})
+
+	t.Run("keeps the workspace read-only", func(t *testing.T) {
+		output, _, err := runScript(t, `if touch written 2>/dev/null; then echo writable; else echo read-only; fi`, styled)
+		assert.NoError(t, err)
+		assert.Equal(t, "read-only", strings.TrimSpace(output))
+	})
+
+	t.Run("hides host files", func(t *testing.T) {
+		secret := filepath.Join(t.TempDir(), "secret.txt")
+		assert.NoError(t, os.WriteFile(secret, []byte("secret"), 0o600))
+
+		output, _, err := runScript(t, `cat `+secret+` 2>/dev/null || echo hidden`, styled)
+		assert.NoError(t, err)
+		assert.Equal(t, "hidden", strings.TrimSpace(output))
+	})
+
+	t.Run("reports a failing render", func(t *testing.T) {
+		recorder := tracetest.NewSpanRecorder()
+		ctx, span := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test").Start(context.Background(), "pdf.sandbox.run")
+
+		_, _, err := runScriptContext(ctx, t, `echo broken >&2; exit 3`, styled)
+		span.End()
+
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "broken")
+		}
+		if assert.Len(t, recorder.Ended(), 1) {
+			assert.Contains(t, recorder.Ended()[0].Attributes(), attribute.Int("process.exit.code", 3))
+		}
+	})
+
+	t.Run("renders a PDF with WeasyPrint", func(t *testing.T) {
+		if _, err := exec.LookPath("weasyprint"); err != nil {
+			t.Skip("weasyprint not available in PATH")
+		}
+		workDir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(workDir, "index.html"), []byte("<html><body><h1>Hello</h1></body></html>"), 0o600))
+
+		var output bytes.Buffer
+		_, err := newRunner("weasyprint", stylesheetPath).GeneratePDF(context.Background(),
+			RenderRequest{WorkDir: workDir, HTMLFilename: "index.html", Stylesheets: []string{defaultStylesheetPath}}, &output)
+		assert.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(output.Bytes(), []byte("%PDF")), "expected generated output to start with %PDF")
+	})
+}
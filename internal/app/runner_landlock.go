@@ -0,0 +1,380 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// LandlockExecArg is the first argument that makes the service binary act as the Landlock exec helper
+// (see RunHelper). Landlock and seccomp restrict the calling thread, so the helper applies them to
+// itself and then execs WeasyPrint, which inherits them.
+const LandlockExecArg = "landlock-exec"
+
+// DefaultLandlockReadOnlyPaths are the host paths a LandlockRunner render may read and execute when
+// ReadOnlyPaths is not set. They match the bwrap binds of WeasyprintRunner.
+var DefaultLandlockReadOnlyPaths = []string{
+	"/usr",
+	"/lib",
+	"/lib64",
+	"/bin",
+	"/etc/fonts",
+	"/etc/ld.so.cache",
+	"/var/cache/fontconfig",
+	"/dev/urandom",
+}
+
+const (
+	landlockReadAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+
+	landlockWriteAccess = unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE | unix.LANDLOCK_ACCESS_FS_MAKE_DIR | unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_TRUNCATE
+
+	// landlockFileAccess are the rights that apply to a file rather than a directory.
+	landlockFileAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+)
+
+// LandlockRunner renders with WeasyPrint confined by Landlock, seccomp and rlimits instead of bwrap, for hosts
+// that do not allow unprivileged user namespaces. The render can read ReadOnlyPaths, its workspace and the
+// default stylesheet, write only a private scratch directory, and cannot open sockets.
+type LandlockRunner struct {
+	WeasyprintPath        string
+	DefaultStylesheetPath string
+	// ReadOnlyPaths defaults to DefaultLandlockReadOnlyPaths.
+	ReadOnlyPaths []string
+	Limits        ResourceLimits
+	// Reloadable, when set, replaces DefaultStylesheetPath and Limits at the start of every render.
+	Reloadable *ReloadableSettings
+}
+
+// landlockPolicy is passed from the runner to the Landlock exec helper.
+type landlockPolicy struct {
+	Rlimits   string   `json:"rlimits"`
+	ReadOnly  []string `json:"read_only"`
+	ReadWrite []string `json:"read_write"`
+}
+
+func (r LandlockRunner) GeneratePDF(ctx context.Context, request RenderRequest, output io.Writer) (RenderResult, error) {
+	r = r.current()
+	stylesheet, err := filepath.Abs(r.defaultStylesheetPath())
+	if err != nil {
+		return RenderResult{}, err
+	}
+	r.Limits = r.Limits.tighten(request.Limits)
+	args := append([]string{request.HTMLFilename, "-"}, weasyprintArgs(request, stylesheet)...)
+
+	scratch, err := os.MkdirTemp("", "pdf-scratch-*")
+	if err != nil {
+		return RenderResult{}, err
+	}
+	defer os.RemoveAll(scratch)
+
+	stderr := &tailBuffer{limit: renderStderrLimit}
+	start := time.Now()
+	state, err := r.run(ctx, request.WorkDir, scratch, output, stderr, r.WeasyprintPath, args...)
+	recordProcessExit(ctx, state)
+	result := RenderResult{Usage: processUsage(state, time.Since(start)), Diagnostics: parseDiagnostics(stderr.String(), RequestIDFromContext(ctx))}
+	if err != nil {
+		stderrText := strings.TrimSpace(stderr.String())
+		return result, fmt.Errorf("weasyprint failed: %w: %s", classifyLimitError(err, stderrText, false), stderrText)
+	}
+	return result, nil
+}
+
+// CheckHealth verifies that Landlock is available, asks WeasyPrint for its version under the same
+// restrictions as a render and runs the isolation SelfTest.
+func (r LandlockRunner) CheckHealth(ctx context.Context) error {
+	r = r.current()
+	if _, err := landlockABI(); err != nil {
+		return err
+	}
+	if _, err := exec.LookPath(r.WeasyprintPath); err != nil {
+		return err
+	}
+	if _, err := os.Stat(r.defaultStylesheetPath()); err != nil {
+		return fmt.Errorf("default stylesheet: %w", err)
+	}
+
+	workDir, err := os.MkdirTemp("", "pdf-healthcheck-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	var stderr bytes.Buffer
+	if _, err := r.run(ctx, workDir, workDir, io.Discard, &stderr, r.WeasyprintPath, "--version"); err != nil {
+		return fmt.Errorf("sandbox failed to start: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return r.SelfTest(ctx)
+}
+
+// Version asks WeasyPrint for its version under the same restrictions as a render.
+func (r LandlockRunner) Version(ctx context.Context) (string, error) {
+	r = r.current()
+	workDir, err := os.MkdirTemp("", "pdf-version-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(workDir)
+
+	var stdout, stderr bytes.Buffer
+	if _, err := r.run(ctx, workDir, workDir, &stdout, &stderr, r.WeasyprintPath, "--version"); err != nil {
+		return "", fmt.Errorf("sandbox failed to start: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseWeasyprintVersion(stdout.Bytes()), nil
+}
+
+// SelfTest runs the same isolation probes as WeasyprintRunner.SelfTest under Landlock.
+func (r LandlockRunner) SelfTest(ctx context.Context) error {
+	r = r.current()
+	if _, err := landlockABI(); err != nil {
+		return err
+	}
+	workDir, err := os.MkdirTemp("", "pdf-selftest-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+	scratch, err := os.MkdirTemp("", "pdf-scratch-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch)
+
+	run := func(ctx context.Context, command ...string) ([]byte, error) {
+		var stdout, stderr bytes.Buffer
+		if _, err := r.run(ctx, workDir, scratch, &stdout, &stderr, command[0], command[1:]...); err != nil {
+			return nil, fmt.Errorf("sandbox failed to start: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return stdout.Bytes(), nil
+	}
+	allowedEnv := map[string]string{}
+	for _, variable := range landlockEnv(scratch) {
+		name, value, _ := strings.Cut(variable, "=")
+		allowedEnv[name] = value
+	}
+	expectations := sandboxProbeExpectations{
+		Dirs:      parentDirs(append(slices.Clone(r.readOnlyPaths()), workDir, scratch)),
+		Workspace: workDir,
+	}
+	return selfTestSandbox(ctx, run, allowedEnv, expectations)
+}
+
+// run starts name under Landlock in workDir and returns its state once it has exited. scratch is the only
+// writable directory.
+func (r LandlockRunner) run(ctx context.Context, workDir string, scratch string, stdout io.Writer, stderr io.Writer, name string, args ...string) (*os.ProcessState, error) {
+	stylesheet, err := filepath.Abs(r.defaultStylesheetPath())
+	if err != nil {
+		return nil, err
+	}
+	policy, err := json.Marshal(landlockPolicy{
+		Rlimits:   formatRlimits(r.Limits),
+		ReadOnly:  append(slices.Clone(r.readOnlyPaths()), workDir, stylesheet),
+		ReadWrite: []string{scratch, "/dev/null"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	helper, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate landlock exec helper: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, helper, append([]string{LandlockExecArg, string(policy), name}, args...)...)
+	cmd.Dir = workDir
+	cmd.Env = landlockEnv(scratch)
+	if id := RequestIDFromContext(ctx); id != "" {
+		cmd.Env = append(cmd.Env, sandboxRequestIDEnv+"="+id)
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	return cmd.ProcessState, err
+}
+
+func landlockEnv(scratch string) []string {
+	return []string{"PATH=/usr/local/bin:/usr/bin", "TMPDIR=" + scratch, "HOME=" + scratch}
+}
+
+func (r LandlockRunner) readOnlyPaths() []string {
+	if r.ReadOnlyPaths == nil {
+		return DefaultLandlockReadOnlyPaths
+	}
+	return r.ReadOnlyPaths
+}
+
+// current returns r with the settings of r.Reloadable in effect.
+func (r LandlockRunner) current() LandlockRunner {
+	if settings := r.Reloadable.load(); settings != nil {
+		r.DefaultStylesheetPath = settings.DefaultStylesheetPath
+		r.Limits = settings.Limits
+	}
+	return r
+}
+
+func (r LandlockRunner) defaultStylesheetPath() string {
+	if r.DefaultStylesheetPath == "" {
+		return "assets/default.css"
+	}
+	return r.DefaultStylesheetPath
+}
+
+// landlockExec restricts the calling thread according to the policy in args[0] and execs args[1:].
+func landlockExec(args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: landlock-exec <policy> <command> [args...]")
+	}
+	var policy landlockPolicy
+	if err := json.Unmarshal([]byte(args[0]), &policy); err != nil {
+		return fmt.Errorf("invalid policy: %w", err)
+	}
+
+	// Everything below applies to this thread only; exec replaces the process with it.
+	runtime.LockOSThread()
+
+	path, err := exec.LookPath(args[1])
+	if err != nil {
+		return err
+	}
+	abi, err := landlockABI()
+	if err != nil {
+		return err
+	}
+	// Landlock scopes signals from ABI 6. Before that the renderer, which runs as the service user, is given
+	// its own process group and seccomp keeps it from signalling the service or other renders.
+	signalPID := 0
+	if abi < 6 {
+		if err := unix.Setpgid(0, 0); err != nil {
+			return fmt.Errorf("failed to create a process group: %w", err)
+		}
+		signalPID = os.Getpid()
+	}
+	filter, err := newSeccompFilter(false, signalPID)
+	if err != nil {
+		return err
+	}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+	if err := restrictLandlock(policy); err != nil {
+		return err
+	}
+	if err := installSeccompFilter(filter); err != nil {
+		return err
+	}
+	if err := applyRlimits(policy.Rlimits); err != nil {
+		return err
+	}
+	return syscall.Exec(path, args[1:], os.Environ())
+}
+
+// landlockABI returns the Landlock ABI version of the running kernel.
+func landlockABI() (int, error) {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return 0, fmt.Errorf("landlock is not available: %w", errno)
+	}
+	return int(abi), nil
+}
+
+// landlockHandledAccess returns every filesystem right the kernel's ABI knows, so anything not granted
+// by a rule is denied.
+func landlockHandledAccess(abi int) uint64 {
+	access := uint64(unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR | unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK | unix.LANDLOCK_ACCESS_FS_MAKE_FIFO | unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM)
+	if abi >= 2 {
+		access |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		access |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	if abi >= 5 {
+		access |= unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+	return access
+}
+
+func restrictLandlock(policy landlockPolicy) error {
+	abi, err := landlockABI()
+	if err != nil {
+		return err
+	}
+
+	handled := landlockHandledAccess(abi)
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	if abi >= 4 {
+		// No network rules are added, so TCP bind and connect are denied. The seccomp filter denies sockets anyway.
+		attr.Access_net = unix.LANDLOCK_ACCESS_NET_BIND_TCP | unix.LANDLOCK_ACCESS_NET_CONNECT_TCP
+	}
+	if abi >= 6 {
+		attr.Scoped = unix.LANDLOCK_SCOPE_ABSTRACT_UNIX_SOCKET | unix.LANDLOCK_SCOPE_SIGNAL
+	}
+
+	ruleset, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to create landlock ruleset: %w", errno)
+	}
+	defer unix.Close(int(ruleset))
+
+	for _, path := range policy.ReadOnly {
+		if err := addLandlockRule(int(ruleset), path, landlockReadAccess&handled); err != nil {
+			return err
+		}
+	}
+	for _, path := range policy.ReadWrite {
+		if err := addLandlockRule(int(ruleset), path, (landlockReadAccess|landlockWriteAccess)&handled); err != nil {
+			return err
+		}
+	}
+
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, ruleset, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to enforce landlock ruleset: %w", errno)
+	}
+	return nil
+}
+
+// addLandlockRule grants access beneath path. Paths missing on this host (such as /lib64) are skipped.
+func addLandlockRule(ruleset int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s for landlock: %w", path, err)
+	}
+	defer unix.Close(fd)
+
+	var stat unix.Stat_t
+	if err := unix.Fstat(fd, &stat); err != nil {
+		return fmt.Errorf("failed to stat %s for landlock: %w", path, err)
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= landlockFileAccess
+	}
+
+	rule := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to add landlock rule for %s: %w", path, errno)
+	}
+	return nil
+}
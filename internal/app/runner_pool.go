@@ -0,0 +1,681 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// poolWorkerScript is the long-lived WeasyPrint worker. It speaks a length-prefixed protocol on
+// stdin/stdout: every frame is a 4 byte big-endian length followed by that many bytes.
+//
+//   - On startup, after importing WeasyPrint, the worker sends {"ready": true}.
+//   - A job is a JSON frame {"html", "stylesheets", "attachments", "files", "base_url", "options", "cpu_time",
+//     "request_id", "max_diagnostics"} followed by one frame per entry of "files" with its contents. Paths are relative to the job
+//     directory, except the default stylesheet, which is absolute. The request ID is set as REQUEST_ID in the
+//     environment of the worker while it renders the job.
+//   - The worker answers with a JSON frame {"error", "max_rss", "diagnostics", "pages", "user_cpu", "system_cpu"}
+//     and, when "error" is empty, a frame with the PDF.
+//
+// Each job is unpacked into its own directory under the sandbox /tmp, which is removed afterwards.
+const poolWorkerScript = `
+import json, logging, os, resource, shutil, struct, sys, tempfile
+
+stdin, stdout = sys.stdin.buffer, sys.stdout.buffer
+# Output of WeasyPrint or its dependencies must not corrupt the protocol.
+sys.stdout = sys.stderr
+
+def read_frame():
+    header = stdin.read(4)
+    if not header:
+        return None
+    if len(header) < 4:
+        raise EOFError("truncated frame header")
+    size, = struct.unpack(">I", header)
+    data = stdin.read(size)
+    if len(data) < size:
+        raise EOFError("truncated frame")
+    return data
+
+def write_frame(data):
+    stdout.write(struct.pack(">I", len(data)))
+    stdout.write(data)
+    stdout.flush()
+
+def write_json(value):
+    write_frame(json.dumps(value).encode())
+
+def max_rss():
+    return resource.getrusage(resource.RUSAGE_SELF).ru_maxrss * 1024
+
+def receive(request, directory):
+    # Every file frame is read, even after a failure, to stay in sync with the service.
+    error = None
+    for name in request["files"]:
+        data = read_frame()
+        if data is None:
+            raise EOFError("missing file frame")
+        if error:
+            continue
+        try:
+            path = os.path.join(directory, name)
+            os.makedirs(os.path.dirname(path), exist_ok=True)
+            with open(path, "wb") as f:
+                f.write(data)
+        except OSError as e:
+            error = e
+    if error:
+        raise error
+
+def limit_cpu(seconds):
+    # RLIMIT_CPU counts the whole life of the worker, so the limit is moved forward for every job.
+    if seconds:
+        usage = resource.getrusage(resource.RUSAGE_SELF)
+        _, hard = resource.getrlimit(resource.RLIMIT_CPU)
+        resource.setrlimit(resource.RLIMIT_CPU, (int(usage.ru_utime + usage.ru_stime) + seconds, hard))
+
+class Diagnostics(logging.Handler):
+    # Collects what WeasyPrint logs during a job, up to the max_diagnostics of the job, formatted like
+    # the WeasyPrint command line does.
+    def __init__(self):
+        super().__init__(logging.WARNING)
+        self.setFormatter(logging.Formatter("%(levelname)s: %(message)s"))
+        self.messages = []
+        self.max_messages = 0
+
+    def emit(self, record):
+        if len(self.messages) < self.max_messages:
+            self.messages.append(self.format(record))
+
+diagnostics = Diagnostics()
+logging.getLogger("weasyprint").addHandler(diagnostics)
+
+from weasyprint import CSS, HTML
+
+write_json({"ready": True})
+
+while True:
+    frame = read_frame()
+    if frame is None:
+        break
+    request = json.loads(frame)
+    directory = tempfile.mkdtemp(prefix="job-")
+    diagnostics.messages = []
+    diagnostics.max_messages = request["max_diagnostics"]
+    os.environ["REQUEST_ID"] = request["request_id"]
+    before = resource.getrusage(resource.RUSAGE_SELF)
+    result = {"error": "", "diagnostics": diagnostics.messages, "pages": 0}
+    try:
+        receive(request, directory)
+        limit_cpu(request["cpu_time"])
+        options = request["options"]
+        document = HTML(filename=os.path.join(directory, request["html"]), base_url=request["base_url"] or None).render(
+            stylesheets=[CSS(filename=os.path.join(directory, name)) for name in request["stylesheets"]], **options)
+        pdf = document.write_pdf(attachments=[os.path.join(directory, name) for name in request["attachments"]], **options)
+        result["pages"] = len(document.pages)
+    except EOFError:
+        raise
+    except Exception as e:
+        result["error"] = "%s: %s" % (type(e).__name__, e)
+    after = resource.getrusage(resource.RUSAGE_SELF)
+    result.update(max_rss=max_rss(), user_cpu=after.ru_utime - before.ru_utime, system_cpu=after.ru_stime - before.ru_stime)
+    write_json(result)
+    if not result["error"]:
+        write_frame(pdf)
+    shutil.rmtree(directory, ignore_errors=True)
+`
+
+// poolWorkerStartTimeout bounds how long a worker may take to import WeasyPrint and report ready.
+const poolWorkerStartTimeout = time.Minute
+
+// poolMaxJSONFrame bounds the JSON frames read from a worker.
+const poolMaxJSONFrame = 1 << 20
+
+// poolStderrTail is how much of a worker's stderr is kept for error messages.
+const poolStderrTail = 8 << 10
+
+// PoolConfig sizes a PooledRunner.
+type PoolConfig struct {
+	// Size is the number of workers kept running.
+	Size int
+	// PythonPath is the interpreter that runs the worker; it must be able to import weasyprint.
+	PythonPath string
+	// MaxJobsPerWorker retires a worker after this many renders. Zero never retires a worker by job count.
+	MaxJobsPerWorker int
+	// MaxWorkerMemoryBytes retires a worker once its peak resident memory exceeds it. Zero disables the check.
+	MaxWorkerMemoryBytes int64
+}
+
+// PooledRunner renders with persistent WeasyPrint workers, each in its own bwrap sandbox built like the
+// sandbox of WeasyprintRunner, so a render does not pay for starting Python and importing WeasyPrint.
+// A worker renders one job at a time; a job that crashes or fails its worker only fails itself and the
+// worker is replaced.
+type PooledRunner struct {
+	sandbox WeasyprintRunner
+	config  PoolConfig
+	// slots holds one entry per worker: an idle worker, or nil when the worker must be started on use.
+	slots  chan *poolWorker
+	closed atomic.Bool
+	// command builds the worker process. Tests run the worker without a sandbox.
+	command func() (*exec.Cmd, func(), error)
+}
+
+// NewPooledRunner starts config.Size workers in the background.
+func NewPooledRunner(sandbox WeasyprintRunner, config PoolConfig) *PooledRunner {
+	if config.PythonPath == "" {
+		config.PythonPath = "python3"
+	}
+	p := &PooledRunner{sandbox: sandbox, config: config}
+	return p.start(p.sandboxCommand)
+}
+
+// start fills the pool with workers built by command.
+func (p *PooledRunner) start(command func() (*exec.Cmd, func(), error)) *PooledRunner {
+	p.command = command
+	p.slots = make(chan *poolWorker, max(p.config.Size, 1))
+	for range cap(p.slots) {
+		go p.replaceWorker()
+	}
+	return p
+}
+
+// Capabilities leaves out CapabilityLimits: the limits are set once per worker, except the CPU time.
+func (p *PooledRunner) Capabilities() []string {
+	return []string{CapabilityStylesheet, CapabilityAttachments, CapabilityAssets, CapabilityBaseURL, CapabilityOptions}
+}
+
+// GeneratePDF renders on an idle worker. Of request.Limits only the CPU time applies; the other limits
+// are set once per worker.
+func (p *PooledRunner) GeneratePDF(ctx context.Context, request RenderRequest, output io.Writer) (RenderResult, error) {
+	if p.closed.Load() {
+		return RenderResult{}, errPoolClosed
+	}
+
+	var worker *poolWorker
+	select {
+	case worker = <-p.slots:
+	case <-ctx.Done():
+		return RenderResult{}, fmt.Errorf("waiting for a weasyprint worker: %w", ctx.Err())
+	}
+
+	if worker != nil && worker.settings != p.sandbox.Reloadable.load() {
+		// The worker was started before a reload, so the render gets a worker with the current settings.
+		worker.stop()
+		worker = nil
+	}
+	if worker == nil {
+		started, err := p.startWorker(ctx)
+		if err != nil {
+			p.slots <- nil
+			return RenderResult{}, err
+		}
+		worker = started
+	}
+
+	job := poolJob{
+		HTML:           request.HTMLFilename,
+		Stylesheets:    []string{},
+		Attachments:    request.attachmentFilenames(),
+		BaseURL:        request.BaseURL,
+		Options:        map[string]any{},
+		CPUTime:        int64(p.sandbox.current().Limits.tighten(request.Limits).CPUTime / time.Second),
+		RequestID:      RequestIDFromContext(ctx),
+		MaxDiagnostics: renderMaxDiagnostics,
+	}
+	for _, stylesheet := range request.Stylesheets {
+		if stylesheet == defaultStylesheetPath {
+			stylesheet = sandboxDefaultStylesheetPath
+		}
+		job.Stylesheets = append(job.Stylesheets, stylesheet)
+	}
+	if request.Options.PDFVariant != "" {
+		job.Options["pdf_variant"] = request.Options.PDFVariant
+	}
+	if request.Options.PresentationalHints {
+		job.Options["presentational_hints"] = true
+	}
+
+	start := time.Now()
+	response, err := worker.render(ctx, request.WorkDir, job, output)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("pdf.pool.worker.pid", worker.cmd.Process.Pid),
+		attribute.Int("pdf.pool.worker.jobs", worker.jobs))
+	result := RenderResult{
+		Diagnostics: parseDiagnostics(strings.Join(response.Diagnostics, "\n"), job.RequestID),
+		PageCount:   response.Pages,
+		Usage: ResourceUsage{
+			WallTime:    time.Since(start),
+			UserCPU:     time.Duration(response.UserCPU * float64(time.Second)),
+			SystemCPU:   time.Duration(response.SystemCPU * float64(time.Second)),
+			MaxRSSBytes: response.MaxRSS,
+		},
+	}
+	if err == nil && p.reusable(worker) {
+		p.slots <- worker
+		return result, nil
+	}
+	worker.stop()
+	go p.replaceWorker()
+	return result, err
+}
+
+// Close stops the workers. It waits for renders in progress to finish; later renders fail.
+func (p *PooledRunner) Close() {
+	if p.closed.Swap(true) {
+		return
+	}
+	for range cap(p.slots) {
+		if worker := <-p.slots; worker != nil {
+			worker.stop()
+		}
+	}
+}
+
+// CheckHealth checks the sandbox the workers run in.
+func (p *PooledRunner) CheckHealth(ctx context.Context) error {
+	return p.sandbox.CheckHealth(ctx)
+}
+
+// Version reports the version of WeasyPrint in the sandbox the workers run in.
+func (p *PooledRunner) Version(ctx context.Context) (string, error) {
+	return p.sandbox.Version(ctx)
+}
+
+// SelfTest verifies the isolation of the sandbox the workers run in.
+func (p *PooledRunner) SelfTest(ctx context.Context) error {
+	return p.sandbox.SelfTest(ctx)
+}
+
+// reusable reports whether worker may take another job. A worker that failed a job is never reused,
+// because its interpreter may be left in a bad state, for example after a MemoryError. A worker started
+// before the settings of the sandbox were reloaded is replaced by one started with the new settings.
+func (p *PooledRunner) reusable(worker *poolWorker) bool {
+	if p.closed.Load() || worker.settings != p.sandbox.Reloadable.load() {
+		return false
+	}
+	if p.config.MaxJobsPerWorker > 0 && worker.jobs >= p.config.MaxJobsPerWorker {
+		return false
+	}
+	return p.config.MaxWorkerMemoryBytes == 0 || worker.maxRSS < p.config.MaxWorkerMemoryBytes
+}
+
+// replaceWorker fills a slot with a new worker. If the worker fails to start the slot is left empty
+// and the next render starts a worker itself, reporting the error.
+func (p *PooledRunner) replaceWorker() {
+	if p.closed.Load() {
+		p.slots <- nil
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), poolWorkerStartTimeout)
+	defer cancel()
+	worker, err := p.startWorker(ctx)
+	if err != nil {
+		worker = nil
+	}
+	p.slots <- worker
+}
+
+// sandboxCommand starts the worker in the render sandbox, without a workspace. RLIMIT_CPU is not set on
+// the worker; the worker limits the CPU time of every job itself.
+func (p *PooledRunner) sandboxCommand() (*exec.Cmd, func(), error) {
+	sandbox := p.sandbox.current()
+	sandbox.Limits.CPUTime = 0
+	args := append(sandbox.sandboxArgs(""), p.config.PythonPath, "-c", poolWorkerScript)
+	return sandbox.sandboxCommand(context.Background(), args)
+}
+
+func (p *PooledRunner) startWorker(ctx context.Context) (*poolWorker, error) {
+	// The settings are read before the command is built, so a concurrent reload at worst retires the worker early.
+	settings := p.sandbox.Reloadable.load()
+	cmd, cleanup, err := p.command()
+	if err != nil {
+		return nil, err
+	}
+
+	worker := &poolWorker{cmd: cmd, settings: settings, stderr: &tailBuffer{limit: poolStderrTail}, done: make(chan struct{})}
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		cleanup()
+		_ = stdinReader.Close()
+		_ = stdinWriter.Close()
+		return nil, err
+	}
+	// The pipes are created here rather than with StdinPipe/StdoutPipe so that Wait, which runs as
+	// soon as the worker starts, does not close them while a response is being read.
+	cmd.Stdin = stdinReader
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = worker.stderr
+	worker.stdin = stdinWriter
+	worker.stdout = bufio.NewReader(stdoutReader)
+	worker.closePipes = func() {
+		_ = stdinWriter.Close()
+		_ = stdoutReader.Close()
+	}
+
+	worker.cgroup, err = p.sandbox.current().useCgroup(cmd)
+	if err == nil {
+		err = cmd.Start()
+	}
+	_ = stdinReader.Close()
+	_ = stdoutWriter.Close()
+	if err != nil {
+		worker.closePipes()
+		if worker.cgroup != nil {
+			worker.cgroup.close()
+		}
+		cleanup()
+		return nil, fmt.Errorf("failed to start weasyprint worker: %w", err)
+	}
+	go func() {
+		worker.waitErr = cmd.Wait()
+		cleanup()
+		close(worker.done)
+	}()
+
+	stop := context.AfterFunc(ctx, worker.kill)
+	defer stop()
+	var ready struct {
+		Ready bool `json:"ready"`
+	}
+	if err := readJSONFrame(worker.stdout, &ready); err != nil {
+		return nil, worker.failed(ctx, fmt.Errorf("weasyprint worker did not start: %w", err))
+	}
+	if !ready.Ready {
+		return nil, worker.failed(ctx, errors.New("weasyprint worker did not report ready"))
+	}
+	return worker, nil
+}
+
+// poolJob is the JSON header of a job.
+type poolJob struct {
+	HTML        string   `json:"html"`
+	Stylesheets []string `json:"stylesheets"`
+	Attachments []string `json:"attachments"`
+	Files       []string `json:"files"`
+	BaseURL     string   `json:"base_url"`
+	// Options are keyword arguments of WeasyPrint's render and write_pdf.
+	Options map[string]any `json:"options"`
+	// CPUTime is the CPU time limit of the job in seconds; zero disables it.
+	CPUTime int64 `json:"cpu_time"`
+	// RequestID is the ID of the request the job renders.
+	RequestID string `json:"request_id"`
+	// MaxDiagnostics bounds the log records the worker collects for the job.
+	MaxDiagnostics int `json:"max_diagnostics"`
+}
+
+// poolResponse is the JSON header of a job's result.
+type poolResponse struct {
+	Error  string `json:"error"`
+	MaxRSS int64  `json:"max_rss"`
+	// Diagnostics are the formatted log records of the job.
+	Diagnostics []string `json:"diagnostics"`
+	Pages       int      `json:"pages"`
+	// UserCPU and SystemCPU are the CPU seconds the job used.
+	UserCPU   float64 `json:"user_cpu"`
+	SystemCPU float64 `json:"system_cpu"`
+}
+
+type poolWorker struct {
+	cmd        *exec.Cmd
+	stdin      io.Writer
+	stdout     *bufio.Reader
+	stderr     *tailBuffer
+	closePipes func()
+	cgroup     *renderCgroup
+	stopOnce   sync.Once
+
+	// done is closed once the worker has exited; waitErr is its exit status.
+	done    chan struct{}
+	waitErr error
+
+	jobs   int
+	maxRSS int64
+	// settings are the sandbox settings the worker was started with.
+	settings *RunnerSettings
+}
+
+func (w *poolWorker) render(ctx context.Context, workDir string, job poolJob, output io.Writer) (poolResponse, error) {
+	w.jobs++
+	w.stderr.Reset()
+
+	stop := context.AfterFunc(ctx, w.kill)
+	target := &outputWriter{Writer: output}
+	response, err := w.exchange(workDir, job, target)
+	// When stop reports false the worker was killed, possibly after the result was complete.
+	killed := !stop()
+	switch {
+	case target.err != nil:
+		return response, fmt.Errorf("failed to write pdf: %w", target.err)
+	case err != nil:
+		return response, w.failed(ctx, err)
+	case killed:
+		return response, w.failed(ctx, ctx.Err())
+	}
+
+	w.maxRSS = response.MaxRSS
+	if response.Error != "" {
+		return response, classifyWorkerError(response.Error)
+	}
+	return response, nil
+}
+
+// exchange sends job with the files of workDir and copies the resulting PDF to output.
+func (w *poolWorker) exchange(workDir string, job poolJob, output io.Writer) (poolResponse, error) {
+	var response poolResponse
+	job.Files = []string{}
+
+	root, err := os.OpenRoot(workDir)
+	if err != nil {
+		return response, err
+	}
+	defer root.Close()
+
+	err = fs.WalkDir(root.FS(), ".", func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.Type().IsRegular() {
+			job.Files = append(job.Files, filepath.ToSlash(path))
+		}
+		return err
+	})
+	if err != nil {
+		return response, err
+	}
+
+	stdin := bufio.NewWriter(w.stdin)
+	header, err := json.Marshal(job)
+	if err != nil {
+		return response, err
+	}
+	if err := writeFrame(stdin, header); err != nil {
+		return response, err
+	}
+	for _, name := range job.Files {
+		if err := writeFileFrame(stdin, root, name); err != nil {
+			return response, err
+		}
+	}
+	if err := stdin.Flush(); err != nil {
+		return response, err
+	}
+
+	if err := readJSONFrame(w.stdout, &response); err != nil {
+		return response, err
+	}
+	if response.Error != "" {
+		return response, nil
+	}
+	size, err := readFrameSize(w.stdout)
+	if err != nil {
+		return response, err
+	}
+	if _, err := io.CopyN(output, w.stdout, int64(size)); err != nil {
+		return response, err
+	}
+	return response, nil
+}
+
+// failed stops the worker after cause broke the protocol and explains why, preferring the worker's
+// own exit status over the protocol error it caused.
+func (w *poolWorker) failed(ctx context.Context, cause error) error {
+	w.stop()
+	if ctx.Err() != nil {
+		return fmt.Errorf("weasyprint worker was stopped: %w", ctx.Err())
+	}
+	stderr := strings.TrimSpace(w.stderr.String())
+	err := cause
+	if w.waitErr != nil {
+		err = classifyLimitError(w.waitErr, stderr, w.cgroup != nil && w.cgroup.oomKilled())
+	}
+	return fmt.Errorf("weasyprint worker failed: %w: %s", err, stderr)
+}
+
+func (w *poolWorker) kill() {
+	_ = w.cmd.Process.Kill()
+}
+
+// stop closes the worker's stdin, which ends it, and kills it if it does not exit promptly.
+func (w *poolWorker) stop() {
+	w.stopOnce.Do(func() {
+		w.closePipes()
+		select {
+		case <-w.done:
+		case <-time.After(5 * time.Second):
+			w.kill()
+			<-w.done
+		}
+		if w.cgroup != nil {
+			w.cgroup.close()
+		}
+	})
+}
+
+// classifyWorkerError turns an exception reported by the worker into an error, recognizing resource limits.
+func classifyWorkerError(message string) error {
+	err := fmt.Errorf("weasyprint failed: %s", message)
+	switch {
+	case strings.HasPrefix(message, "MemoryError"):
+		return &ResourceLimitError{Resource: ResourceMemory, Cause: err}
+	case strings.Contains(message, "[Errno 27]"):
+		// EFBIG: Python ignores SIGXFSZ, so RLIMIT_FSIZE surfaces as an OSError.
+		return &ResourceLimitError{Resource: ResourceFileSize, Cause: err}
+	}
+	return err
+}
+
+func writeFrame(w io.Writer, data []byte) error {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func writeFileFrame(w io.Writer, root *os.Root, name string) error {
+	file, err := root.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > math.MaxUint32 {
+		return fmt.Errorf("%s is too large for a weasyprint worker", name)
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(info.Size())); err != nil {
+		return err
+	}
+	_, err = io.CopyN(w, file, info.Size())
+	return err
+}
+
+func readFrameSize(r io.Reader) (uint32, error) {
+	var size uint32
+	err := binary.Read(r, binary.BigEndian, &size)
+	return size, err
+}
+
+func readJSONFrame(r io.Reader, v any) error {
+	size, err := readFrameSize(r)
+	if err != nil {
+		return err
+	}
+	if size > poolMaxJSONFrame {
+		return fmt.Errorf("worker frame of %d bytes is too large", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// outputWriter records whether anything was written, and write errors, so a failing output is not
+// mistaken for a failing worker or node.
+type outputWriter struct {
+	io.Writer
+	written bool
+	err     error
+}
+
+func (o *outputWriter) Write(p []byte) (int, error) {
+	n, err := o.Writer.Write(p)
+	o.written = o.written || n > 0
+	if err != nil {
+		o.err = err
+	}
+	return n, err
+}
+
+// tailBuffer keeps the last limit bytes written to it. It is safe for concurrent use.
+type tailBuffer struct {
+	mu    sync.Mutex
+	limit int
+	data  []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = append(b.data, p...)
+	if len(b.data) > b.limit {
+		b.data = append(b.data[:0], b.data[len(b.data)-b.limit:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = b.data[:0]
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data)
+}
+
+// errPoolClosed is returned for renders started after Close.
+var errPoolClosed = errors.New("weasyprint worker pool is closed")
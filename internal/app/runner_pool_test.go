@@ -0,0 +1,242 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeWeasyprintModule replaces WeasyPrint for the worker script. The HTML file holds a command for it.
+const fakeWeasyprintModule = `
+import logging, os, time
+
+class CSS:
+    def __init__(self, filename):
+        self.filename = filename
+
+class HTML:
+    def __init__(self, filename, base_url=None):
+        self.filename = filename
+
+    def render(self, stylesheets, **options):
+        with open(self.filename) as f:
+            command = f.read().strip()
+        if command == "crash":
+            os._exit(3)
+        if command == "memory":
+            raise MemoryError()
+        if command == "sleep":
+            time.sleep(60)
+        if command == "warn":
+            logging.getLogger("weasyprint").warning("Ignored unknown property")
+        if command == "flood":
+            for i in range(10):
+                logging.getLogger("weasyprint").warning("Ignored property %d", i)
+        if command == "request-id":
+            logging.getLogger("weasyprint").warning("Rendering %s", os.environ["REQUEST_ID"])
+        return Document([os.path.basename(s.filename) for s in stylesheets])
+
+class Document:
+    def __init__(self, names):
+        self.names = names
+        self.pages = [None, None]
+
+    def write_pdf(self, attachments, **options):
+        names = self.names + [os.path.basename(a) for a in attachments]
+        return ("%%PDF pid=%d " % os.getpid() + " ".join(names)).encode()
+`
+
+func TestPooledRunnerReusesWorkers(t *testing.T) {
+	pool := newTestPool(t, PoolConfig{Size: 1})
+
+	first, err := renderWithPool(t, pool, "render")
+	assert.NoError(t, err)
+	assert.Contains(t, first, "style.css a.txt")
+	second, err := renderWithPool(t, pool, "render")
+	assert.NoError(t, err)
+
+	assert.Equal(t, workerPID(first), workerPID(second))
+}
+
+func TestPooledRunnerReportsPagesAndDiagnostics(t *testing.T) {
+	pool := newTestPool(t, PoolConfig{Size: 1})
+
+	result, err := pool.GeneratePDF(context.Background(), workspaceRequest(t, "warn"), &bytes.Buffer{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.PageCount)
+	assert.Equal(t, []Diagnostic{{Level: DiagnosticLevelWarning, Message: "Ignored unknown property"}}, result.Diagnostics)
+	assert.Positive(t, result.Usage.MaxRSSBytes)
+}
+
+func TestPooledRunnerPassesTheRequestID(t *testing.T) {
+	pool := newTestPool(t, PoolConfig{Size: 1})
+
+	ctx := contextWithRequestID(context.Background(), "req-123")
+	result, err := pool.GeneratePDF(ctx, workspaceRequest(t, "request-id"), io.Discard)
+	assert.NoError(t, err)
+	assert.Equal(t, []Diagnostic{{Level: DiagnosticLevelWarning, Message: "Rendering req-123", RequestID: "req-123"}}, result.Diagnostics)
+}
+
+func TestPoolWorkerKeepsTheDiagnosticsOfTheJob(t *testing.T) {
+	pool := newTestPool(t, PoolConfig{Size: 1})
+	worker, err := pool.startWorker(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer worker.stop()
+
+	request := workspaceRequest(t, "flood")
+	job := poolJob{HTML: request.HTMLFilename, Stylesheets: []string{}, Attachments: []string{}, Options: map[string]any{}, MaxDiagnostics: 3}
+	response, err := worker.render(context.Background(), request.WorkDir, job, io.Discard)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"WARNING: Ignored property 0", "WARNING: Ignored property 1", "WARNING: Ignored property 2"}, response.Diagnostics)
+}
+
+func TestPooledRunnerRetiresWorkers(t *testing.T) {
+	tests := []struct {
+		name   string
+		config PoolConfig
+	}{
+		{name: "after max jobs", config: PoolConfig{Size: 1, MaxJobsPerWorker: 1}},
+		{name: "after memory growth", config: PoolConfig{Size: 1, MaxWorkerMemoryBytes: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newTestPool(t, tt.config)
+
+			first, err := renderWithPool(t, pool, "render")
+			assert.NoError(t, err)
+			second, err := renderWithPool(t, pool, "render")
+			assert.NoError(t, err)
+
+			assert.NotEqual(t, workerPID(first), workerPID(second))
+		})
+	}
+}
+
+func TestPooledRunnerCrashFailsOnlyItsJob(t *testing.T) {
+	pool := newTestPool(t, PoolConfig{Size: 1})
+
+	_, err := renderWithPool(t, pool, "crash")
+	assert.ErrorContains(t, err, "weasyprint worker failed")
+
+	output, err := renderWithPool(t, pool, "render")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(output, "%PDF"))
+}
+
+func TestPooledRunnerReportsMemoryErrors(t *testing.T) {
+	pool := newTestPool(t, PoolConfig{Size: 1})
+
+	_, err := renderWithPool(t, pool, "memory")
+
+	var limitErr *ResourceLimitError
+	if assert.ErrorAs(t, err, &limitErr) {
+		assert.Equal(t, ResourceMemory, limitErr.Resource)
+	}
+}
+
+func TestPooledRunnerStopsCancelledJobs(t *testing.T) {
+	pool := newTestPool(t, PoolConfig{Size: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err := pool.GeneratePDF(ctx, workspaceRequest(t, "sleep"), &bytes.Buffer{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	_, err = renderWithPool(t, pool, "render")
+	assert.NoError(t, err)
+}
+
+func TestPooledRunnerRejectsRendersAfterClose(t *testing.T) {
+	pool := newTestPool(t, PoolConfig{Size: 2})
+	pool.Close()
+
+	_, err := renderWithPool(t, pool, "render")
+
+	assert.ErrorIs(t, err, errPoolClosed)
+}
+
+func TestTailBufferKeepsTheEnd(t *testing.T) {
+	buffer := &tailBuffer{limit: 4}
+	_, _ = buffer.Write([]byte("abc"))
+	_, _ = buffer.Write([]byte("def"))
+
+	assert.Equal(t, "cdef", buffer.String())
+}
+
+// newTestPool runs the worker script without a sandbox, with WeasyPrint replaced by fakeWeasyprintModule.
+func TestPooledRunnerReplacesWorkersAfterReload(t *testing.T) {
+	settings := NewReloadableSettings(RunnerSettings{})
+	pool := startTestPool(t, &PooledRunner{sandbox: WeasyprintRunner{Reloadable: settings}, config: PoolConfig{Size: 1}})
+
+	first, err := renderWithPool(t, pool, "render")
+	assert.NoError(t, err)
+	settings.Store(RunnerSettings{Limits: ResourceLimits{CPUTime: time.Minute}})
+	second, err := renderWithPool(t, pool, "render")
+	assert.NoError(t, err)
+	third, err := renderWithPool(t, pool, "render")
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, workerPID(first), workerPID(second))
+	assert.Equal(t, workerPID(second), workerPID(third))
+}
+
+func newTestPool(t *testing.T, config PoolConfig) *PooledRunner {
+	t.Helper()
+	return startTestPool(t, &PooledRunner{config: config})
+}
+
+// startTestPool starts pool with workers that run outside a sandbox and import fakeWeasyprintModule.
+func startTestPool(t *testing.T, pool *PooledRunner) *PooledRunner {
+	t.Helper()
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not available in PATH")
+	}
+	modules := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(modules, "weasyprint.py"), []byte(fakeWeasyprintModule), 0o600))
+
+	pool.start(func() (*exec.Cmd, func(), error) {
+		cmd := exec.Command("python3", "-c", poolWorkerScript)
+		cmd.Env = append(os.Environ(), "PYTHONPATH="+modules)
+		return cmd, func() {}, nil
+	})
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func renderWithPool(t *testing.T, pool *PooledRunner, command string) (string, error) {
+	t.Helper()
+	var output bytes.Buffer
+	_, err := pool.GeneratePDF(context.Background(), workspaceRequest(t, command), &output)
+	return output.String(), err
+}
+
+// workspaceRequest writes a workspace whose HTML file holds command for fakeWeasyprintModule.
+func workspaceRequest(t *testing.T, command string) RenderRequest {
+	t.Helper()
+	workDir := t.TempDir()
+	for name, content := range map[string]string{"index.html": command, "style.css": "body {}", "a.txt": "attachment"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(workDir, name), []byte(content), 0o600))
+	}
+	return RenderRequest{
+		WorkDir:      workDir,
+		HTMLFilename: "index.html",
+		Stylesheets:  []string{"style.css"},
+		Attachments:  []RenderAttachment{{Filename: "a.txt"}},
+	}
+}
+
+func workerPID(output string) string {
+	pid, _, _ := strings.Cut(strings.TrimPrefix(output, "%PDF pid="), " ")
+	return pid
+}
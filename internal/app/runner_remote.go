@@ -0,0 +1,329 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// remoteHealthTimeout bounds a single /readyz probe of a render node.
+const remoteHealthTimeout = 5 * time.Second
+
+// errRenderNodeUnavailable marks failures after which a render is retried on another node: the node
+// could not be reached or answered that it cannot take the render now.
+var errRenderNodeUnavailable = errors.New("render node unavailable")
+
+// RemoteRunner renders on other instances of this service, so the API tier and the sandbox tier can
+// scale separately. The workspace is sent to a node's POST /pdf as a multipart request signed with an
+// HMAC key the node accepts.
+//
+// Nodes are probed on /readyz every health interval. A render goes to the healthy node with the fewest
+// renders in flight from this runner; when a node cannot be reached or answers 502, 503 or 504 before
+// any output was received, it is marked unhealthy and the render fails over to the next node.
+type RemoteRunner struct {
+	nodes  []*remoteNode
+	key    HMACKey
+	client *http.Client
+	// next rotates between nodes with the same load.
+	next      atomic.Uint64
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+type remoteNode struct {
+	url      *url.URL
+	healthy  atomic.Bool
+	inFlight atomic.Int64
+}
+
+// NewRemoteRunner probes nodes every healthInterval until Close. Nodes are assumed healthy until a probe
+// or a render fails.
+func NewRemoteRunner(nodeURLs []string, key HMACKey, healthInterval time.Duration, obs Observability) (*RemoteRunner, error) {
+	if len(nodeURLs) == 0 {
+		return nil, errors.New("at least one render node is required")
+	}
+	r := &RemoteRunner{
+		key:    key,
+		client: obs.HttpClient(nil),
+		stop:   make(chan struct{}),
+	}
+	for _, raw := range nodeURLs {
+		nodeURL, err := url.Parse(raw)
+		if err != nil || (nodeURL.Scheme != "http" && nodeURL.Scheme != "https") || nodeURL.Host == "" {
+			return nil, fmt.Errorf("invalid render node url %q", raw)
+		}
+		node := &remoteNode{url: nodeURL}
+		node.healthy.Store(true)
+		r.nodes = append(r.nodes, node)
+	}
+
+	go r.monitor(healthInterval)
+	return r, nil
+}
+
+// Close stops the health probes.
+func (r *RemoteRunner) Close() {
+	r.closeOnce.Do(func() { close(r.stop) })
+}
+
+// Capabilities are the request features a render node receives: the parts of the workspace, but not the
+// base URL, options or limits of the request.
+func (r *RemoteRunner) Capabilities() []string {
+	return []string{CapabilityStylesheet, CapabilityAttachments, CapabilityAssets}
+}
+
+// GeneratePDF forwards the workspace of request to a render node. The result reports the wall time, the
+// diagnostics the node summarised in its response headers and, when the node sends usage headers, the CPU
+// time and memory of its render.
+func (r *RemoteRunner) GeneratePDF(ctx context.Context, request RenderRequest, output io.Writer) (RenderResult, error) {
+	if request.BaseURL != "" || request.Options != (RenderOptions{}) || request.Limits != (ResourceLimits{}) {
+		return RenderResult{}, errors.New("render options, base url and limits cannot be forwarded to a render node")
+	}
+	start := time.Now()
+	result, err := r.forward(ctx, request, output)
+	result.Usage.WallTime = time.Since(start)
+	return result, err
+}
+
+func (r *RemoteRunner) forward(ctx context.Context, renderRequest RenderRequest, output io.Writer) (RenderResult, error) {
+	body, err := os.CreateTemp("", "pdf-remote-*")
+	if err != nil {
+		return RenderResult{}, err
+	}
+	defer func() {
+		_ = body.Close()
+		_ = os.Remove(body.Name())
+	}()
+
+	digest := sha256.New()
+	contentType, err := writeRemoteRequest(io.MultiWriter(body, digest), renderRequest)
+	if err != nil {
+		return RenderResult{}, fmt.Errorf("failed to prepare remote render: %w", err)
+	}
+	size, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return RenderResult{}, err
+	}
+	request := remoteRequest{body: body, size: size, contentType: contentType, digest: hex.EncodeToString(digest.Sum(nil))}
+
+	var failures []error
+	for _, node := range r.candidates() {
+		target := &outputWriter{Writer: output}
+		result, err := r.renderOn(ctx, node, request, target)
+		if err == nil || !errors.Is(err, errRenderNodeUnavailable) || target.written || ctx.Err() != nil {
+			return result, err
+		}
+		node.healthy.Store(false)
+		failures = append(failures, err)
+	}
+	return RenderResult{}, fmt.Errorf("no render node could take the render: %w", errors.Join(failures...))
+}
+
+// CheckHealth reports whether at least one render node is healthy.
+func (r *RemoteRunner) CheckHealth(ctx context.Context) error {
+	for _, node := range r.nodes {
+		if node.healthy.Load() {
+			return nil
+		}
+	}
+	return errors.New("no healthy render nodes")
+}
+
+type remoteRequest struct {
+	body        *os.File
+	size        int64
+	contentType string
+	digest      string
+}
+
+func (r *RemoteRunner) renderOn(ctx context.Context, node *remoteNode, request remoteRequest, output *outputWriter) (RenderResult, error) {
+	node.inFlight.Add(1)
+	defer node.inFlight.Add(-1)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, node.url.JoinPath("/pdf").String(), io.NewSectionReader(request.body, 0, request.size))
+	if err != nil {
+		return RenderResult{}, err
+	}
+	req.ContentLength = request.size
+	req.Header.Set("Content-Type", request.contentType)
+	if id := RequestIDFromContext(ctx); id != "" {
+		// The node logs the render under the same ID.
+		req.Header.Set(RequestIDHeader, id)
+	}
+	signRequestDigest(req, r.key, request.digest, time.Now(), rand.Text())
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return RenderResult{}, fmt.Errorf("%w: %s: %w", errRenderNodeUnavailable, node.url, err)
+	}
+	defer resp.Body.Close()
+
+	result := RenderResult{Diagnostics: parseDiagnosticsHeader(resp.Header)}
+	parseUsageHeaders(resp.Header, &result.Usage)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if _, err := io.Copy(output, resp.Body); err != nil {
+			return result, fmt.Errorf("failed to receive pdf from %s: %w", node.url, err)
+		}
+		return result, nil
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return RenderResult{}, fmt.Errorf("%w: %s returned %d", errRenderNodeUnavailable, node.url, resp.StatusCode)
+	}
+
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	err = fmt.Errorf("render node %s returned %d: %s", node.url, resp.StatusCode, strings.TrimSpace(string(message)))
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		if resource, ok := parseResourceLimitMessage(string(message)); ok {
+			return result, &ResourceLimitError{Resource: resource, Cause: err}
+		}
+	}
+	return result, err
+}
+
+// candidates orders the nodes to try: healthy nodes by ascending load, then the unhealthy ones, which
+// may have recovered since their last probe.
+func (r *RemoteRunner) candidates() []*remoteNode {
+	offset := int(r.next.Add(1) % uint64(len(r.nodes)))
+	nodes := append(slices.Clone(r.nodes[offset:]), r.nodes[:offset]...)
+	slices.SortStableFunc(nodes, func(a, b *remoteNode) int {
+		if a.healthy.Load() != b.healthy.Load() {
+			if a.healthy.Load() {
+				return -1
+			}
+			return 1
+		}
+		return int(a.inFlight.Load() - b.inFlight.Load())
+	})
+	return nodes
+}
+
+func (r *RemoteRunner) monitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.probeNodes()
+		}
+	}
+}
+
+// probeNodes updates the health of every node from its /readyz.
+func (r *RemoteRunner) probeNodes() {
+	var wg sync.WaitGroup
+	for _, node := range r.nodes {
+		wg.Go(func() {
+			node.healthy.Store(r.probe(node) == nil)
+		})
+	}
+	wg.Wait()
+}
+
+func (r *RemoteRunner) probe(node *remoteNode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteHealthTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, node.url.JoinPath("/readyz").String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s is not ready: %d", node.url, resp.StatusCode)
+	}
+	return nil
+}
+
+// writeRemoteRequest writes the workspace of request as the multipart body of POST /pdf: the html part,
+// the css parts and the attachments in order, then every other file as an asset. The default stylesheet
+// is not sent; the node applies its own.
+func writeRemoteRequest(w io.Writer, request RenderRequest) (string, error) {
+	root, err := os.OpenRoot(request.WorkDir)
+	if err != nil {
+		return "", err
+	}
+	defer root.Close()
+
+	type part struct{ field, filename string }
+	parts := []part{{field: "html", filename: request.HTMLFilename}}
+	for _, stylesheet := range request.Stylesheets {
+		if stylesheet != defaultStylesheetPath {
+			parts = append(parts, part{field: "css", filename: stylesheet})
+		}
+	}
+	for i, attachment := range request.Attachments {
+		parts = append(parts, part{field: "attachment." + strconv.Itoa(i), filename: attachment.Filename})
+	}
+	sent := map[string]bool{}
+	for _, p := range parts {
+		sent[p.filename] = true
+	}
+	err = fs.WalkDir(root.FS(), ".", func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.Type().IsRegular() && !sent[path] {
+			parts = append(parts, part{field: "asset", filename: path})
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	writer := multipart.NewWriter(w)
+	for _, p := range parts {
+		if err := writeRemotePart(writer, root, p.field, p.filename); err != nil {
+			return "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return writer.FormDataContentType(), nil
+}
+
+func writeRemotePart(writer *multipart.Writer, root *os.Root, field string, filename string) error {
+	file, err := root.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	part, err := writer.CreateFormFile(field, filename)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, file)
+	return err
+}
+
+// parseResourceLimitMessage extracts the resource from the message of a 422 response, which is on its first
+// line.
+func parseResourceLimitMessage(message string) (string, bool) {
+	message, _, _ = strings.Cut(strings.TrimSpace(message), "\n")
+	resource, ok := strings.CutPrefix(message, resourceLimitMessagePrefix)
+	if !ok {
+		return "", false
+	}
+	return strings.CutSuffix(resource, resourceLimitMessageSuffix)
+}
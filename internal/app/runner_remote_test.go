@@ -0,0 +1,248 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteRunnerShipsWorkspace(t *testing.T) {
+	node := newRenderNode(t, workspaceEchoRunner{})
+	runner := newTestRemoteRunner(t, NewMockObservabilityProvider(), node.URL)
+
+	workDir := t.TempDir()
+	for name, content := range map[string]string{"index.html": "<h1>remote</h1>", "style.css": "body {}", "b.txt": "b", "a.txt": "a", "logo.png": "png"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(workDir, name), []byte(content), 0o600))
+	}
+	var output bytes.Buffer
+	_, err := runner.GeneratePDF(context.Background(), RenderRequest{
+		WorkDir:      workDir,
+		HTMLFilename: "index.html",
+		Stylesheets:  []string{defaultStylesheetPath, "style.css"},
+		Attachments:  []RenderAttachment{{Filename: "b.txt"}, {Filename: "a.txt"}},
+	}, &output)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "<h1>remote</h1>|style.css|b.txt,a.txt|a.txt,b.txt,index.html,logo.png,style.css", output.String())
+}
+
+func TestRemoteRunnerLetsNodesApplyTheirDefaultStylesheet(t *testing.T) {
+	node := newRenderNode(t, workspaceEchoRunner{})
+	runner := newTestRemoteRunner(t, NewMockObservabilityProvider(), node.URL)
+
+	var output bytes.Buffer
+	_, err := runner.GeneratePDF(context.Background(), remoteWorkspaceRequest(t), &output)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "<h1>remote</h1>|"+defaultStylesheetPath+"||index.html", output.String())
+}
+
+func TestRemoteRunnerRejectsSettingsItCannotForward(t *testing.T) {
+	node := newRenderNode(t, workspaceEchoRunner{})
+	runner := newTestRemoteRunner(t, NewMockObservabilityProvider(), node.URL)
+
+	request := remoteWorkspaceRequest(t)
+	request.Options.PDFVariant = "pdf/a-3b"
+	_, err := runner.GeneratePDF(context.Background(), request, io.Discard)
+
+	assert.ErrorContains(t, err, "cannot be forwarded")
+}
+
+func TestRemoteRunnerFailsOver(t *testing.T) {
+	draining := newTestService(fakeValidator{}, &fakeRunner{}, WithSignedRequests(newTestHMACValidator(t, testHMACKey)))
+	assert.NoError(t, draining.Shutdown(context.Background()))
+	drainingNode := httptest.NewServer(draining.Routes())
+	t.Cleanup(drainingNode.Close)
+	stoppedNode := httptest.NewServer(http.NotFoundHandler())
+	stoppedNode.Close()
+	node := newRenderNode(t, &fakeRunner{output: []byte("%PDF-remote")})
+
+	runner := newTestRemoteRunner(t, NewMockObservabilityProvider(), drainingNode.URL, stoppedNode.URL, node.URL)
+	for range 3 {
+		var output bytes.Buffer
+		_, err := runner.GeneratePDF(context.Background(), remoteWorkspaceRequest(t), &output)
+		assert.NoError(t, err)
+		assert.Equal(t, "%PDF-remote", output.String())
+	}
+
+	assert.False(t, runner.nodes[0].healthy.Load())
+	assert.False(t, runner.nodes[1].healthy.Load())
+	assert.True(t, runner.nodes[2].healthy.Load())
+}
+
+func TestRemoteRunnerDoesNotRetryFailedRenders(t *testing.T) {
+	tests := []struct {
+		name      string
+		runErr    error
+		assertErr func(t *testing.T, err error)
+	}{
+		{
+			name:   "resource limit",
+			runErr: &ResourceLimitError{Resource: ResourceCPUTime, Cause: assert.AnError},
+			assertErr: func(t *testing.T, err error) {
+				var limitErr *ResourceLimitError
+				if assert.ErrorAs(t, err, &limitErr) {
+					assert.Equal(t, ResourceCPUTime, limitErr.Resource)
+				}
+			},
+		},
+		{
+			name:   "render failure",
+			runErr: assert.AnError,
+			assertErr: func(t *testing.T, err error) {
+				assert.ErrorContains(t, err, "returned 500: PDF generation failed.")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failing := &fakeRunner{runErr: tt.runErr}
+			other := &fakeRunner{}
+			runner := newTestRemoteRunner(t, NewMockObservabilityProvider(), newRenderNode(t, failing).URL, newRenderNode(t, other).URL)
+			runner.nodes[1].inFlight.Store(1)
+
+			_, err := runner.GeneratePDF(context.Background(), remoteWorkspaceRequest(t), io.Discard)
+
+			tt.assertErr(t, err)
+			assert.Empty(t, other.lastRequest.HTMLFilename)
+			assert.True(t, runner.nodes[0].healthy.Load())
+		})
+	}
+}
+
+func TestRemoteRunnerPrefersHealthyNodesWithLessLoad(t *testing.T) {
+	runner := newTestRemoteRunner(t, NewMockObservabilityProvider(), "http://a", "http://b", "http://c")
+	runner.nodes[0].inFlight.Store(2)
+	runner.nodes[1].healthy.Store(false)
+	runner.nodes[2].inFlight.Store(1)
+
+	var hosts []string
+	for _, node := range runner.candidates() {
+		hosts = append(hosts, node.url.Host)
+	}
+
+	assert.Equal(t, []string{"c", "a", "b"}, hosts)
+}
+
+func TestRemoteRunnerProbesNodeReadiness(t *testing.T) {
+	draining := newTestService(fakeValidator{}, &fakeRunner{}, WithSignedRequests(newTestHMACValidator(t, testHMACKey)))
+	draining.Drain()
+	drainingNode := httptest.NewServer(draining.Routes())
+	t.Cleanup(drainingNode.Close)
+	node := newRenderNode(t, &fakeRunner{})
+
+	runner := newTestRemoteRunner(t, NewMockObservabilityProvider(), drainingNode.URL, node.URL)
+	runner.probeNodes()
+
+	assert.False(t, runner.nodes[0].healthy.Load())
+	assert.True(t, runner.nodes[1].healthy.Load())
+	assert.NoError(t, runner.CheckHealth(context.Background()))
+
+	node.Close()
+	runner.probeNodes()
+	assert.ErrorContains(t, runner.CheckHealth(context.Background()), "no healthy render nodes")
+}
+
+func TestRemoteRunnerUsesObservabilityHTTPClient(t *testing.T) {
+	var traced []string
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traced = append(traced, r.Header.Get("X-Test-Trace"))
+		_, _ = w.Write([]byte("%PDF"))
+	}))
+	t.Cleanup(node.Close)
+
+	runner := newTestRemoteRunner(t, tracingObservability{NewMockObservabilityProvider()}, node.URL)
+	_, err := runner.GeneratePDF(context.Background(), remoteWorkspaceRequest(t), io.Discard)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"traced"}, traced)
+}
+
+func TestParseResourceLimitMessage(t *testing.T) {
+	resource, ok := parseResourceLimitMessage(resourceLimitMessagePrefix + ResourceFileSize + resourceLimitMessageSuffix + "\n")
+	assert.True(t, ok)
+	assert.Equal(t, ResourceFileSize, resource)
+
+	_, ok = parseResourceLimitMessage("Unprocessable")
+	assert.False(t, ok)
+}
+
+// newRenderNode runs a normal Service that accepts requests signed with testHMACKey.
+func newRenderNode(t *testing.T, runner PDFRunner) *httptest.Server {
+	t.Helper()
+	svc := newTestService(fakeValidator{}, runner, WithSignedRequests(newTestHMACValidator(t, testHMACKey)))
+	server := httptest.NewServer(svc.Routes())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestRemoteRunner(t *testing.T, obs Observability, nodes ...string) *RemoteRunner {
+	t.Helper()
+	runner, err := NewRemoteRunner(nodes, testHMACKey, time.Hour, obs)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(runner.Close)
+	return runner
+}
+
+func remoteWorkspaceRequest(t *testing.T) RenderRequest {
+	t.Helper()
+	workDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(workDir, "index.html"), []byte("<h1>remote</h1>"), 0o600))
+	return RenderRequest{WorkDir: workDir, HTMLFilename: "index.html", Stylesheets: []string{defaultStylesheetPath}}
+}
+
+// workspaceEchoRunner writes the HTML, the stylesheet, the attachments and the workspace files it received.
+type workspaceEchoRunner struct{}
+
+func (workspaceEchoRunner) GeneratePDF(_ context.Context, request RenderRequest, output io.Writer) (RenderResult, error) {
+	html, err := os.ReadFile(filepath.Join(request.WorkDir, request.HTMLFilename))
+	if err != nil {
+		return RenderResult{}, err
+	}
+	entries, err := os.ReadDir(request.WorkDir)
+	if err != nil {
+		return RenderResult{}, err
+	}
+	var files []string
+	for _, entry := range entries {
+		files = append(files, entry.Name())
+	}
+	slices.Sort(files)
+	_, err = fmt.Fprintf(output, "%s|%s|%s|%s", html, strings.Join(request.Stylesheets, ","),
+		strings.Join(request.attachmentFilenames(), ","), strings.Join(files, ","))
+	return RenderResult{}, err
+}
+
+// tracingObservability marks requests sent by its HTTP client, standing in for trace propagation.
+type tracingObservability struct {
+	*MockObservabilityProvider
+}
+
+func (o tracingObservability) HttpClient(base http.RoundTripper) *http.Client {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r.Header.Set("X-Test-Trace", "traced")
+		return base.RoundTrip(r)
+	})}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
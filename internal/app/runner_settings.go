@@ -0,0 +1,37 @@
+package app
+
+import "sync/atomic"
+
+// RunnerSettings are the settings of a local runner that a configuration reload can change.
+type RunnerSettings struct {
+	DefaultStylesheetPath string
+	Limits                ResourceLimits
+}
+
+// ReloadableSettings holds the current RunnerSettings of a runner. A render reads them when it starts, so
+// Store only affects later renders.
+type ReloadableSettings struct {
+	current atomic.Pointer[RunnerSettings]
+}
+
+func NewReloadableSettings(settings RunnerSettings) *ReloadableSettings {
+	s := &ReloadableSettings{}
+	s.Store(settings)
+	return s
+}
+
+func (s *ReloadableSettings) Load() RunnerSettings {
+	return *s.current.Load()
+}
+
+func (s *ReloadableSettings) Store(settings RunnerSettings) {
+	s.current.Store(&settings)
+}
+
+// load returns the settings in effect, or nil when s is nil. Every Store returns a new pointer.
+func (s *ReloadableSettings) load() *RunnerSettings {
+	if s == nil {
+		return nil
+	}
+	return s.current.Load()
+}
@@ -1,39 +1,204 @@
 package app
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/bpf"
 )
 
 const sandboxDefaultStylesheetPath = "/defaults/default.css"
 
-func (r WeasyprintRunner) GeneratePDF(ctx context.Context, workDir string, htmlFilename string, cssFilename string, attachmentFilenames []string, output io.Writer) error {
-	args := r.buildArgs(workDir, htmlFilename, cssFilename, attachmentFilenames)
+// sandboxSeccompFD is the descriptor bwrap reads the seccomp filter from: the first of cmd.ExtraFiles.
+const sandboxSeccompFD = 3
+
+// sandboxUID and sandboxGID are the ids of the sandboxed process inside its user namespace (nobody).
+const (
+	sandboxUID = "65534"
+	sandboxGID = "65534"
+)
+
+var bwrapSeccompFilter = sync.OnceValues(func() ([]bpf.RawInstruction, error) {
+	return newSeccompFilter(true, 0)
+})
+
+func (r WeasyprintRunner) GeneratePDF(ctx context.Context, request RenderRequest, output io.Writer) (RenderResult, error) {
+	r = r.current()
+	r.Limits = r.Limits.tighten(request.Limits)
+	requestID := RequestIDFromContext(ctx)
+	cmd, cleanup, err := r.sandboxCommand(ctx, r.buildArgs(request, requestID))
+	if err != nil {
+		return RenderResult{}, err
+	}
+	defer cleanup()
 
-	cmd := exec.CommandContext(ctx, r.BwrapPath, args...)
 	cmd.Stdout = output
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	stderr := &tailBuffer{limit: renderStderrLimit}
+	cmd.Stderr = stderr
+
+	cgroup, err := r.useCgroup(cmd)
+	if err != nil {
+		return RenderResult{}, err
+	}
+	if cgroup != nil {
+		defer cgroup.close()
+	}
+
+	start := time.Now()
+	err = cmd.Run()
+	recordProcessExit(ctx, cmd.ProcessState)
+	result := RenderResult{Usage: processUsage(cmd.ProcessState, time.Since(start)), Diagnostics: parseDiagnostics(stderr.String(), requestID)}
+	if err != nil {
+		stderrText := strings.TrimSpace(stderr.String())
+		err = classifyLimitError(err, stderrText, cgroup != nil && cgroup.oomKilled())
+		return result, fmt.Errorf("weasyprint failed: %w: %s", err, stderrText)
+	}
+	return result, nil
+}
+
+// CheckHealth starts the sandbox the same way a render does, asks WeasyPrint for its version and
+// runs the isolation SelfTest.
+func (r WeasyprintRunner) CheckHealth(ctx context.Context) error {
+	r = r.current()
+	for _, path := range []string{r.BwrapPath, r.WeasyprintPath} {
+		if _, err := exec.LookPath(path); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(r.defaultStylesheetPath()); err != nil {
+		return fmt.Errorf("default stylesheet: %w", err)
+	}
+
+	workDir, err := os.MkdirTemp("", "pdf-healthcheck-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	if _, err := r.runInSandbox(ctx, r.sandboxArgs(workDir), r.WeasyprintPath, "--version"); err != nil {
+		return err
+	}
+	return r.SelfTest(ctx)
+}
+
+// Version asks WeasyPrint for its version inside the sandbox.
+func (r WeasyprintRunner) Version(ctx context.Context) (string, error) {
+	r = r.current()
+	output, err := r.runInSandbox(ctx, r.sandboxArgs(""), r.WeasyprintPath, "--version")
+	if err != nil {
+		return "", err
+	}
+	return parseWeasyprintVersion(output), nil
+}
+
+// current returns r with the settings of r.Reloadable in effect.
+func (r WeasyprintRunner) current() WeasyprintRunner {
+	if settings := r.Reloadable.load(); settings != nil {
+		r.DefaultStylesheetPath = settings.DefaultStylesheetPath
+		r.Limits = settings.Limits
+	}
+	return r
+}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("weasyprint failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+func (r WeasyprintRunner) defaultStylesheetPath() string {
+	if r.DefaultStylesheetPath == "" {
+		return "assets/default.css"
 	}
-	return nil
+	return r.DefaultStylesheetPath
 }
 
-func (r WeasyprintRunner) buildArgs(workDir string, htmlFilename string, cssFilename string, attachmentFilenames []string) []string {
-	defaultStylesheetPath := r.DefaultStylesheetPath
-	if defaultStylesheetPath == "" {
-		defaultStylesheetPath = "assets/default.css"
+// buildArgs returns the bwrap command line rendering request, with requestID in the sandbox environment.
+func (r WeasyprintRunner) buildArgs(request RenderRequest, requestID string) []string {
+	args := r.sandboxArgs(request.WorkDir)
+	if requestID != "" {
+		// Before the "--" that ends the bwrap options.
+		args = slices.Insert(args, len(args)-1, "--setenv", sandboxRequestIDEnv, requestID)
+	}
+	args = append(args, r.WeasyprintPath, request.HTMLFilename, "-")
+	return append(args, weasyprintArgs(request, sandboxDefaultStylesheetPath)...)
+}
+
+// weasyprintArgs returns the WeasyPrint command line options of request. defaultStylesheet is the path the
+// runner's default stylesheet is readable at.
+func weasyprintArgs(request RenderRequest, defaultStylesheet string) []string {
+	var args []string
+	for _, stylesheet := range request.Stylesheets {
+		if stylesheet == defaultStylesheetPath {
+			stylesheet = defaultStylesheet
+		}
+		args = append(args, "--stylesheet", stylesheet)
+	}
+	for _, attachment := range request.Attachments {
+		args = append(args, "--attachment", attachment.Filename)
+	}
+	if request.BaseURL != "" {
+		args = append(args, "--base-url", request.BaseURL)
+	}
+	if request.Options.PDFVariant != "" {
+		args = append(args, "--pdf-variant", request.Options.PDFVariant)
+	}
+	if request.Options.PresentationalHints {
+		args = append(args, "--presentational-hints")
+	}
+	return args
+}
+
+// sandboxCommand prepares bwrap with args, started through the sandbox exec helper when rlimits are
+// configured and with the seccomp filter on sandboxSeccompFD. cleanup must be called once the command has exited.
+func (r WeasyprintRunner) sandboxCommand(ctx context.Context, args []string) (*exec.Cmd, func(), error) {
+	filter, err := bwrapSeccompFilter()
+	if err != nil {
+		return nil, nil, err
+	}
+	name, args, err := sandboxExecCommand(r.Limits, r.BwrapPath, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	filterFile, err := seccompFilterFile(filter)
+	if err != nil {
+		return nil, nil, err
 	}
 
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.ExtraFiles = []*os.File{filterFile}
+	return cmd, func() { _ = filterFile.Close() }, nil
+}
+
+// useCgroup starts cmd in a new cgroup limited by r.Limits. It returns nil when no cgroup is used.
+func (r WeasyprintRunner) useCgroup(cmd *exec.Cmd) (*renderCgroup, error) {
+	if r.Cgroups == nil || !r.Limits.hasCgroupLimits() {
+		return nil, nil
+	}
+	cgroup, err := r.Cgroups.create(r.Limits)
+	if err != nil {
+		return nil, err
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(cgroup.fd.Fd())}
+	return cgroup, nil
+}
+
+// sandboxArgs returns the bwrap arguments up to and including the "--" that precedes the command.
+// The policy they implement is described in architecture.md. Without a workDir nothing is bound on
+// /workspace and the command starts in /tmp.
+func (r WeasyprintRunner) sandboxArgs(workDir string) []string {
 	args := []string{
 		"--unshare-all",
+		"--unshare-user",
+		"--uid", sandboxUID,
+		"--gid", sandboxGID,
 		"--new-session",
+		"--die-with-parent",
+		"--cap-drop", "ALL",
+		"--seccomp", strconv.Itoa(sandboxSeccompFD),
 		"--clearenv",
 
 		"--ro-bind", "/usr", "/usr",
@@ -43,21 +208,16 @@ func (r WeasyprintRunner) buildArgs(workDir string, htmlFilename string, cssFile
 		"--ro-bind", "/etc/fonts", "/etc/fonts",
 		"--ro-bind", "/var/cache/fontconfig", "/var/cache/fontconfig",
 
-		"--ro-bind", defaultStylesheetPath, sandboxDefaultStylesheetPath,
-		"--ro-bind", workDir, "/workspace",
-		"--chdir", "/workspace",
-		"--setenv", "PATH", "/usr/local/bin:/usr/bin",
-		"--",
-		r.WeasyprintPath,
-		htmlFilename,
-		"-",
-		"--stylesheet",
-		cssFilename,
+		"--ro-bind", r.defaultStylesheetPath(), sandboxDefaultStylesheetPath,
+		"--tmpfs", "/tmp",
 	}
-
-	for _, attachment := range attachmentFilenames {
-		args = append(args, "--attachment", attachment)
+	if workDir != "" {
+		args = append(args, "--ro-bind", workDir, "/workspace", "--chdir", "/workspace")
+	} else {
+		args = append(args, "--chdir", "/tmp")
 	}
-
-	return args
+	return append(args,
+		"--setenv", "PATH", "/usr/local/bin:/usr/bin",
+		"--",
+	)
 }
@@ -19,11 +19,18 @@ func TestBuildArgsUsesCustomStylesheetBindAndAttachments(t *testing.T) {
 		DefaultStylesheetPath: "/app/assets/custom.css",
 	}
 
-	args := runner.buildArgs("/tmp/work", "doc.html", "style.css", []string{"a.txt", "b.txt"})
+	args := runner.buildArgs(RenderRequest{
+		WorkDir:      "/tmp/work",
+		HTMLFilename: "doc.html",
+		Stylesheets:  []string{defaultStylesheetPath, "style.css"},
+		Attachments:  []RenderAttachment{{Filename: "a.txt"}, {Filename: "b.txt"}},
+	}, "req-123")
 	joinedArgs := strings.Join(args, " ")
+	assert.Contains(t, joinedArgs, "--stylesheet "+sandboxDefaultStylesheetPath+" --stylesheet style.css")
 	assert.Contains(t, joinedArgs, "--attachment a.txt")
 	assert.Contains(t, joinedArgs, "--attachment b.txt")
 	assert.Contains(t, joinedArgs, "--ro-bind /app/assets/custom.css "+sandboxDefaultStylesheetPath)
+	assert.Contains(t, joinedArgs, "--setenv REQUEST_ID req-123 -- weasyprint")
 }
 
 func TestGeneratePDFRunsBwrapWeasyprintCommand(t *testing.T) {
@@ -51,7 +58,7 @@ func TestGeneratePDFRunsBwrapWeasyprintCommand(t *testing.T) {
 	}
 
 	var output bytes.Buffer
-	err := runner.GeneratePDF(context.Background(), workDir, "index.html", "style.css", nil, &output)
+	_, err := runner.GeneratePDF(context.Background(), RenderRequest{WorkDir: workDir, HTMLFilename: "index.html", Stylesheets: []string{"style.css"}}, &output)
 	assert.NoError(t, err)
 	assert.NotZero(t, output.Len())
 	assert.True(t, bytes.HasPrefix(output.Bytes(), []byte("%PDF")), "expected generated output to start with %PDF")
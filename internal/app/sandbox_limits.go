@@ -0,0 +1,191 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// SandboxExecArg is the first argument that makes the service binary act as the sandbox exec helper
+// (see RunHelper). Go cannot set rlimits on a child before it starts, so renders are started
+// through the service binary itself, which applies the limits and then execs bwrap.
+const SandboxExecArg = "sandbox-exec"
+
+// cpuTimeGrace is how long a process may keep running after SIGXCPU before the kernel kills it.
+const cpuTimeGrace = 5 * time.Second
+
+const (
+	ResourceMemory    = "memory"
+	ResourceCPUTime   = "CPU time"
+	ResourceProcesses = "process"
+	ResourceFileSize  = "file size"
+)
+
+// ResourceLimits bound a single render. Zero values disable a limit.
+type ResourceLimits struct {
+	// MemoryBytes sets RLIMIT_AS and, with cgroups, memory.max.
+	MemoryBytes int64
+	// CPUTime sets RLIMIT_CPU.
+	CPUTime time.Duration
+	// CPUQuota is the number of CPUs a render may use (cgroup cpu.max).
+	CPUQuota float64
+	// MaxProcesses sets RLIMIT_NPROC. It counts every process of the service user, not just this render.
+	MaxProcesses int
+	// MaxFileBytes sets RLIMIT_FSIZE.
+	MaxFileBytes int64
+}
+
+func (l ResourceLimits) hasRlimits() bool {
+	return l.MemoryBytes > 0 || l.CPUTime > 0 || l.MaxProcesses > 0 || l.MaxFileBytes > 0
+}
+
+func (l ResourceLimits) hasCgroupLimits() bool {
+	return l.MemoryBytes > 0 || l.CPUQuota > 0
+}
+
+// ResourceLimitError reports that a render was stopped by one of its ResourceLimits.
+type ResourceLimitError struct {
+	Resource string
+	Cause    error
+}
+
+func (e *ResourceLimitError) Error() string {
+	return fmt.Sprintf("render exceeded the %s limit: %v", e.Resource, e.Cause)
+}
+
+func (e *ResourceLimitError) Unwrap() error {
+	return e.Cause
+}
+
+// sandboxExecCommand wraps name and args in the sandbox exec helper when rlimits are configured.
+func sandboxExecCommand(limits ResourceLimits, name string, args []string) (string, []string, error) {
+	if !limits.hasRlimits() {
+		return name, args, nil
+	}
+	helper, err := os.Executable()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to locate sandbox exec helper: %w", err)
+	}
+	return helper, append([]string{SandboxExecArg, formatRlimits(limits), name}, args...), nil
+}
+
+func formatRlimits(limits ResourceLimits) string {
+	return strings.Join([]string{
+		"as=" + strconv.FormatInt(limits.MemoryBytes, 10),
+		"cpu=" + strconv.FormatInt(int64(limits.CPUTime/time.Second), 10),
+		"nproc=" + strconv.Itoa(limits.MaxProcesses),
+		"fsize=" + strconv.FormatInt(limits.MaxFileBytes, 10),
+	}, ",")
+}
+
+// RunHelper runs the helper named by args[0] (SandboxExecArg or LandlockExecArg), which replaces the
+// process with the sandboxed command and never returns. It returns false when args do not name a helper.
+// The service binary and tests call it before anything else.
+func RunHelper(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	var err error
+	switch args[0] {
+	case SandboxExecArg:
+		err = sandboxExec(args[1:])
+	case LandlockExecArg:
+		err = landlockExec(args[1:])
+	default:
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+	os.Exit(127)
+	return true
+}
+
+// sandboxExec applies the rlimit spec in args[0] and execs the command in args[1:].
+func sandboxExec(args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: sandbox-exec <limits> <command> [args...]")
+	}
+	path, err := exec.LookPath(args[1])
+	if err != nil {
+		return err
+	}
+	if err := applyRlimits(args[0]); err != nil {
+		return err
+	}
+	return syscall.Exec(path, args[1:], os.Environ())
+}
+
+// applyRlimits sets the limits of a spec written by formatRlimits on the current process.
+func applyRlimits(spec string) error {
+	limits := map[string]int{"cpu": unix.RLIMIT_CPU, "nproc": unix.RLIMIT_NPROC, "fsize": unix.RLIMIT_FSIZE, "as": unix.RLIMIT_AS}
+	values := map[int]uint64{}
+	for item := range strings.SplitSeq(spec, ",") {
+		name, raw, _ := strings.Cut(item, "=")
+		resource, ok := limits[name]
+		if !ok {
+			return fmt.Errorf("unknown limit %q", name)
+		}
+		value, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid limit %q: %w", item, err)
+		}
+		if value > 0 {
+			values[resource] = value
+		}
+	}
+
+	// RLIMIT_AS is applied last: once it is set, this process may not be able to map more memory.
+	for _, resource := range []int{unix.RLIMIT_CPU, unix.RLIMIT_NPROC, unix.RLIMIT_FSIZE, unix.RLIMIT_AS} {
+		value, ok := values[resource]
+		if !ok {
+			continue
+		}
+		limit := unix.Rlimit{Cur: value, Max: value}
+		if resource == unix.RLIMIT_CPU {
+			limit.Max = value + uint64(cpuTimeGrace/time.Second)
+		}
+		if err := unix.Setrlimit(resource, &limit); err != nil {
+			return fmt.Errorf("failed to set limit %d: %w", resource, err)
+		}
+	}
+	return nil
+}
+
+// classifyLimitError recognizes a render that failed because it hit a resource limit. bwrap exits
+// with 128+signal when the sandboxed process is killed by a signal.
+func classifyLimitError(err error, stderr string, oomKilled bool) error {
+	if oomKilled {
+		return &ResourceLimitError{Resource: ResourceMemory, Cause: err}
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+
+	signal := syscall.Signal(0)
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		signal = status.Signal()
+	} else if code := exitErr.ExitCode(); code > 128 {
+		signal = syscall.Signal(code - 128)
+	}
+
+	switch {
+	case signal == syscall.SIGXCPU:
+		return &ResourceLimitError{Resource: ResourceCPUTime, Cause: err}
+	case signal == syscall.SIGXFSZ:
+		return &ResourceLimitError{Resource: ResourceFileSize, Cause: err}
+	case strings.Contains(stderr, "MemoryError"), strings.Contains(stderr, "Cannot allocate memory"):
+		return &ResourceLimitError{Resource: ResourceMemory, Cause: err}
+	case strings.Contains(stderr, "Resource temporarily unavailable"), strings.Contains(stderr, "can't start new thread"):
+		return &ResourceLimitError{Resource: ResourceProcesses, Cause: err}
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMain lets the test binary act as the sandbox helpers, like the service binary does.
+func TestMain(m *testing.M) {
+	RunHelper(os.Args[1:])
+	os.Exit(m.Run())
+}
+
+func TestSandboxExecEnforcesFileSizeLimit(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "out")
+	err := runWithLimits(t, ResourceLimits{MaxFileBytes: 1024}, "sh", "-c", "head -c 4096 /dev/zero > "+target)
+
+	var limitErr *ResourceLimitError
+	if assert.ErrorAs(t, classifyLimitError(err, "", false), &limitErr) {
+		assert.Equal(t, ResourceFileSize, limitErr.Resource)
+	}
+}
+
+func TestSandboxExecEnforcesCPUTimeLimit(t *testing.T) {
+	err := runWithLimits(t, ResourceLimits{CPUTime: time.Second}, "sh", "-c", "while :; do :; done")
+
+	var limitErr *ResourceLimitError
+	if assert.ErrorAs(t, classifyLimitError(err, "", false), &limitErr) {
+		assert.Equal(t, ResourceCPUTime, limitErr.Resource)
+	}
+}
+
+func TestSandboxExecCommandSkipsHelperWithoutRlimits(t *testing.T) {
+	name, args, err := sandboxExecCommand(ResourceLimits{CPUQuota: 1}, "bwrap", []string{"--", "true"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "bwrap", name)
+	assert.Equal(t, []string{"--", "true"}, args)
+}
+
+func TestClassifyLimitErrorFromBwrapExitStatus(t *testing.T) {
+	// bwrap exits with 128+signal when the sandboxed process is killed.
+	err := exec.Command("sh", "-c", "exit 153").Run()
+
+	var limitErr *ResourceLimitError
+	if assert.ErrorAs(t, classifyLimitError(err, "", false), &limitErr) {
+		assert.Equal(t, ResourceFileSize, limitErr.Resource)
+	}
+}
+
+func TestClassifyLimitErrorFromStderrAndCgroup(t *testing.T) {
+	err := exec.Command("sh", "-c", "exit 1").Run()
+
+	var limitErr *ResourceLimitError
+	if assert.ErrorAs(t, classifyLimitError(err, "MemoryError", false), &limitErr) {
+		assert.Equal(t, ResourceMemory, limitErr.Resource)
+	}
+	if assert.ErrorAs(t, classifyLimitError(errors.New("signal: killed"), "", true), &limitErr) {
+		assert.Equal(t, ResourceMemory, limitErr.Resource)
+	}
+	assert.NotErrorAs(t, classifyLimitError(err, "invalid html", false), &limitErr)
+}
+
+func TestRenderCgroupDetectsOOMKill(t *testing.T) {
+	cgroup := &renderCgroup{dir: t.TempDir()}
+	assert.False(t, cgroup.oomKilled())
+
+	assert.NoError(t, os.WriteFile(filepath.Join(cgroup.dir, "memory.events"), []byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n"), 0o644))
+	assert.True(t, cgroup.oomKilled())
+}
+
+func TestRenderLimitMapsToUnprocessableEntity(t *testing.T) {
+	runErr := &ResourceLimitError{Resource: ResourceMemory, Cause: errors.New("exit status 1")}
+	svc := newTestService(fakeValidator{}, &fakeRunner{runErr: runErr})
+
+	rec := postMultipart(t, svc, "/pdf", []testPart{testHTMLPart})
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "memory limit")
+}
+
+func runWithLimits(t *testing.T, limits ResourceLimits, name string, args ...string) error {
+	t.Helper()
+	helper, helperArgs, err := sandboxExecCommand(limits, name, args)
+	assert.NoError(t, err)
+
+	cmd := exec.Command(helper, helperArgs...)
+	err = cmd.Run()
+	assert.Error(t, err)
+	return err
+}
+
+func TestResourceLimitsTighten(t *testing.T) {
+	runner := ResourceLimits{MemoryBytes: 512 << 20, CPUTime: time.Minute, MaxProcesses: 16}
+	request := ResourceLimits{MemoryBytes: 256 << 20, CPUTime: 2 * time.Minute, CPUQuota: 0.5}
+
+	assert.Equal(t, ResourceLimits{MemoryBytes: 256 << 20, CPUTime: time.Minute, CPUQuota: 0.5, MaxProcesses: 16},
+		runner.tighten(request))
+}
@@ -0,0 +1,285 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// sandboxProbeScript runs inside the render sandbox and reports, per check, what it could see that it
+// should not. An empty string means the check passed.
+const sandboxProbeScript = `
+import array, fcntl, json, os, socket, struct, sys
+
+SIOCGIFCONF = 0x8912
+IFREQ_SIZE = 40
+
+expected = json.loads(sys.argv[1])
+results = {}
+
+def check(name, fn):
+    try:
+        problem = fn()
+    except Exception as e:
+        problem = "probe error: %s" % e
+    results[name] = problem or ""
+
+def interfaces():
+    # /sys and /proc are not mounted in the bwrap sandbox and netlink sockets are denied, so the interfaces are
+    # also listed with SIOCGIFCONF on a unix socket, which reports those of the network namespace of the socket.
+    names = set()
+    try:
+        names.update(os.listdir("/sys/class/net"))
+    except OSError:
+        pass
+    try:
+        with open("/proc/net/dev") as f:
+            names.update(line.split(":", 1)[0].strip() for line in f.readlines()[2:])
+    except OSError:
+        pass
+    try:
+        sock = socket.socket(socket.AF_UNIX, socket.SOCK_DGRAM)
+    except OSError:
+        # The seccomp filter of the Landlock runner denies every socket.
+        return names
+    with sock:
+        buf = array.array("B", bytes(IFREQ_SIZE * 64))
+        ifconf = struct.pack("iL", len(buf), buf.buffer_info()[0])
+        size, _ = struct.unpack("iL", fcntl.ioctl(sock.fileno(), SIOCGIFCONF, ifconf))
+        data = buf.tobytes()
+        names.update(data[i:i + 16].split(b"\0", 1)[0].decode() for i in range(0, size, IFREQ_SIZE))
+    return names
+
+def network():
+    extra = sorted(name for name in interfaces() if name != "lo")
+    if extra:
+        return "network interfaces visible: " + ", ".join(extra)
+    try:
+        sock = socket.socket(socket.AF_UNIX, socket.SOCK_STREAM)
+    except OSError:
+        sock = None
+    if sock:
+        with sock:
+            try:
+                sock.connect("\0" + expected["abstract_socket"])
+                return "abstract unix socket of the host reachable"
+            except OSError:
+                pass
+    try:
+        socket.create_connection(("1.1.1.1", 53), timeout=1).close()
+    except OSError:
+        return None
+    return "outbound connection succeeded"
+
+def host_paths():
+    leaked = []
+    for directory, allowed in sorted(expected["dirs"].items()):
+        try:
+            entries = os.listdir(directory)
+        except PermissionError:
+            # Landlock denies listing directories above the allowed paths.
+            continue
+        for entry in sorted(entries):
+            if entry not in allowed:
+                leaked.append(os.path.join(directory, entry))
+    if leaked:
+        return "host paths visible: " + ", ".join(leaked)
+
+def workspace_read_only():
+    try:
+        fd = os.open(os.path.join(expected["workspace"], ".sandbox-probe"), os.O_WRONLY | os.O_CREAT, 0o600)
+    except OSError:
+        return None
+    os.close(fd)
+    return expected["workspace"] + " is writable"
+
+def seccomp():
+    import ctypes
+    libc = ctypes.CDLL(None, use_errno=True)
+    if libc.unshare(0x10000000) == 0:
+        return "unshare(CLONE_NEWUSER) succeeded"
+
+check("network", network)
+check("host_paths", host_paths)
+check("workspace_read_only", workspace_read_only)
+check("seccomp", seccomp)
+json.dump(results, sys.stdout)
+`
+
+// sandboxProbeInterpreter is resolved with the PATH set inside the sandbox. WeasyPrint needs it anyway.
+const sandboxProbeInterpreter = "python3"
+
+type sandboxProbeExpectations struct {
+	// Dirs maps every parent directory of an allowed path to the entries it may contain.
+	Dirs map[string][]string `json:"dirs"`
+	// Workspace is the request workspace as seen by the sandboxed process.
+	Workspace string `json:"workspace"`
+	// AbstractSocket is the name of an abstract unix socket the service listens on while the probe runs. Only
+	// a sandbox sharing the network namespace of the service can connect to it.
+	AbstractSocket string `json:"abstract_socket"`
+}
+
+// sandboxRun runs a command inside the sandbox under test and returns its stdout.
+type sandboxRun func(ctx context.Context, command ...string) ([]byte, error)
+
+// SelfTest verifies that the sandbox built for renders actually isolates them: no network, no host
+// paths beyond the binds, a read-only workspace, an active seccomp filter and an environment holding
+// only the variables set explicitly. bwrap silently degrades on some hosts (for example without user
+// namespaces), so this is checked at runtime rather than assumed from the arguments.
+func (r WeasyprintRunner) SelfTest(ctx context.Context) error {
+	r = r.current()
+	workDir, err := os.MkdirTemp("", "pdf-selftest-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+	return r.selfTest(ctx, r.sandboxArgs(workDir))
+}
+
+// selfTest runs the probes in the sandbox built by sandboxArgs.
+func (r WeasyprintRunner) selfTest(ctx context.Context, sandboxArgs []string) error {
+	run := func(ctx context.Context, command ...string) ([]byte, error) {
+		return r.runInSandbox(ctx, sandboxArgs, command...)
+	}
+	expectations := sandboxProbeExpectations{
+		Dirs:      parentDirs(sandboxMountTargets(sandboxArgs)),
+		Workspace: "/workspace",
+	}
+	return selfTestSandbox(ctx, run, sandboxEnv(sandboxArgs), expectations)
+}
+
+func selfTestSandbox(ctx context.Context, run sandboxRun, allowedEnv map[string]string, expectations sandboxProbeExpectations) error {
+	var failures []error
+
+	expectations.AbstractSocket = "pdf-service-selftest-" + rand.Text()
+	listener, err := net.Listen("unix", "@"+expectations.AbstractSocket)
+	if err != nil {
+		return fmt.Errorf("failed to listen on an abstract unix socket: %w", err)
+	}
+	defer listener.Close()
+
+	env, err := run(ctx, "env")
+	if err != nil {
+		return err
+	}
+	if problem := unexpectedEnvironment(env, allowedEnv); problem != "" {
+		failures = append(failures, fmt.Errorf("environment: %s", problem))
+	}
+
+	expected, err := json.Marshal(expectations)
+	if err != nil {
+		return err
+	}
+	output, err := run(ctx, sandboxProbeInterpreter, "-c", sandboxProbeScript, string(expected))
+	if err != nil {
+		return err
+	}
+	var results map[string]string
+	if err := json.Unmarshal(output, &results); err != nil {
+		return fmt.Errorf("invalid sandbox probe output: %w", err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(results)) {
+		if results[name] != "" {
+			failures = append(failures, fmt.Errorf("%s: %s", name, results[name]))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("sandbox is not isolated: %w", errors.Join(failures...))
+	}
+	return nil
+}
+
+func (r WeasyprintRunner) runInSandbox(ctx context.Context, sandboxArgs []string, command ...string) ([]byte, error) {
+	cmd, cleanup, err := r.sandboxCommand(ctx, append(slices.Clone(sandboxArgs), command...))
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("sandbox failed to start: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// sandboxEnv returns the variables the sandbox arguments set explicitly.
+func sandboxEnv(args []string) map[string]string {
+	env := map[string]string{}
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			break
+		}
+		if args[i] == "--setenv" && i+2 < len(args) {
+			env[args[i+1]] = args[i+2]
+			i += 2
+		}
+	}
+	return env
+}
+
+// parentDirs returns, for every directory above one of targets, the entries leading to the targets.
+func parentDirs(targets []string) map[string][]string {
+	dirs := map[string][]string{}
+	for _, target := range targets {
+		for target != "/" {
+			parent := path.Dir(target)
+			if !slices.Contains(dirs[parent], path.Base(target)) {
+				dirs[parent] = append(dirs[parent], path.Base(target))
+			}
+			target = parent
+		}
+	}
+	return dirs
+}
+
+// sandboxMountTargets returns the destination of every mount option before the "--" separator.
+func sandboxMountTargets(args []string) []string {
+	var targets []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--":
+			return targets
+		case "--bind", "--ro-bind", "--dev-bind", "--bind-try", "--ro-bind-try", "--dev-bind-try":
+			if i+2 < len(args) {
+				targets = append(targets, path.Clean(args[i+2]))
+				i += 2
+			}
+		case "--tmpfs", "--dir", "--proc", "--dev", "--mqueue":
+			if i+1 < len(args) {
+				targets = append(targets, path.Clean(args[i+1]))
+				i++
+			}
+		}
+	}
+	return targets
+}
+
+func unexpectedEnvironment(output []byte, allowed map[string]string) string {
+	var unexpected []string
+	for line := range strings.Lines(string(output)) {
+		name, value, _ := strings.Cut(strings.TrimRight(line, "\n"), "=")
+		if name == "" {
+			continue
+		}
+		if expected, ok := allowed[name]; !ok || expected != value {
+			unexpected = append(unexpected, name)
+		}
+	}
+	if len(unexpected) > 0 {
+		return "unexpected variables: " + strings.Join(unexpected, ", ")
+	}
+	return ""
+}
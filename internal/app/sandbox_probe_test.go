@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+	"os/exec"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSandboxParentDirsCoverEveryMount(t *testing.T) {
+	runner := WeasyprintRunner{BwrapPath: "bwrap", WeasyprintPath: "weasyprint"}
+
+	dirs := parentDirs(sandboxMountTargets(runner.sandboxArgs("/tmp/work")))
+
+	assert.ElementsMatch(t, []string{"usr", "lib", "lib64", "bin", "etc", "var", "defaults", "workspace", "tmp"}, dirs["/"])
+	assert.Equal(t, []string{"fonts"}, dirs["/etc"])
+	assert.Equal(t, []string{"cache"}, dirs["/var"])
+	assert.Equal(t, []string{"fontconfig"}, dirs["/var/cache"])
+	assert.Equal(t, []string{"default.css"}, dirs["/defaults"])
+	assert.NotContains(t, dirs, "/workspace")
+}
+
+func TestSandboxEnvReadsSetenvBeforeCommand(t *testing.T) {
+	args := []string{"--clearenv", "--setenv", "PATH", "/usr/bin", "--", "sh", "--setenv", "HOME", "/root"}
+
+	assert.Equal(t, map[string]string{"PATH": "/usr/bin"}, sandboxEnv(args))
+}
+
+func TestUnexpectedEnvironment(t *testing.T) {
+	allowed := map[string]string{"PATH": "/usr/bin"}
+
+	assert.Empty(t, unexpectedEnvironment([]byte("PATH=/usr/bin\n"), allowed))
+	assert.Equal(t, "unexpected variables: HOME, PATH", unexpectedEnvironment([]byte("HOME=/root\nPATH=/bin\n"), allowed))
+}
+
+func TestSelfTestPassesWithDefaultSandbox(t *testing.T) {
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		t.Skip("bwrap not available in PATH")
+	}
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not available in PATH")
+	}
+
+	runner := WeasyprintRunner{
+		BwrapPath:             "bwrap",
+		WeasyprintPath:        "weasyprint",
+		DefaultStylesheetPath: "../../assets/default.css",
+	}
+
+	assert.NoError(t, runner.SelfTest(context.Background()))
+}
+
+func TestSelfTestFailsWithoutNetworkNamespace(t *testing.T) {
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		t.Skip("bwrap not available in PATH")
+	}
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not available in PATH")
+	}
+	runner := WeasyprintRunner{
+		BwrapPath:             "bwrap",
+		WeasyprintPath:        "weasyprint",
+		DefaultStylesheetPath: "../../assets/default.css",
+	}
+	args := runner.sandboxArgs(t.TempDir())
+	args = slices.Insert(args, slices.Index(args, "--"), "--share-net")
+
+	err := runner.selfTest(context.Background(), args)
+
+	assert.ErrorContains(t, err, "network:")
+}
+
+func TestSandboxProbeDetectsTheHostNetwork(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not available in PATH")
+	}
+	unsandboxed := func(ctx context.Context, command ...string) ([]byte, error) {
+		return exec.CommandContext(ctx, command[0], command[1:]...).Output()
+	}
+
+	err := selfTestSandbox(context.Background(), unsandboxed, nil, sandboxProbeExpectations{Workspace: t.TempDir()})
+
+	assert.ErrorContains(t, err, "network: network interfaces visible")
+}
@@ -0,0 +1,248 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"unsafe"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// Offsets into struct seccomp_data. Arguments are 64 bits wide; the filter reads their low 32 bits,
+// which come first on the little-endian architectures the service supports.
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArg0 = 16
+)
+
+// seccompX32SyscallBit marks x32 ABI syscalls on amd64. They are rejected so the deny list cannot be bypassed.
+const seccompX32SyscallBit = 0x40000000
+
+// seccompNamespaceFlags are the clone flags that would create new namespaces inside the sandbox.
+const seccompNamespaceFlags = unix.CLONE_NEWNS | unix.CLONE_NEWUTS | unix.CLONE_NEWIPC | unix.CLONE_NEWUSER |
+	unix.CLONE_NEWPID | unix.CLONE_NEWNET | unix.CLONE_NEWCGROUP | unix.CLONE_NEWTIME
+
+// seccompDeniedSyscalls fail with EPERM inside the sandbox. WeasyPrint needs none of them; they are the
+// syscalls that reach kernel attack surface or undo the sandbox (mounts, namespaces, tracing, modules,
+// keyrings, BPF, io_uring). Architecture specific additions are in seccompArchDeniedSyscalls.
+var seccompDeniedSyscalls = []uint32{
+	unix.SYS_ACCT,
+	unix.SYS_ADD_KEY,
+	unix.SYS_ADJTIMEX,
+	unix.SYS_BPF,
+	unix.SYS_CHROOT,
+	unix.SYS_CLOCK_ADJTIME,
+	unix.SYS_CLOCK_SETTIME,
+	unix.SYS_DELETE_MODULE,
+	unix.SYS_FINIT_MODULE,
+	unix.SYS_FSCONFIG,
+	unix.SYS_FSMOUNT,
+	unix.SYS_FSOPEN,
+	unix.SYS_FSPICK,
+	unix.SYS_INIT_MODULE,
+	unix.SYS_IO_URING_ENTER,
+	unix.SYS_IO_URING_REGISTER,
+	unix.SYS_IO_URING_SETUP,
+	unix.SYS_KEXEC_FILE_LOAD,
+	unix.SYS_KEXEC_LOAD,
+	unix.SYS_KEYCTL,
+	unix.SYS_MOUNT,
+	unix.SYS_MOUNT_SETATTR,
+	unix.SYS_MOVE_MOUNT,
+	unix.SYS_NAME_TO_HANDLE_AT,
+	unix.SYS_OPEN_BY_HANDLE_AT,
+	unix.SYS_OPEN_TREE,
+	unix.SYS_PERF_EVENT_OPEN,
+	unix.SYS_PIVOT_ROOT,
+	unix.SYS_PROCESS_VM_READV,
+	unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_PTRACE,
+	unix.SYS_QUOTACTL,
+	unix.SYS_REBOOT,
+	unix.SYS_REQUEST_KEY,
+	unix.SYS_SETNS,
+	unix.SYS_SETTIMEOFDAY,
+	unix.SYS_SWAPOFF,
+	unix.SYS_SWAPON,
+	unix.SYS_SYSLOG,
+	unix.SYS_UMOUNT2,
+	unix.SYS_UNSHARE,
+	unix.SYS_USERFAULTFD,
+	unix.SYS_VHANGUP,
+}
+
+// seccompInstruction is a bpf instruction whose jump targets are labels, resolved by assembleSeccomp.
+type seccompInstruction struct {
+	instruction bpf.Instruction
+	label       string
+	jumpTrue    string
+	jumpFalse   string
+}
+
+// newSeccompFilter builds the render sandbox policy:
+//   - syscalls from another architecture (or the x32 ABI) kill the process;
+//   - seccompDeniedSyscalls fail with EPERM;
+//   - clone with namespace flags fails with EPERM, and clone3, whose flags cannot be inspected, fails
+//     with ENOSYS so libc falls back to clone;
+//   - socket fails with EAFNOSUPPORT, except for AF_UNIX when allowUnixSockets is set (bwrap's network
+//     namespace makes unix sockets safe there);
+//   - when signalPID is set, kill, tgkill, rt_sigqueueinfo and rt_tgsigqueueinfo fail with EPERM unless
+//     they target signalPID (kill also its process group), and tkill and pidfd_send_signal, whose targets
+//     cannot be checked, fail with EPERM. This is for sandboxes that share the pid namespace of the service;
+//   - everything else is allowed.
+func newSeccompFilter(allowUnixSockets bool, signalPID int) ([]bpf.RawInstruction, error) {
+	if seccompAuditArch == 0 {
+		return nil, errors.New("seccomp filter is not supported on this architecture")
+	}
+
+	// No socket family is numbered MaxUint32, so this denies every socket when unix sockets are not allowed.
+	unixSocketFamily := uint32(math.MaxUint32)
+	if allowUnixSockets {
+		unixSocketFamily = unix.AF_UNIX
+	}
+
+	program := []seccompInstruction{
+		{instruction: bpf.LoadAbsolute{Off: seccompDataArch, Size: 4}},
+		{instruction: bpf.JumpIf{Cond: bpf.JumpEqual, Val: seccompAuditArch}, jumpFalse: "kill"},
+		{instruction: bpf.LoadAbsolute{Off: seccompDataNr, Size: 4}},
+		{instruction: bpf.JumpIf{Cond: bpf.JumpGreaterOrEqual, Val: seccompX32SyscallBit}, jumpTrue: "kill"},
+		{instruction: bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.SYS_SOCKET}, jumpTrue: "socket"},
+		{instruction: bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.SYS_CLONE}, jumpTrue: "clone"},
+		{instruction: bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.SYS_CLONE3}, jumpTrue: "enosys"},
+	}
+	if signalPID != 0 {
+		program = append(program,
+			seccompInstruction{instruction: bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.SYS_KILL}, jumpTrue: "signal_group"},
+			seccompInstruction{instruction: bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.SYS_TGKILL}, jumpTrue: "signal"},
+			seccompInstruction{instruction: bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.SYS_RT_SIGQUEUEINFO}, jumpTrue: "signal"},
+			seccompInstruction{instruction: bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.SYS_RT_TGSIGQUEUEINFO}, jumpTrue: "signal"},
+			seccompInstruction{instruction: bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.SYS_TKILL}, jumpTrue: "eperm"},
+			seccompInstruction{instruction: bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.SYS_PIDFD_SEND_SIGNAL}, jumpTrue: "eperm"},
+		)
+	}
+	for _, nr := range slices.Concat(seccompDeniedSyscalls, seccompArchDeniedSyscalls) {
+		program = append(program, seccompInstruction{instruction: bpf.JumpIf{Cond: bpf.JumpEqual, Val: nr}, jumpTrue: "eperm"})
+	}
+	program = append(program,
+		seccompInstruction{instruction: bpf.Jump{}, jumpTrue: "allow"},
+
+		seccompInstruction{label: "socket", instruction: bpf.LoadAbsolute{Off: seccompDataArg0, Size: 4}},
+		seccompInstruction{instruction: bpf.JumpIf{Cond: bpf.JumpEqual, Val: unixSocketFamily}, jumpTrue: "allow", jumpFalse: "eafnosupport"},
+
+		seccompInstruction{label: "clone", instruction: bpf.LoadAbsolute{Off: seccompDataArg0, Size: 4}},
+		seccompInstruction{instruction: bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: seccompNamespaceFlags}, jumpTrue: "eperm", jumpFalse: "allow"},
+	)
+	if signalPID != 0 {
+		// The first argument of kill is 0 or -signalPID for the process group, which the sandbox makes its own.
+		program = append(program,
+			seccompInstruction{label: "signal_group", instruction: bpf.LoadAbsolute{Off: seccompDataArg0, Size: 4}},
+			seccompInstruction{instruction: bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0}, jumpTrue: "allow"},
+			seccompInstruction{instruction: bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(-int32(signalPID))}, jumpTrue: "allow"},
+
+			seccompInstruction{label: "signal", instruction: bpf.LoadAbsolute{Off: seccompDataArg0, Size: 4}},
+			seccompInstruction{instruction: bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(signalPID)}, jumpTrue: "allow", jumpFalse: "eperm"},
+		)
+	}
+	program = append(program,
+		seccompInstruction{label: "allow", instruction: bpf.RetConstant{Val: unix.SECCOMP_RET_ALLOW}},
+		seccompInstruction{label: "eperm", instruction: bpf.RetConstant{Val: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)}},
+		seccompInstruction{label: "enosys", instruction: bpf.RetConstant{Val: unix.SECCOMP_RET_ERRNO | uint32(unix.ENOSYS)}},
+		seccompInstruction{label: "eafnosupport", instruction: bpf.RetConstant{Val: unix.SECCOMP_RET_ERRNO | uint32(unix.EAFNOSUPPORT)}},
+		seccompInstruction{label: "kill", instruction: bpf.RetConstant{Val: unix.SECCOMP_RET_KILL_PROCESS}},
+	)
+
+	return assembleSeccomp(program)
+}
+
+// assembleSeccomp resolves label jumps into the relative skips bpf uses. Jumps can only go forward.
+func assembleSeccomp(program []seccompInstruction) ([]bpf.RawInstruction, error) {
+	labels := map[string]int{}
+	for i, instruction := range program {
+		if instruction.label != "" {
+			labels[instruction.label] = i
+		}
+	}
+	skip := func(from int, label string) (uint8, error) {
+		if label == "" {
+			return 0, nil
+		}
+		target, ok := labels[label]
+		if !ok {
+			return 0, fmt.Errorf("unknown seccomp label %q", label)
+		}
+		distance := target - from - 1
+		if distance < 0 || distance > 255 {
+			return 0, fmt.Errorf("seccomp jump to %q out of range", label)
+		}
+		return uint8(distance), nil
+	}
+
+	instructions := make([]bpf.Instruction, len(program))
+	for i, entry := range program {
+		jumpTrue, err := skip(i, entry.jumpTrue)
+		if err != nil {
+			return nil, err
+		}
+		jumpFalse, err := skip(i, entry.jumpFalse)
+		if err != nil {
+			return nil, err
+		}
+
+		switch instruction := entry.instruction.(type) {
+		case bpf.JumpIf:
+			instruction.SkipTrue, instruction.SkipFalse = jumpTrue, jumpFalse
+			instructions[i] = instruction
+		case bpf.Jump:
+			instruction.Skip = uint32(jumpTrue)
+			instructions[i] = instruction
+		default:
+			instructions[i] = instruction
+		}
+	}
+	return bpf.Assemble(instructions)
+}
+
+// installSeccompFilter applies filter to the calling thread. no_new_privs must already be set.
+func installSeccompFilter(filter []bpf.RawInstruction) error {
+	program := make([]unix.SockFilter, len(filter))
+	for i, instruction := range filter {
+		program[i] = unix.SockFilter{Code: instruction.Op, Jt: instruction.Jt, Jf: instruction.Jf, K: instruction.K}
+	}
+	fprog := unix.SockFprog{Len: uint16(len(program)), Filter: &program[0]}
+	if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&fprog)), 0, 0); err != nil {
+		return fmt.Errorf("failed to install seccomp filter: %w", err)
+	}
+	return nil
+}
+
+// seccompFilterFile returns a pipe from which bwrap reads the filter given to --seccomp. The program
+// is far smaller than the pipe buffer, so it is written up front.
+func seccompFilterFile(filter []bpf.RawInstruction) (*os.File, error) {
+	var program bytes.Buffer
+	for _, instruction := range filter {
+		_ = binary.Write(&program, binary.NativeEndian, struct {
+			Op uint16
+			Jt uint8
+			Jf uint8
+			K  uint32
+		}{instruction.Op, instruction.Jt, instruction.Jf, instruction.K})
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer writer.Close()
+	if _, err := writer.Write(program.Bytes()); err != nil {
+		_ = reader.Close()
+		return nil, fmt.Errorf("failed to write seccomp filter: %w", err)
+	}
+	return reader, nil
+}
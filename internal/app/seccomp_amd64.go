@@ -0,0 +1,12 @@
+package app
+
+import "golang.org/x/sys/unix"
+
+const seccompAuditArch = unix.AUDIT_ARCH_X86_64
+
+var seccompArchDeniedSyscalls = []uint32{
+	unix.SYS_IOPERM,
+	unix.SYS_IOPL,
+	unix.SYS_NFSSERVCTL,
+	unix.SYS_USELIB,
+}
@@ -0,0 +1,9 @@
+package app
+
+import "golang.org/x/sys/unix"
+
+const seccompAuditArch = unix.AUDIT_ARCH_AARCH64
+
+var seccompArchDeniedSyscalls = []uint32{
+	unix.SYS_NFSSERVCTL,
+}
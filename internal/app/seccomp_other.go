@@ -0,0 +1,8 @@
+//go:build !amd64 && !arm64
+
+package app
+
+// The seccomp filter is only built for amd64 and arm64; newSeccompFilter fails elsewhere.
+const seccompAuditArch = 0
+
+var seccompArchDeniedSyscalls []uint32
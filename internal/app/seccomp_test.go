@@ -0,0 +1,154 @@
+package app
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+func TestSeccompFilterPolicy(t *testing.T) {
+	vm := newSeccompVM(t, true, 0)
+
+	tests := []struct {
+		name string
+		arch uint32
+		nr   uint32
+		arg0 uint32
+		want uint32
+	}{
+		{name: "read is allowed", nr: unix.SYS_READ, want: unix.SECCOMP_RET_ALLOW},
+		{name: "mount is denied", nr: unix.SYS_MOUNT, want: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		{name: "ptrace is denied", nr: unix.SYS_PTRACE, want: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		{name: "unshare is denied", nr: unix.SYS_UNSHARE, want: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		{name: "unix sockets are allowed", nr: unix.SYS_SOCKET, arg0: unix.AF_UNIX, want: unix.SECCOMP_RET_ALLOW},
+		{name: "inet sockets are denied", nr: unix.SYS_SOCKET, arg0: unix.AF_INET, want: unix.SECCOMP_RET_ERRNO | uint32(unix.EAFNOSUPPORT)},
+		{name: "netlink sockets are denied", nr: unix.SYS_SOCKET, arg0: unix.AF_NETLINK, want: unix.SECCOMP_RET_ERRNO | uint32(unix.EAFNOSUPPORT)},
+		{name: "clone of a thread is allowed", nr: unix.SYS_CLONE, arg0: unix.CLONE_VM | unix.CLONE_THREAD, want: unix.SECCOMP_RET_ALLOW},
+		{name: "clone into a new user namespace is denied", nr: unix.SYS_CLONE, arg0: unix.CLONE_NEWUSER, want: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		{name: "clone3 falls back to clone", nr: unix.SYS_CLONE3, want: unix.SECCOMP_RET_ERRNO | uint32(unix.ENOSYS)},
+		{name: "x32 syscalls kill the process", nr: seccompX32SyscallBit | unix.SYS_READ, want: unix.SECCOMP_RET_KILL_PROCESS},
+		{name: "foreign architectures kill the process", arch: 0x40000003, nr: unix.SYS_READ, want: unix.SECCOMP_RET_KILL_PROCESS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arch := tt.arch
+			if arch == 0 {
+				arch = seccompAuditArch
+			}
+			result, err := vm.Run(seccompData(arch, tt.nr, tt.arg0))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, uint32(result))
+		})
+	}
+}
+
+func TestSeccompFilterWithoutUnixSocketsDeniesEverySocket(t *testing.T) {
+	vm := newSeccompVM(t, false, 0)
+
+	for _, family := range []uint32{unix.AF_UNIX, unix.AF_INET, unix.AF_INET6} {
+		result, err := vm.Run(seccompData(seccompAuditArch, unix.SYS_SOCKET, family))
+		assert.NoError(t, err)
+		assert.Equal(t, unix.SECCOMP_RET_ERRNO|uint32(unix.EAFNOSUPPORT), uint32(result))
+	}
+}
+
+func TestSeccompFilterWithSignalPIDOnlySignalsTheRenderer(t *testing.T) {
+	const pid = 4242
+	vm := newSeccompVM(t, false, pid)
+	ownGroup := uint32(0)
+	pidGroup := -int32(pid)
+
+	tests := []struct {
+		name string
+		nr   uint32
+		arg0 uint32
+		want uint32
+	}{
+		{name: "kill of itself is allowed", nr: unix.SYS_KILL, arg0: pid, want: unix.SECCOMP_RET_ALLOW},
+		{name: "kill of its own process group is allowed", nr: unix.SYS_KILL, arg0: ownGroup, want: unix.SECCOMP_RET_ALLOW},
+		{name: "kill of its process group by id is allowed", nr: unix.SYS_KILL, arg0: uint32(pidGroup), want: unix.SECCOMP_RET_ALLOW},
+		{name: "kill of another process is denied", nr: unix.SYS_KILL, arg0: 1, want: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		{name: "kill of every process is denied", nr: unix.SYS_KILL, arg0: math.MaxUint32, want: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		{name: "tgkill of its own threads is allowed", nr: unix.SYS_TGKILL, arg0: pid, want: unix.SECCOMP_RET_ALLOW},
+		{name: "tgkill of another process is denied", nr: unix.SYS_TGKILL, arg0: 1, want: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		{name: "rt_sigqueueinfo of another process is denied", nr: unix.SYS_RT_SIGQUEUEINFO, arg0: 1, want: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		{name: "rt_tgsigqueueinfo of another process is denied", nr: unix.SYS_RT_TGSIGQUEUEINFO, arg0: 1, want: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		{name: "tkill is denied", nr: unix.SYS_TKILL, arg0: pid, want: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		{name: "pidfd_send_signal is denied", nr: unix.SYS_PIDFD_SEND_SIGNAL, want: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		{name: "read is allowed", nr: unix.SYS_READ, want: unix.SECCOMP_RET_ALLOW},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := vm.Run(seccompData(seccompAuditArch, tt.nr, tt.arg0))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, uint32(result))
+		})
+	}
+}
+
+func TestSeccompFilterWithoutSignalPIDAllowsSignals(t *testing.T) {
+	vm := newSeccompVM(t, true, 0)
+
+	for _, nr := range []uint32{unix.SYS_KILL, unix.SYS_TKILL, unix.SYS_TGKILL, unix.SYS_PIDFD_SEND_SIGNAL} {
+		result, err := vm.Run(seccompData(seccompAuditArch, nr, 1))
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(unix.SECCOMP_RET_ALLOW), uint32(result))
+	}
+}
+
+func TestSeccompFilterFileContainsProgram(t *testing.T) {
+	filter, err := newSeccompFilter(true, 0)
+	assert.NoError(t, err)
+
+	file, err := seccompFilterFile(filter)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	program, err := io.ReadAll(file)
+	assert.NoError(t, err)
+	assert.Len(t, program, 8*len(filter))
+	assert.Equal(t, filter[0].Op, binary.NativeEndian.Uint16(program[0:2]))
+}
+
+func TestSandboxArgsHardenBwrap(t *testing.T) {
+	runner := WeasyprintRunner{BwrapPath: "bwrap", WeasyprintPath: "weasyprint"}
+
+	joinedArgs := strings.Join(runner.sandboxArgs("/tmp/work"), " ")
+
+	assert.Contains(t, joinedArgs, "--unshare-user --uid 65534 --gid 65534")
+	assert.Contains(t, joinedArgs, "--die-with-parent")
+	assert.Contains(t, joinedArgs, "--cap-drop ALL")
+	assert.Contains(t, joinedArgs, "--seccomp 3")
+	assert.Contains(t, joinedArgs, "--tmpfs /tmp")
+}
+
+func newSeccompVM(t *testing.T, allowUnixSockets bool, signalPID int) *bpf.VM {
+	t.Helper()
+	filter, err := newSeccompFilter(allowUnixSockets, signalPID)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	instructions, ok := bpf.Disassemble(filter)
+	assert.True(t, ok)
+	vm, err := bpf.NewVM(instructions)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return vm
+}
+
+// seccompData builds a struct seccomp_data for the bpf VM, which loads words big-endian. arg0 is placed
+// where the filter reads the low 32 bits of the first argument.
+func seccompData(arch uint32, nr uint32, arg0 uint32) []byte {
+	data := make([]byte, 64)
+	binary.BigEndian.PutUint32(data[seccompDataNr:], nr)
+	binary.BigEndian.PutUint32(data[seccompDataArch:], arch)
+	binary.BigEndian.PutUint32(data[seccompDataArg0:], arg0)
+	return data
+}
@@ -3,66 +3,143 @@ package app
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/netip"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 type Config struct {
 	MaxRequestBytes int64
 	RequestTimeout  time.Duration
+	// MaxConcurrentRenders and MaxQueuedRenders size the render queue. They are read once by NewService.
+	MaxConcurrentRenders int
+	MaxQueuedRenders     int
+	// MinFreeWorkDirBytes is the free space the work directory needs for the service to report ready.
+	MinFreeWorkDirBytes int64
+	// UsageHeaders reports the resources of each render in Server-Timing and X-Render-* response headers.
+	UsageHeaders bool
+	// TrustedProxies are the load balancers and proxies whose Forwarded and X-Forwarded-For headers are
+	// believed when determining the client address.
+	TrustedProxies []netip.Prefix
+	// ForwardedHeader is the header the trusted proxies write: ForwardedHeaderXForwardedFor, the default, or
+	// ForwardedHeaderForwarded.
+	ForwardedHeader string
 }
 
 type TokenValidator interface {
-	Validate(ctx context.Context, token string) error
+	Validate(ctx context.Context, token string) (*Principal, error)
 }
 
+type RequestValidator interface {
+	ValidateRequest(r *http.Request, credentials string) (*Principal, error)
+}
+
+// PDFRunner renders a RenderRequest and writes the PDF to output. The result of a failed render may still
+// report the resources the render used.
 type PDFRunner interface {
-	GeneratePDF(ctx context.Context, workDir string, htmlFilename string, cssFilename string, attachmentFilenames []string, output io.Writer) error
+	GeneratePDF(ctx context.Context, request RenderRequest, output io.Writer) (RenderResult, error)
 }
 
 type Service struct {
-	validator TokenValidator
-	runner    PDFRunner
-	config    Config
-	obs       Observability
+	validator      TokenValidator
+	signedRequests RequestValidator
+	engines        *EngineRegistry
+	config         atomic.Pointer[Config]
+	obs            Observability
+	audit          AuditSink
+	shadow         *shadowRenderer
+	failures       *failureLog
+	fonts          FontLister
+	metrics        *serviceMetrics
+	queue          *renderQueue
+	draining       atomic.Bool
+
+	readinessChecks []readinessCheck
+}
+
+type ServiceOption func(*Service)
+
+// WithSignedRequests accepts HMAC signed requests (see HMACAuthScheme) in addition to bearer tokens.
+func WithSignedRequests(validator RequestValidator) ServiceOption {
+	return func(s *Service) {
+		s.signedRequests = validator
+	}
+}
+
+// WithAuditLog records every render request, successful or not, to sink.
+func WithAuditLog(sink AuditSink) ServiceOption {
+	return func(s *Service) {
+		s.audit = sink
+	}
 }
 
-func NewService(validator TokenValidator, runner PDFRunner, config Config, obs Observability) *Service {
-	return &Service{
+func NewService(validator TokenValidator, engines *EngineRegistry, config Config, obs Observability, opts ...ServiceOption) *Service {
+	s := &Service{
 		validator: validator,
-		runner:    runner,
-		config:    config,
+		engines:   engines,
 		obs:       obs,
+		metrics:   newServiceMetrics(obs.Meter()),
+		queue:     newRenderQueue(config.MaxConcurrentRenders, config.MaxQueuedRenders),
 	}
+	s.SetConfig(config)
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.readinessChecks = append(s.defaultReadinessChecks(), s.readinessChecks...)
+	return s
 }
 
-func (s *Service) Routes() http.Handler {
-	mux := http.NewServeMux()
-	s.addRoute(mux, "GET /healthcheck", http.HandlerFunc(s.healthcheck))
-	s.addRoute(mux, "POST /pdf", s.requireAuth(http.HandlerFunc(s.renderPDF)))
-	return mux
+// SetConfig replaces the service limits. Requests already in progress keep the values they started with.
+func (s *Service) SetConfig(config Config) {
+	s.config.Store(&config)
+}
+
+func (s *Service) currentConfig() Config {
+	return *s.config.Load()
+}
+
+// Drain makes the readiness check fail so load balancers stop sending new requests. Requests are still served.
+func (s *Service) Drain() {
+	s.draining.Store(true)
+}
+
+// Shutdown drains and cancels queued renders and shadow renders, and waits until the cancelled shadow renders
+// cleaned up, or ctx is done. Renders that already hold a sandbox keep running; the HTTP server's Shutdown
+// waits for them.
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.Drain()
+	s.queue.close()
+	if s.shadow != nil {
+		return s.shadow.close(ctx)
+	}
+	return nil
 }
 
-func (s *Service) healthcheck(w http.ResponseWriter, _ *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte("OK"))
+func (s *Service) Routes() http.Handler {
+	mux := http.NewServeMux()
+	s.addRoute(mux, "GET /livez", http.HandlerFunc(s.livez))
+	s.addRoute(mux, "GET /readyz", http.HandlerFunc(s.readyz))
+	// Deprecated: kept for deployments that still probe the old path.
+	s.addRoute(mux, "GET /healthcheck", http.HandlerFunc(s.livez))
+	s.addRoute(mux, "POST /pdf", s.auditRender(s.requireAuth(http.HandlerFunc(s.renderPDF))))
+	s.addRoute(mux, "POST /pdf/validate", s.requireAuth(http.HandlerFunc(s.validatePDF)))
+	s.addRoute(mux, "GET /engines", s.requireAuth(http.HandlerFunc(s.listEngines)))
+	return withRequestID(s.logAccess(mux))
 }
 
 func (s *Service) requireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
-		token, err := parseBearerToken(r.Header.Get("Authorization"))
-		if err != nil {
-			writeHTTPError(ctx, s.obs.Logger(), w, r, NewUnauthorizedError("Unauthorized", err))
-			return
-		}
-
-		err = s.validator.Validate(r.Context(), token)
+		principal, err := s.authenticate(r)
 		if err != nil {
+			s.metrics.recordAuthFailure(ctx, authFailureReason(err))
 			if errors.Is(err, ErrForbidden) {
 				writeHTTPError(ctx, s.obs.Logger(), w, r, NewForbiddenError("Forbidden", err))
 				return
@@ -71,62 +148,122 @@ func (s *Service) requireAuth(next http.Handler) http.Handler {
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		ctx = withPrincipal(ctx, principal)
+		if entry := accessEntryFromContext(ctx); entry != nil {
+			entry.principal = principal
+		}
+		if body, ok := r.Body.(*signedBody); ok {
+			ctx = withSignedBody(ctx, body)
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func (s *Service) renderPDF(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	if r.Method != http.MethodPost {
-		writeHTTPError(ctx, s.obs.Logger(), w, r, NewMethodNotAllowedError("Method not allowed", nil))
-		return
+func (s *Service) authenticate(r *http.Request) (*Principal, error) {
+	scheme, credentials, err := parseAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return nil, err
 	}
 
-	contentType := r.Header.Get("Content-Type")
-	mediaType, _, err := mime.ParseMediaType(contentType)
-	if err != nil || mediaType != "multipart/form-data" {
-		writeHTTPError(ctx, s.obs.Logger(), w, r, NewBadRequestError("Multipart request required.", err))
-		return
+	switch {
+	case strings.EqualFold(scheme, "Bearer"):
+		return s.validator.Validate(r.Context(), credentials)
+	case strings.EqualFold(scheme, HMACAuthScheme) && s.signedRequests != nil:
+		return s.signedRequests.ValidateRequest(r, credentials)
+	default:
+		return nil, errInvalidAuthorizationScheme
 	}
+}
 
-	r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxRequestBytes)
-	reader, err := r.MultipartReader()
+func (s *Service) renderPDF(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	engine, reader, err := s.openRenderRequest(w, r)
 	if err != nil {
-		writeHTTPError(ctx, s.obs.Logger(), w, r, NewBadRequestError("Multipart request required.", err))
+		writeHTTPError(ctx, s.obs.Logger(), w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/pdf")
 	w.Header().Set("Content-Disposition", `attachment; filename="output.pdf"`)
+	w.Header().Set("X-Render-Engine", engine.Name)
+	if engine.Version != "" {
+		w.Header().Set("X-Render-Engine-Version", engine.Version)
+	}
 
-	err = s.generatePDFToWriter(r.Context(), reader, w)
+	start := time.Now()
+	summary, err := s.generatePDFToWriter(r.Context(), engine, reader, w)
+	s.accountRequest(ctx, summary)
+	s.accountRender(ctx, summary, err)
+	s.recordFailure(ctx, start, summary, err)
+	if audit := renderAuditFromContext(ctx); audit != nil {
+		audit.principal = PrincipalFromContext(ctx)
+		audit.summary = summary
+		audit.err = err
+	}
 	if err != nil {
 		writeHTTPError(ctx, s.obs.Logger(), w, r, err)
 		return
 	}
 }
 
-func parseBearerToken(headerValue string) (string, error) {
+// openRenderRequest checks the method and content type of a render payload, limits its size and returns the
+// engine it selects and a reader for its parts.
+func (s *Service) openRenderRequest(w http.ResponseWriter, r *http.Request) (Engine, *multipart.Reader, error) {
+	if r.Method != http.MethodPost {
+		return Engine{}, nil, NewMethodNotAllowedError("Method not allowed", nil)
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		return Engine{}, nil, NewBadRequestError("Multipart request required.", err)
+	}
+
+	engine, ok := s.engines.Lookup(r.URL.Query().Get(EngineQueryParameter))
+	if !ok {
+		return Engine{}, nil, NewBadRequestError("Unknown engine.", nil)
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.currentConfig().MaxRequestBytes)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return Engine{}, nil, NewBadRequestError("Multipart request required.", err)
+	}
+	return engine, reader, nil
+}
+
+var (
+	errMissingAuthorization       = errors.New("missing authorization")
+	errInvalidAuthorizationScheme = errors.New("invalid authorization scheme")
+)
+
+func parseAuthorization(headerValue string) (string, string, error) {
 	if headerValue == "" {
-		return "", errors.New("missing authorization")
+		return "", "", errMissingAuthorization
 	}
-	parts := strings.SplitN(headerValue, " ", 2)
-	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
-		return "", errors.New("invalid authorization scheme")
+	scheme, credentials, ok := strings.Cut(headerValue, " ")
+	if !ok {
+		return "", "", errInvalidAuthorizationScheme
 	}
-	token := strings.TrimSpace(parts[1])
-	if token == "" {
-		return "", errors.New("missing token")
+	credentials = strings.TrimSpace(credentials)
+	if credentials == "" {
+		return "", "", fmt.Errorf("%w: missing credentials", errMissingAuthorization)
 	}
-	return token, nil
+	return scheme, credentials, nil
 }
 
 type WeasyprintRunner struct {
 	BwrapPath             string
 	WeasyprintPath        string
 	DefaultStylesheetPath string
+	Limits                ResourceLimits
+	// Reloadable, when set, replaces DefaultStylesheetPath and Limits at the start of every render.
+	Reloadable *ReloadableSettings
+	// Cgroups, when set, enforces Limits.MemoryBytes and Limits.CPUQuota with a cgroup per render.
+	Cgroups *CgroupManager
 }
 
 func (s *Service) addRoute(mux *http.ServeMux, pattern string, handler http.Handler) {
-	mux.Handle(pattern, s.obs.HttpHandler(pattern, handler))
+	mux.Handle(pattern, s.obs.HttpHandler(pattern, tagRequestSpan(handler)))
 }
@@ -63,13 +63,8 @@ func TestRenderPDFBadContentType(t *testing.T) {
 
 func TestRenderPDFRequiresHTML(t *testing.T) {
 	svc := newTestService(fakeValidator{}, &fakeRunner{})
-	body, contentType := newMultipartBody(t, map[string]string{"css": "body{color:red;}"}, nil)
-	req := httptest.NewRequest(http.MethodPost, "/pdf", body)
-	req.Header.Set("Authorization", "Bearer test-token")
-	req.Header.Set("Content-Type", contentType)
-	rec := httptest.NewRecorder()
 
-	svc.Routes().ServeHTTP(rec, req)
+	rec := postMultipart(t, svc, "/pdf", []testPart{{field: "css", filename: "css.txt", content: "body{color:red;}"}})
 
 	assert.Equal(t, http.StatusBadRequest, rec.Code)
 	assert.Contains(t, rec.Body.String(), "No html file provided")
@@ -79,137 +74,182 @@ func TestRenderPDFSuccess(t *testing.T) {
 	runner := &fakeRunner{output: []byte("%PDF-1.4")}
 	svc := newTestService(fakeValidator{}, runner)
 
-	body, contentType := newMultipartBody(t,
-		map[string]string{
-			"html":       "<html><body><h1>Hello</h1><img src=\"logo.png\"></body></html>",
-			"css":        "body{font-size:12pt;}",
-			"asset.logo": "binary-image-content",
-		},
-		map[string]string{"attachment.test": "notes.txt"},
-	)
-
-	req := httptest.NewRequest(http.MethodPost, "/pdf", body)
-	req.Header.Set("Authorization", "Bearer test-token")
-	req.Header.Set("Content-Type", contentType)
-	rec := httptest.NewRecorder()
-
-	svc.Routes().ServeHTTP(rec, req)
+	rec := postMultipart(t, svc, "/pdf", []testPart{
+		{field: "html", filename: "html.txt", content: "<html><body><h1>Hello</h1><img src=\"logo.png\"></body></html>"},
+		{field: "css", filename: "css.txt", content: "body{font-size:12pt;}"},
+		{field: "asset.logo", filename: "asset.logo.txt", content: "binary-image-content"},
+		{field: "attachment.test", filename: "notes.txt", content: "test-file"},
+	})
 
 	assert.Equal(t, http.StatusOK, rec.Code, "body: %q", rec.Body.String())
 	assert.Equal(t, "application/pdf", rec.Header().Get("Content-Type"))
 	assert.True(t, bytes.Equal(rec.Body.Bytes(), []byte("%PDF-1.4")), "unexpected PDF body: %q", rec.Body.String())
-	assert.NotEmpty(t, runner.lastHTML)
-	assert.NotEmpty(t, runner.lastCSS)
-	assert.Len(t, runner.lastAttachments, 1)
+	assert.NotEmpty(t, runner.lastRequest.HTMLFilename)
+	assert.NotEmpty(t, runner.lastRequest.Stylesheets)
+	assert.Len(t, runner.lastRequest.Attachments, 1)
 }
 
 func TestRenderPDFSupportsFilePrefixAttachments(t *testing.T) {
 	runner := &fakeRunner{output: []byte("%PDF")}
 	svc := newTestService(fakeValidator{}, runner)
 
-	body, contentType := newMultipartBody(t,
-		map[string]string{"html": "<html><body>ok</body></html>"},
-		map[string]string{"file.terms": "terms.txt"},
-	)
-	req := httptest.NewRequest(http.MethodPost, "/pdf", body)
-	req.Header.Set("Authorization", "Bearer test-token")
-	req.Header.Set("Content-Type", contentType)
-	rec := httptest.NewRecorder()
-
-	svc.Routes().ServeHTTP(rec, req)
+	rec := postMultipart(t, svc, "/pdf", []testPart{testHTMLPart, {field: "file.terms", filename: "terms.txt", content: "test-file"}})
 
 	assert.Equal(t, http.StatusOK, rec.Code)
-	assert.Len(t, runner.lastAttachments, 1)
+	assert.Len(t, runner.lastRequest.Attachments, 1)
 }
 
 func TestRenderPDFFailedRunnerReturns500(t *testing.T) {
 	runner := &fakeRunner{runErr: errors.New("boom")}
 	svc := newTestService(fakeValidator{}, runner)
-	body, contentType := newMultipartBody(t, map[string]string{"html": "<html></html>"}, nil)
-	req := httptest.NewRequest(http.MethodPost, "/pdf", body)
-	req.Header.Set("Authorization", "Bearer test-token")
-	req.Header.Set("Content-Type", contentType)
-	rec := httptest.NewRecorder()
 
-	svc.Routes().ServeHTTP(rec, req)
+	rec := postMultipart(t, svc, "/pdf", []testPart{testHTMLPart})
 
 	assert.Equal(t, http.StatusInternalServerError, rec.Code)
 }
 
-func newTestService(validator TokenValidator, runner PDFRunner) *Service {
+// newTestService creates a service with the default test config and runner as its only engine, "weasyprint".
+// Options such as withTestEngines and withTestConfig change the rest; runner may be nil with withTestEngines.
+func newTestService(validator TokenValidator, runner PDFRunner, opts ...ServiceOption) *Service {
+	var engines *EngineRegistry
+	if runner != nil {
+		engines = newTestEngines(Engine{Name: "weasyprint", Runner: runner})
+	}
 	return NewService(
 		validator,
-		runner,
+		engines,
 		Config{
 			MaxRequestBytes: 5 * 1024 * 1024,
 			RequestTimeout:  3 * time.Second,
 		},
 		NewMockObservabilityProvider(),
+		opts...,
 	)
 }
 
-func newMultipartBody(t *testing.T, fields map[string]string, files map[string]string) (*bytes.Buffer, string) {
-	t.Helper()
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+// withTestEngines replaces the engines of a test service, with the first as the default.
+func withTestEngines(engines ...Engine) ServiceOption {
+	return func(s *Service) {
+		s.engines = newTestEngines(engines...)
+	}
+}
 
-	for name, value := range fields {
-		fileWriter, err := writer.CreateFormFile(name, name+".txt")
-		if err != nil {
-			t.Fatalf("failed to create form file %s: %v", name, err)
-		}
-		if _, err := io.Copy(fileWriter, strings.NewReader(value)); err != nil {
-			t.Fatalf("failed to write part %s: %v", name, err)
-		}
+// withTestConfig changes the config of a test service, including the settings NewService only reads once.
+func withTestConfig(change func(*Config)) ServiceOption {
+	return func(s *Service) {
+		config := s.currentConfig()
+		change(&config)
+		s.SetConfig(config)
+		s.queue = newRenderQueue(config.MaxConcurrentRenders, config.MaxQueuedRenders)
 	}
+}
 
-	for name, filename := range files {
-		fileWriter, err := writer.CreateFormFile(name, filename)
-		if err != nil {
-			t.Fatalf("failed to create file part %s: %v", name, err)
-		}
-		if _, err := io.Copy(fileWriter, strings.NewReader("test-file")); err != nil {
-			t.Fatalf("failed to write file part %s: %v", name, err)
-		}
+// withTestObservability replaces the observability of a test service. It must come before options that use it.
+func withTestObservability(obs Observability) ServiceOption {
+	return func(s *Service) {
+		s.obs = obs
+		s.metrics = newServiceMetrics(obs.Meter())
 	}
+}
 
-	if err := writer.Close(); err != nil {
-		t.Fatalf("failed to close multipart writer: %v", err)
+// newTestEngines registers engines with the first as the default.
+func newTestEngines(engines ...Engine) *EngineRegistry {
+	registry, err := NewEngineRegistry(engines[0].Name, engines...)
+	if err != nil {
+		panic(err)
 	}
+	return registry
+}
 
-	return body, writer.FormDataContentType()
+func defaultEngine(svc *Service) Engine {
+	engine, _ := svc.engines.Lookup("")
+	return engine
+}
+
+// testPart is a part of a multipart request body.
+type testPart struct {
+	field, filename, content string
+}
+
+// testHTMLPart is the HTML of a render request that only needs to succeed.
+var testHTMLPart = testPart{field: "html", filename: "html.txt", content: "<html><body>ok</body></html>"}
+
+// newMultipartRequest builds a POST of parts to path with a bearer token.
+func newMultipartRequest(t *testing.T, path string, parts []testPart) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, part := range parts {
+		fileWriter, err := writer.CreateFormFile(part.field, part.filename)
+		assert.NoError(t, err)
+		_, err = fileWriter.Write([]byte(part.content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.Close())
+
+	req := httptest.NewRequest(http.MethodPost, path, body)
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// postMultipart serves a request built by newMultipartRequest.
+func postMultipart(t *testing.T, svc *Service, path string, parts []testPart) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	svc.Routes().ServeHTTP(rec, newMultipartRequest(t, path, parts))
+	return rec
 }
 
 type fakeValidator struct {
 	err error
 }
 
-func (f fakeValidator) Validate(_ context.Context, _ string) error {
+func (f fakeValidator) Validate(_ context.Context, _ string) (*Principal, error) {
 	if f.err != nil {
-		return f.err
+		return nil, f.err
 	}
-	return nil
+	return &Principal{Subject: "test-subject", ClientID: "test-client", Scopes: []string{"pdf#create"}, Method: AuthMethodBearer}, nil
 }
 
 type fakeRunner struct {
-	output          []byte
-	runErr          error
-	lastHTML        string
-	lastCSS         string
-	lastAttachments []string
+	output      []byte
+	result      RenderResult
+	runErr      error
+	lastRequest RenderRequest
 }
 
-func (f *fakeRunner) GeneratePDF(_ context.Context, workDir string, htmlFilename string, cssFilename string, attachmentFilenames []string, output io.Writer) error {
-	f.lastHTML = htmlFilename
-	f.lastCSS = cssFilename
-	f.lastAttachments = attachmentFilenames
+func (f *fakeRunner) GeneratePDF(_ context.Context, request RenderRequest, output io.Writer) (RenderResult, error) {
+	f.lastRequest = request
 	if f.runErr != nil {
-		return f.runErr
+		return f.result, f.runErr
 	}
 	content := f.output
 	if len(content) == 0 {
 		content = []byte("%PDF")
 	}
 	_, err := output.Write(content)
-	return err
+	return f.result, err
+}
+
+func TestSetConfigAppliesToNewRequests(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{})
+	svc.SetConfig(Config{MaxRequestBytes: 16, RequestTimeout: time.Second})
+
+	rec := postMultipart(t, svc, "/pdf", []testPart{{field: "html", filename: "html.txt", content: "<html><body>too large</body></html>"}})
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
+
+func TestShutdownFailsReadinessAndRejectsRenders(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{})
+	assert.NoError(t, svc.Shutdown(context.Background()))
+
+	healthRec := httptest.NewRecorder()
+	svc.Routes().ServeHTTP(healthRec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	renderRec := postMultipart(t, svc, "/pdf", []testPart{testHTMLPart})
+
+	assert.Equal(t, http.StatusServiceUnavailable, healthRec.Code)
+	assert.Equal(t, http.StatusServiceUnavailable, renderRec.Code)
 }
@@ -0,0 +1,442 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Outcomes of a shadow render, recorded as the outcome attribute of pdf.shadow.comparisons.
+const (
+	ShadowOutcomeMatch   = "match"
+	ShadowOutcomeDiffers = "differs"
+	ShadowOutcomeFailed  = "failed"
+	// ShadowOutcomeSkipped is a sampled request that was not rendered because every shadow slot or render
+	// slot was busy, a request needed its render slot, or the candidate engine does not support the request.
+	ShadowOutcomeSkipped = "skipped"
+)
+
+// errShadowPreempted cancels a shadow render whose render slot a request is waiting for.
+var errShadowPreempted = errors.New("render slot needed by a request")
+
+// Fields compared between the primary and the candidate PDF.
+const (
+	ShadowFieldPageCount   = "page_count"
+	ShadowFieldPageSize    = "page_size"
+	ShadowFieldText        = "text"
+	ShadowFieldPageBreaks  = "page_breaks"
+	ShadowFieldOutputBytes = "output_bytes"
+)
+
+const (
+	// shadowPageSizeTolerance absorbs rounding of page dimensions, in points.
+	shadowPageSizeTolerance = 0.5
+	// shadowOutputSizeTolerance is the relative difference in output size that is reported.
+	shadowOutputSizeTolerance = 0.1
+	// shadowExcerptRunes is the length of the text excerpts in a report.
+	shadowExcerptRunes = 120
+)
+
+// ShadowConfig renders a sample of requests a second time on a candidate engine and compares the results,
+// so that pagination or layout changes of a new renderer version show up before it becomes the default.
+type ShadowConfig struct {
+	// Engine is the candidate engine. Requests that already selected it are not shadowed.
+	Engine string
+	// SampleRate is the fraction of successful renders that are repeated on Engine, between 0 and 1.
+	SampleRate float64
+	// MaxConcurrent bounds the shadow renders in flight. A shadow render also takes a slot of the render
+	// queue, so it counts against Config.MaxConcurrentRenders, but only a slot that is free while no request
+	// waits, and it is cancelled as soon as a request has to wait. A sampled request is skipped while all
+	// shadow slots are busy or no render slot is free, so shadowing never delays a request.
+	MaxConcurrent int
+	// ReportDir receives a ShadowReport for every comparison that differs or fails. When empty the
+	// differences are only logged and counted.
+	ReportDir string
+}
+
+// WithShadowRendering repeats a sample of renders on a candidate engine in the background.
+func WithShadowRendering(config ShadowConfig) ServiceOption {
+	return func(s *Service) {
+		s.shadow = newShadowRenderer(config, s.obs, s.metrics)
+	}
+}
+
+// ShadowReport is written to ShadowConfig.ReportDir for a comparison that differs or fails.
+type ShadowReport struct {
+	Time        time.Time          `json:"time"`
+	RequestID   string             `json:"request_id,omitempty"`
+	Inputs      []AuditInput       `json:"inputs"`
+	Primary     ShadowOutput       `json:"primary"`
+	Candidate   ShadowOutput       `json:"candidate"`
+	Outcome     string             `json:"outcome"`
+	Differences []ShadowDifference `json:"differences,omitempty"`
+}
+
+// ShadowOutput describes the PDF one engine produced.
+type ShadowOutput struct {
+	Engine    string     `json:"engine"`
+	Version   string     `json:"version,omitempty"`
+	Bytes     int64      `json:"bytes"`
+	PageCount int        `json:"page_count"`
+	PageSizes []PageSize `json:"page_sizes,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	text      []string
+}
+
+// ShadowDifference is one way the candidate PDF differs from the primary PDF.
+type ShadowDifference struct {
+	Field string `json:"field"`
+	// Page is the 1-based page the difference starts on, when it is tied to a page.
+	Page      int    `json:"page,omitempty"`
+	Primary   string `json:"primary"`
+	Candidate string `json:"candidate"`
+}
+
+type shadowRenderer struct {
+	config      ShadowConfig
+	obs         Observability
+	metrics     *serviceMetrics
+	slots       chan struct{}
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+	comparisons metric.Int64Counter
+	differences metric.Int64Counter
+}
+
+func newShadowRenderer(config ShadowConfig, obs Observability, metrics *serviceMetrics) *shadowRenderer {
+	ctx, cancel := context.WithCancel(context.Background())
+	meter := obs.Meter()
+	comparisons, _ := meter.Int64Counter("pdf.shadow.comparisons",
+		metric.WithDescription("Sampled renders repeated on the shadow candidate engine, by outcome."))
+	differences, _ := meter.Int64Counter("pdf.shadow.differences",
+		metric.WithDescription("Differences between primary and shadow candidate PDFs, by compared field."))
+	return &shadowRenderer{
+		config:      config,
+		obs:         obs,
+		metrics:     metrics,
+		slots:       make(chan struct{}, max(config.MaxConcurrent, 1)),
+		ctx:         ctx,
+		cancel:      cancel,
+		comparisons: comparisons,
+		differences: differences,
+	}
+}
+
+// shadowJob is a finished render, moved out of the request so it outlives it.
+type shadowJob struct {
+	requestID string
+	dir       string
+	request   RenderRequest
+	primary   ShadowOutput
+	candidate Engine
+	parts     []PartInfo
+	timeout   time.Duration
+}
+
+// shadowRender samples a successful render and, when it is picked, takes over the workspace at workDir and the
+// primary PDF and renders them on the candidate engine in the background.
+func (s *Service) shadowRender(ctx context.Context, primary Engine, pp *PartProcessor, workDir string, output *os.File, summary *renderSummary) {
+	r := s.shadow
+	if r == nil || primary.Name == r.config.Engine || rand.Float64() >= r.config.SampleRate || r.ctx.Err() != nil {
+		return
+	}
+	candidate, ok := s.engines.Lookup(r.config.Engine)
+	if !ok {
+		return
+	}
+	outcome := metric.WithAttributes(attribute.String("engine", primary.Name), attribute.String("candidate", candidate.Name),
+		attribute.String("outcome", ShadowOutcomeSkipped))
+	if _, unsupported := unsupportedCapability(candidate, pp.parts, pp.renderRequest("")); unsupported {
+		r.comparisons.Add(r.ctx, 1, outcome)
+		return
+	}
+	select {
+	case r.slots <- struct{}{}:
+	default:
+		r.comparisons.Add(r.ctx, 1, outcome)
+		return
+	}
+	// The request still holds its own render slot, so the shadow render needs another one. It gives the slot
+	// up as soon as a request has to wait for one.
+	jobCtx, cancel := context.WithCancelCause(contextWithRequestID(r.ctx, RequestIDFromContext(ctx)))
+	release, ok := s.queue.tryAcquireBackground(func() { cancel(errShadowPreempted) })
+	if !ok {
+		cancel(nil)
+		<-r.slots
+		r.comparisons.Add(r.ctx, 1, outcome)
+		return
+	}
+
+	job, err := claimShadowJob(workDir, output, candidate, pp)
+	if err != nil {
+		release()
+		cancel(nil)
+		<-r.slots
+		s.obs.Logger().WarnContext(ctx, "failed to prepare shadow render", "engine", primary.Name, "candidate", candidate.Name, "cause", err)
+		return
+	}
+	job.requestID = RequestIDFromContext(ctx)
+	job.primary = ShadowOutput{Engine: primary.Name, Version: primary.Version, Bytes: summary.OutputBytes}
+	job.parts = summary.Parts
+	job.timeout = s.currentConfig().RequestTimeout
+
+	r.wg.Go(func() {
+		defer func() { <-r.slots }()
+		defer release()
+		defer cancel(nil)
+		defer func() { _ = os.RemoveAll(job.dir) }()
+		r.run(jobCtx, job)
+	})
+}
+
+// claimShadowJob moves the workspace at workDir and the primary PDF into a directory of the shadow render, so
+// the request does not wait for a copy. Both are in the temporary directory, so they are renamed. The request
+// then finds nothing left to remove.
+func claimShadowJob(workDir string, output *os.File, candidate Engine, pp *PartProcessor) (job shadowJob, err error) {
+	job = shadowJob{candidate: candidate}
+	job.dir, err = os.MkdirTemp("", "pdf-shadow-*")
+	if err != nil {
+		return job, err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(job.dir)
+		}
+	}()
+
+	job.request = pp.renderRequest(filepath.Join(job.dir, "workspace"))
+	if err := os.Rename(workDir, job.request.WorkDir); err != nil {
+		return job, err
+	}
+	if err := os.Rename(output.Name(), filepath.Join(job.dir, "primary.pdf")); err != nil {
+		return job, err
+	}
+	return job, nil
+}
+
+// close cancels shadow renders in flight, stops new ones from starting and waits until the renders in flight
+// removed their workspace, or ctx is done.
+func (r *shadowRenderer) close(ctx context.Context) error {
+	r.cancel()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for shadow renders: %w", ctx.Err())
+	}
+}
+
+// run renders job on the candidate engine. ctx carries the ID of the request: the shadow render outlives the
+// request, but is logged and forwarded to render nodes under its ID.
+func (r *shadowRenderer) run(ctx context.Context, job shadowJob) {
+	ctx, cancel := context.WithTimeout(ctx, job.timeout)
+	defer cancel()
+	logger := r.obs.Logger().With("engine", job.primary.Engine, "candidate", job.candidate.Name)
+
+	report := ShadowReport{
+		Time:      time.Now().UTC(),
+		RequestID: job.requestID,
+		Primary:   job.primary,
+		Candidate: ShadowOutput{Engine: job.candidate.Name, Version: job.candidate.Version},
+	}
+	for _, part := range job.parts {
+		report.Inputs = append(report.Inputs, AuditInput(part))
+	}
+
+	var err error
+	report.Primary, err = describeShadowOutput(report.Primary, filepath.Join(job.dir, "primary.pdf"))
+	if err == nil {
+		report.Candidate, err = r.renderCandidate(ctx, job, report.Candidate)
+	}
+	attributes := []attribute.KeyValue{attribute.String("engine", report.Primary.Engine), attribute.String("candidate", report.Candidate.Engine)}
+	if err != nil {
+		if r.ctx.Err() != nil {
+			return
+		}
+		if errors.Is(context.Cause(ctx), errShadowPreempted) {
+			r.comparisons.Add(r.ctx, 1, metric.WithAttributes(append(attributes, attribute.String("outcome", ShadowOutcomeSkipped))...))
+			logger.DebugContext(ctx, "shadow render gave up its render slot to a request")
+			return
+		}
+		report.Outcome = ShadowOutcomeFailed
+		logger.WarnContext(ctx, "shadow render failed", "cause", err)
+	} else {
+		report.Differences = compareShadowOutputs(report.Primary, report.Candidate)
+		report.Outcome = ShadowOutcomeMatch
+		if len(report.Differences) > 0 {
+			report.Outcome = ShadowOutcomeDiffers
+		}
+	}
+
+	r.comparisons.Add(ctx, 1, metric.WithAttributes(append(attributes, attribute.String("outcome", report.Outcome))...))
+	for _, difference := range report.Differences {
+		r.differences.Add(ctx, 1, metric.WithAttributes(append(attributes, attribute.String("field", difference.Field))...))
+	}
+	if report.Outcome == ShadowOutcomeMatch {
+		logger.DebugContext(ctx, "shadow render matches")
+		return
+	}
+
+	path, err := r.writeReport(report)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to write shadow report", "cause", err)
+		return
+	}
+	if report.Outcome == ShadowOutcomeDiffers {
+		logger.WarnContext(ctx, "shadow render differs", "differences", report.Differences, "report", path)
+	}
+}
+
+func (r *shadowRenderer) renderCandidate(ctx context.Context, job shadowJob, candidate ShadowOutput) (ShadowOutput, error) {
+	path := filepath.Join(job.dir, "candidate.pdf")
+	output, err := os.Create(path)
+	if err != nil {
+		return candidate, err
+	}
+	stopSandbox := r.metrics.startSandbox(ctx, job.candidate.Name)
+	_, err = job.candidate.Runner.GeneratePDF(ctx, job.request, output)
+	stopSandbox()
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		candidate.Error = err.Error()
+		return candidate, fmt.Errorf("candidate render failed: %w", err)
+	}
+	return describeShadowOutput(candidate, path)
+}
+
+// describeShadowOutput fills in the size, pages and text of the PDF at path.
+func describeShadowOutput(output ShadowOutput, path string) (ShadowOutput, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return output, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return output, err
+	}
+	output.Bytes = stat.Size()
+
+	info, err := inspectPDF(file, output.Bytes)
+	if err == nil {
+		output.PageCount = info.PageCount
+		output.PageSizes = info.PageSizes
+		output.text, err = extractPDFText(file, output.Bytes)
+	}
+	if err != nil {
+		output.Error = err.Error()
+		return output, fmt.Errorf("failed to inspect %s pdf: %w", output.Engine, err)
+	}
+	return output, nil
+}
+
+// compareShadowOutputs lists how candidate differs from primary. Text that only moved to another page is
+// reported as a page break difference rather than a text difference.
+func compareShadowOutputs(primary ShadowOutput, candidate ShadowOutput) []ShadowDifference {
+	var differences []ShadowDifference
+	if primary.PageCount != candidate.PageCount {
+		differences = append(differences, ShadowDifference{
+			Field:     ShadowFieldPageCount,
+			Primary:   strconv.Itoa(primary.PageCount),
+			Candidate: strconv.Itoa(candidate.PageCount),
+		})
+	}
+
+	for i := range min(len(primary.PageSizes), len(candidate.PageSizes)) {
+		p, c := primary.PageSizes[i], candidate.PageSizes[i]
+		if math.Abs(p.Width-c.Width) > shadowPageSizeTolerance || math.Abs(p.Height-c.Height) > shadowPageSizeTolerance {
+			differences = append(differences, ShadowDifference{
+				Field:     ShadowFieldPageSize,
+				Page:      i + 1,
+				Primary:   formatPageSize(p),
+				Candidate: formatPageSize(c),
+			})
+			break
+		}
+	}
+
+	primaryText, candidateText := joinPageText(primary.text), joinPageText(candidate.text)
+	if primaryText != candidateText {
+		p, c := textExcerpts(primaryText, candidateText)
+		differences = append(differences, ShadowDifference{Field: ShadowFieldText, Primary: p, Candidate: c})
+	} else {
+		for i := range min(len(primary.text), len(candidate.text)) {
+			if primary.text[i] != candidate.text[i] {
+				p, c := textExcerpts(primary.text[i], candidate.text[i])
+				differences = append(differences, ShadowDifference{Field: ShadowFieldPageBreaks, Page: i + 1, Primary: p, Candidate: c})
+				break
+			}
+		}
+	}
+
+	if primary.Bytes > 0 && math.Abs(float64(candidate.Bytes-primary.Bytes))/float64(primary.Bytes) > shadowOutputSizeTolerance {
+		differences = append(differences, ShadowDifference{
+			Field:     ShadowFieldOutputBytes,
+			Primary:   strconv.FormatInt(primary.Bytes, 10),
+			Candidate: strconv.FormatInt(candidate.Bytes, 10),
+		})
+	}
+	return differences
+}
+
+func formatPageSize(size PageSize) string {
+	return fmt.Sprintf("%gx%g", size.Width, size.Height)
+}
+
+func joinPageText(pages []string) string {
+	return strings.Join(slices.DeleteFunc(slices.Clone(pages), func(page string) bool { return page == "" }), " ")
+}
+
+// textExcerpts returns the parts of a and b starting shortly before the first rune where they differ.
+func textExcerpts(a string, b string) (string, string) {
+	ra, rb := []rune(a), []rune(b)
+	start := 0
+	for start < len(ra) && start < len(rb) && ra[start] == rb[start] {
+		start++
+	}
+	start = max(start-shadowExcerptRunes/4, 0)
+	excerpt := func(r []rune) string {
+		if start >= len(r) {
+			return ""
+		}
+		return string(r[start:min(start+shadowExcerptRunes, len(r))])
+	}
+	return excerpt(ra), excerpt(rb)
+}
+
+func (r *shadowRenderer) writeReport(report ShadowReport) (string, error) {
+	if r.config.ReportDir == "" {
+		return "", nil
+	}
+	if err := os.MkdirAll(r.config.ReportDir, 0o750); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s-%s-%08x.json", report.Time.Format("20060102T150405Z"), report.Candidate.Engine, rand.Uint32())
+	path := filepath.Join(r.config.ReportDir, name)
+	return path, os.WriteFile(path, data, 0o600)
+}
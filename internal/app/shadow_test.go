@@ -0,0 +1,210 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var a4 = PageSize{Width: 595, Height: 842}
+
+func TestShadowRenderingReportsDifferences(t *testing.T) {
+	reportDir := t.TempDir()
+	candidate := &fakeRunner{output: buildTestPDFPages(a4, "Invoice 1001 Total due")}
+	svc := newShadowTestService(
+		ShadowConfig{Engine: "weasyprint-next", SampleRate: 1, MaxConcurrent: 1, ReportDir: reportDir},
+		&fakeRunner{output: buildTestPDFPages(a4, "Invoice 1001", "Total due")},
+		candidate,
+	)
+
+	rec := postMultipart(t, svc, "/pdf", []testPart{testHTMLPart})
+	svc.shadow.wg.Wait()
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "html.txt", candidate.lastRequest.HTMLFilename)
+	reports, err := filepath.Glob(filepath.Join(reportDir, "*-weasyprint-next-*.json"))
+	assert.NoError(t, err)
+	if assert.Len(t, reports, 1) {
+		data, err := os.ReadFile(reports[0])
+		assert.NoError(t, err)
+		var report ShadowReport
+		assert.NoError(t, json.Unmarshal(data, &report))
+		assert.Equal(t, ShadowOutcomeDiffers, report.Outcome)
+		assert.Equal(t, "weasyprint", report.Primary.Engine)
+		assert.Equal(t, 2, report.Primary.PageCount)
+		assert.Equal(t, 1, report.Candidate.PageCount)
+		assert.Equal(t, []ShadowDifference{
+			{Field: ShadowFieldPageCount, Primary: "2", Candidate: "1"},
+			{Field: ShadowFieldPageBreaks, Page: 1, Primary: "Invoice 1001", Candidate: "Invoice 1001 Total due"},
+			{Field: ShadowFieldOutputBytes, Primary: "849", Candidate: "597"},
+		}, report.Differences)
+		assert.Equal(t, "html", report.Inputs[0].FormName)
+	}
+}
+
+func TestShadowRenderingSkipsMatchesAndCandidateRequests(t *testing.T) {
+	reportDir := t.TempDir()
+	document := buildTestPDFPages(a4, "Invoice 1001")
+	candidate := &fakeRunner{output: document}
+	svc := newShadowTestService(
+		ShadowConfig{Engine: "weasyprint-next", SampleRate: 1, MaxConcurrent: 1, ReportDir: reportDir},
+		&fakeRunner{output: document},
+		candidate,
+	)
+
+	rec := postMultipart(t, svc, "/pdf", []testPart{testHTMLPart})
+	svc.shadow.wg.Wait()
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "html.txt", candidate.lastRequest.HTMLFilename)
+
+	rec = postMultipart(t, svc, "/pdf?engine=weasyprint-next", []testPart{testHTMLPart})
+	svc.shadow.wg.Wait()
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	entries, err := os.ReadDir(reportDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestShadowRenderingReportsCandidateFailures(t *testing.T) {
+	reportDir := t.TempDir()
+	svc := newShadowTestService(
+		ShadowConfig{Engine: "weasyprint-next", SampleRate: 1, MaxConcurrent: 1, ReportDir: reportDir},
+		&fakeRunner{output: buildTestPDFPages(a4, "Invoice 1001")},
+		&fakeRunner{runErr: assert.AnError},
+	)
+
+	rec := postMultipart(t, svc, "/pdf", []testPart{testHTMLPart})
+	svc.shadow.wg.Wait()
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	reports, _ := filepath.Glob(filepath.Join(reportDir, "*.json"))
+	if assert.Len(t, reports, 1) {
+		data, _ := os.ReadFile(reports[0])
+		var report ShadowReport
+		assert.NoError(t, json.Unmarshal(data, &report))
+		assert.Equal(t, ShadowOutcomeFailed, report.Outcome)
+		assert.Equal(t, assert.AnError.Error(), report.Candidate.Error)
+	}
+}
+
+func TestShadowRenderingNeedsAFreeRenderSlot(t *testing.T) {
+	candidate := &fakeRunner{output: buildTestPDFPages(a4, "Invoice 1001")}
+	svc := newShadowTestService(
+		ShadowConfig{Engine: "weasyprint-next", SampleRate: 1, MaxConcurrent: 1},
+		&fakeRunner{output: buildTestPDFPages(a4, "Invoice 1001")},
+		candidate,
+	)
+	release, err := svc.queue.acquire(context.Background())
+	assert.NoError(t, err)
+
+	rec := postMultipart(t, svc, "/pdf", []testPart{testHTMLPart})
+	svc.shadow.wg.Wait()
+	release()
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, candidate.lastRequest.HTMLFilename)
+	assert.Zero(t, svc.queue.stats().Active)
+}
+
+func TestShadowRenderingGivesUpItsSlotToRequests(t *testing.T) {
+	candidate := &blockingRunner{started: make(chan RenderRequest, 2)}
+	svc := newShadowTestService(
+		ShadowConfig{Engine: "weasyprint-next", SampleRate: 1, MaxConcurrent: 1},
+		&fakeRunner{output: buildTestPDFPages(a4, "Invoice 1001")},
+		candidate,
+		withTestConfig(func(config *Config) { config.MaxQueuedRenders = 0 }),
+	)
+	defer func() { assert.NoError(t, svc.Shutdown(context.Background())) }()
+
+	rec := postMultipart(t, svc, "/pdf", []testPart{testHTMLPart})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	shadowed := <-candidate.started
+
+	release, err := svc.queue.acquire(context.Background())
+	assert.NoError(t, err)
+	defer release()
+	rec = postMultipart(t, svc, "/pdf", []testPart{testHTMLPart})
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Dir(shadowed.WorkDir))
+		return os.IsNotExist(err)
+	}, time.Second, time.Millisecond)
+}
+
+func TestShadowCloseWaitsForRendersToCleanUp(t *testing.T) {
+	candidate := &blockingRunner{started: make(chan RenderRequest, 1)}
+	svc := newShadowTestService(
+		ShadowConfig{Engine: "weasyprint-next", SampleRate: 1, MaxConcurrent: 1},
+		&fakeRunner{output: buildTestPDFPages(a4, "Invoice 1001")},
+		candidate,
+	)
+
+	rec := postMultipart(t, svc, "/pdf", []testPart{testHTMLPart})
+	request := <-candidate.started
+	assert.NoError(t, svc.Shutdown(context.Background()))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoDirExists(t, filepath.Dir(request.WorkDir))
+}
+
+func TestCompareShadowOutputs(t *testing.T) {
+	primary := ShadowOutput{Bytes: 1000, PageCount: 2, PageSizes: []PageSize{a4, a4}, text: []string{"Dear customer,", "Regards"}}
+
+	tests := []struct {
+		name      string
+		candidate ShadowOutput
+		want      []ShadowDifference
+	}{
+		{
+			name:      "identical",
+			candidate: ShadowOutput{Bytes: 1050, PageCount: 2, PageSizes: []PageSize{a4, {Width: 595.3, Height: 841.9}}, text: []string{"Dear customer,", "Regards"}},
+		},
+		{
+			name:      "letter paper",
+			candidate: ShadowOutput{Bytes: 1000, PageCount: 2, PageSizes: []PageSize{{Width: 612, Height: 792}, {Width: 612, Height: 792}}, text: primary.text},
+			want:      []ShadowDifference{{Field: ShadowFieldPageSize, Page: 1, Primary: "595x842", Candidate: "612x792"}},
+		},
+		{
+			name:      "changed text and size",
+			candidate: ShadowOutput{Bytes: 2000, PageCount: 2, PageSizes: primary.PageSizes, text: []string{"Dear customer,", "Regard"}},
+			want: []ShadowDifference{
+				{Field: ShadowFieldText, Primary: "Dear customer, Regards", Candidate: "Dear customer, Regard"},
+				{Field: ShadowFieldOutputBytes, Primary: "1000", Candidate: "2000"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, compareShadowOutputs(primary, tt.candidate))
+		})
+	}
+}
+
+// newShadowTestService renders with primary and compares a sample with candidate, the engine config names.
+func newShadowTestService(config ShadowConfig, primary PDFRunner, candidate PDFRunner, opts ...ServiceOption) *Service {
+	return newTestService(fakeValidator{}, nil, append([]ServiceOption{
+		withTestEngines(Engine{Name: "weasyprint", Runner: primary}, Engine{Name: config.Engine, Runner: candidate}),
+		withTestConfig(func(config *Config) { config.MaxConcurrentRenders = 2 }),
+		WithShadowRendering(config),
+	}, opts...)...)
+}
+
+// blockingRunner reports the renders it starts and blocks them until they are cancelled.
+type blockingRunner struct {
+	started chan RenderRequest
+}
+
+func (r *blockingRunner) GeneratePDF(ctx context.Context, request RenderRequest, output io.Writer) (RenderResult, error) {
+	r.started <- request
+	<-ctx.Done()
+	return RenderResult{}, ctx.Err()
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRenderPDFTracesPhases(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	ctx, request := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test").Start(context.Background(), "POST /pdf")
+	runner := &fakeRunner{output: []byte("%PDF-1.7"), result: RenderResult{PageCount: 2}}
+	svc := newTestService(fakeValidator{}, nil, withTestEngines(Engine{Name: "weasyprint", Version: "66.0", Runner: runner}))
+
+	req := newMultipartRequest(t, "/pdf", []testPart{testHTMLPart, {field: "asset.logo", filename: "logo.png", content: "test-file"}})
+	rec := httptest.NewRecorder()
+	svc.Routes().ServeHTTP(rec, req.WithContext(ctx))
+	request.End()
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	for _, name := range []string{"pdf.workspace.setup", "pdf.multipart.parse", "pdf.queue.wait", "pdf.sandbox.run", "pdf.postprocess", "pdf.response.write"} {
+		if assert.Len(t, spans[name], 1, name) {
+			assert.Equal(t, request.SpanContext().SpanID(), spans[name][0].Parent().SpanID(), name)
+		}
+	}
+	parse := spans["pdf.multipart.parse"][0]
+	assert.Contains(t, parse.Attributes(), attribute.Int("pdf.parts.count", 2))
+	if assert.Len(t, spans["pdf.multipart.part"], 2) {
+		part := spans["pdf.multipart.part"][1]
+		assert.Equal(t, parse.SpanContext().SpanID(), part.Parent().SpanID())
+		assert.Contains(t, part.Attributes(), attribute.String("pdf.part.role", PartRoleAsset))
+		assert.Contains(t, part.Attributes(), attribute.Int64("pdf.part.size", int64(len("test-file"))))
+	}
+	assert.Contains(t, spans["pdf.sandbox.run"][0].Attributes(), attribute.String("pdf.engine.version", "66.0"))
+	assert.Contains(t, spans["pdf.postprocess"][0].Attributes(), attribute.Int("pdf.output.pages", 2))
+	assert.Contains(t, spans["pdf.response.write"][0].Attributes(), attribute.Int64("pdf.response.bytes", 8))
+}
@@ -0,0 +1,434 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	ValidationSeverityError   = "error"
+	ValidationSeverityWarning = "warning"
+)
+
+// Validation issue codes.
+const (
+	// ValidationMissingAsset is a reference to a file that was not uploaded.
+	ValidationMissingAsset = "missing_asset"
+	// ValidationOutsideWorkspace is a reference to a local file outside the uploaded files.
+	ValidationOutsideWorkspace = "outside_workspace"
+	// ValidationRemoteURL is a reference the sandbox will not fetch, because it has no network.
+	ValidationRemoteURL = "remote_url"
+	// ValidationInvalidURL is a reference that is not a valid URL.
+	ValidationInvalidURL = "invalid_url"
+	// ValidationUnknownFont is a font family that is neither installed nor declared with @font-face.
+	ValidationUnknownFont = "unknown_font"
+	// ValidationUnsupportedCapability is a part the selected engine does not support.
+	ValidationUnsupportedCapability = "unsupported_capability"
+)
+
+// ValidationReport is the result of POST /pdf/validate. The payload is valid when no issue is an error.
+type ValidationReport struct {
+	Valid  bool              `json:"valid"`
+	Engine string            `json:"engine"`
+	Inputs []AuditInput      `json:"inputs"`
+	Issues []ValidationIssue `json:"issues"`
+	// FontsChecked is false when the service cannot list the installed fonts.
+	FontsChecked bool `json:"fonts_checked"`
+}
+
+type ValidationIssue struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	// File is the uploaded file the reference is in.
+	File      string `json:"file,omitempty"`
+	Reference string `json:"reference,omitempty"`
+}
+
+// FontLister reports the font families available to the renderer.
+type FontLister interface {
+	FontFamilies(ctx context.Context) ([]string, error)
+}
+
+// WithFontLister lets POST /pdf/validate report font families that are not available to the renderer.
+func WithFontLister(fonts FontLister) ServiceOption {
+	return func(s *Service) {
+		s.fonts = fonts
+	}
+}
+
+// FontconfigLister lists the font families fontconfig knows, which are the fonts the sandbox binds. The
+// list is read once.
+type FontconfigLister struct {
+	// Path is the fc-list binary; empty means fc-list from PATH.
+	Path string
+
+	mu       sync.Mutex
+	families []string
+}
+
+func (l *FontconfigLister) FontFamilies(ctx context.Context) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.families != nil {
+		return l.families, nil
+	}
+	output, err := exec.CommandContext(ctx, cmp.Or(l.Path, "fc-list"), "--format", "%{family}\n").Output()
+	if err != nil {
+		return nil, fmt.Errorf("fc-list failed: %w", err)
+	}
+	families := []string{}
+	for line := range strings.Lines(string(output)) {
+		// A font lists all its family names, separated by commas.
+		for family := range strings.SplitSeq(line, ",") {
+			if family = strings.TrimSpace(family); family != "" {
+				families = append(families, family)
+			}
+		}
+	}
+	l.families = families
+	return families, nil
+}
+
+// validatePDF checks a render payload without rendering it and answers with a ValidationReport.
+func (s *Service) validatePDF(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	engine, reader, err := s.openRenderRequest(w, r)
+	if err != nil {
+		writeHTTPError(ctx, s.obs.Logger(), w, r, err)
+		return
+	}
+
+	report, err := s.validateParts(ctx, engine, reader)
+	if err != nil {
+		writeHTTPError(ctx, s.obs.Logger(), w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+func (s *Service) validateParts(ctx context.Context, engine Engine, reader *multipart.Reader) (*ValidationReport, error) {
+	workDir, err := os.MkdirTemp("", "pdf-validate-*")
+	if err != nil {
+		return nil, NewInternalError("Failed to process request.", err)
+	}
+	defer func() { _ = os.RemoveAll(workDir) }()
+
+	root, err := os.OpenRoot(workDir)
+	if err != nil {
+		return nil, NewInternalError("Failed to process request.", err)
+	}
+	defer root.Close()
+
+	pp := &PartProcessor{reader: reader, root: root}
+	if err := pp.ProcessParts(ctx); err != nil {
+		return nil, err
+	}
+	if err := verifyRequestBody(ctx); err != nil {
+		return nil, err
+	}
+
+	report := &ValidationReport{Engine: engine.Name, Inputs: []AuditInput{}, Issues: []ValidationIssue{}}
+	for _, part := range pp.parts {
+		report.Inputs = append(report.Inputs, AuditInput(part))
+	}
+	if capability, ok := unsupportedCapability(engine, pp.parts, pp.renderRequest("")); ok {
+		report.add(ValidationIssue{
+			Severity: ValidationSeverityError,
+			Code:     ValidationUnsupportedCapability,
+			Message:  fmt.Sprintf("Engine %q does not support %s.", engine.Name, capability),
+		})
+	}
+
+	v := &payloadValidator{root: root, report: report, checked: map[string]bool{}, bases: map[string]*url.URL{},
+		declaredFonts: map[string]bool{}}
+	v.checkHTML(pp.htmlFilename)
+	for _, stylesheet := range pp.stylesheets {
+		if stylesheet != defaultStylesheetPath {
+			v.checkStylesheet(stylesheet)
+		}
+	}
+	if s.fonts != nil {
+		families, err := s.fonts.FontFamilies(ctx)
+		if err != nil {
+			s.obs.Logger().WarnContext(ctx, "failed to list fonts", "cause", err)
+		} else {
+			report.FontsChecked = true
+			v.checkFonts(families)
+		}
+	}
+
+	report.Valid = !slices.ContainsFunc(report.Issues, func(issue ValidationIssue) bool {
+		return issue.Severity == ValidationSeverityError
+	})
+	return report, nil
+}
+
+func (r *ValidationReport) add(issue ValidationIssue) {
+	r.Issues = append(r.Issues, issue)
+}
+
+var (
+	cssCommentPattern    = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssImportPattern     = regexp.MustCompile(`@import\s+(?:url\(\s*)?(?:"([^"]*)"|'([^']*)'|([^\s'"()]+))`)
+	cssURLPattern        = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^\s'"()]*))\s*\)`)
+	cssFontFacePattern   = regexp.MustCompile(`(?is)@font-face\s*\{([^}]*)\}`)
+	cssFontFamilyPattern = regexp.MustCompile(`(?i)font-family\s*:\s*([^;}]+)`)
+)
+
+// genericFontFamilies are the CSS generic families and keywords that never need a font to be installed.
+var genericFontFamilies = []string{
+	"serif", "sans-serif", "monospace", "cursive", "fantasy", "system-ui", "emoji", "math", "fangsong",
+	"ui-serif", "ui-sans-serif", "ui-monospace", "ui-rounded", "inherit", "initial", "unset", "revert",
+	"revert-layer",
+}
+
+// payloadValidator follows the references of the uploaded HTML and stylesheets.
+type payloadValidator struct {
+	root   *os.Root
+	report *ValidationReport
+	// checked holds the stylesheets already read and the references already followed, the latter as file
+	// and reference separated by a NUL byte.
+	checked map[string]bool
+	// bases holds the <base href> of the HTML files that declare one, which their references resolve against.
+	bases         map[string]*url.URL
+	declaredFonts map[string]bool
+	usedFonts     []fontUse
+}
+
+// fontUse is a font family used in file.
+type fontUse struct {
+	file   string
+	family string
+}
+
+func (v *payloadValidator) checkHTML(filename string) {
+	file, err := v.root.Open(filename)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	document, err := html.Parse(file)
+	if err != nil {
+		return
+	}
+	v.checkBase(filename, document)
+
+	for node := range document.Descendants() {
+		if node.Type != html.ElementNode {
+			continue
+		}
+		if node.Data == "style" && node.FirstChild != nil && node.FirstChild.Type == html.TextNode {
+			v.checkCSS(filename, node.FirstChild.Data)
+		}
+		for _, attr := range node.Attr {
+			switch {
+			case attr.Key == "src", attr.Key == "poster", attr.Key == "data" && node.Data == "object":
+				v.checkReference(filename, attr.Val)
+			case attr.Key == "srcset":
+				for candidate := range strings.SplitSeq(attr.Val, ",") {
+					if fields := strings.Fields(candidate); len(fields) > 0 {
+						v.checkReference(filename, fields[0])
+					}
+				}
+			case attr.Key == "href" && node.Data == "link":
+				if resolved, ok := v.checkReference(filename, attr.Val); ok && isStylesheetLink(node) {
+					v.checkStylesheet(resolved)
+				}
+			case attr.Key == "style":
+				v.checkCSS(filename, attr.Val)
+			}
+		}
+	}
+}
+
+// checkBase records the first <base href> of the HTML document in filename, as the HTML specification does.
+func (v *payloadValidator) checkBase(filename string, document *html.Node) {
+	for node := range document.Descendants() {
+		if node.Type != html.ElementNode || node.Data != "base" {
+			continue
+		}
+		for _, attr := range node.Attr {
+			if attr.Key != "href" {
+				continue
+			}
+			base, err := url.Parse(strings.TrimSpace(attr.Val))
+			if err != nil {
+				v.report.add(ValidationIssue{Severity: ValidationSeverityError, Code: ValidationInvalidURL,
+					Message: "The base URL is not a valid URL.", File: filename, Reference: attr.Val})
+				return
+			}
+			v.bases[filename] = base
+			return
+		}
+	}
+}
+
+// resolveBase resolves a relative reference from filename against its <base href>, if it has one. A
+// relative base stays relative to filename.
+func (v *payloadValidator) resolveBase(filename string, u *url.URL) *url.URL {
+	base, ok := v.bases[filename]
+	if !ok || u.Scheme != "" || u.Host != "" || u.Path == "" || (path.IsAbs(u.Path) && base.Scheme == "" && base.Host == "") {
+		return u
+	}
+	if base.Scheme != "" || base.Host != "" {
+		return base.ResolveReference(u)
+	}
+	dir := base.Path
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir)
+	}
+	resolved := *u
+	resolved.Path = path.Join(dir, u.Path)
+	return &resolved
+}
+
+func isStylesheetLink(node *html.Node) bool {
+	for _, attr := range node.Attr {
+		if attr.Key == "rel" && slices.Contains(strings.Fields(strings.ToLower(attr.Val)), "stylesheet") {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *payloadValidator) checkStylesheet(filename string) {
+	if v.checked[filename] {
+		return
+	}
+	v.checked[filename] = true
+	data, err := v.root.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	v.checkCSS(filename, string(data))
+}
+
+// checkCSS checks the imports, URLs and font families of css, which is in or referenced from filename.
+func (v *payloadValidator) checkCSS(filename string, css string) {
+	css = cssCommentPattern.ReplaceAllString(css, "")
+
+	for _, match := range cssImportPattern.FindAllStringSubmatch(css, -1) {
+		if resolved, ok := v.checkReference(filename, match[1]+match[2]+match[3]); ok {
+			v.checkStylesheet(resolved)
+		}
+	}
+	for _, match := range cssURLPattern.FindAllStringSubmatch(css, -1) {
+		v.checkReference(filename, match[1]+match[2]+match[3])
+	}
+
+	for _, fontFace := range cssFontFacePattern.FindAllStringSubmatch(css, -1) {
+		for _, family := range cssFontFamilyPattern.FindAllStringSubmatch(fontFace[1], -1) {
+			for _, name := range parseFontFamilies(family[1]) {
+				v.declaredFonts[strings.ToLower(name)] = true
+			}
+		}
+	}
+	for _, family := range cssFontFamilyPattern.FindAllStringSubmatch(cssFontFacePattern.ReplaceAllString(css, ""), -1) {
+		for _, name := range parseFontFamilies(family[1]) {
+			v.usedFonts = append(v.usedFonts, fontUse{file: filename, family: name})
+		}
+	}
+}
+
+// parseFontFamilies returns the family names of a font-family value, without generic families.
+func parseFontFamilies(value string) []string {
+	value, _, _ = strings.Cut(value, "!")
+	var families []string
+	for family := range strings.SplitSeq(value, ",") {
+		family = strings.Trim(strings.TrimSpace(family), `"'`)
+		if family == "" || strings.Contains(family, "(") || slices.Contains(genericFontFamilies, strings.ToLower(family)) {
+			continue
+		}
+		families = append(families, family)
+	}
+	return families
+}
+
+// checkReference checks a reference from filename. It returns the workspace path of a reference to an
+// uploaded file.
+func (v *payloadValidator) checkReference(filename string, reference string) (string, bool) {
+	reference = strings.TrimSpace(reference)
+	key := filename + "\x00" + reference
+	if reference == "" || strings.HasPrefix(reference, "#") || v.checked[key] {
+		return "", false
+	}
+	v.checked[key] = true
+
+	issue := ValidationIssue{Severity: ValidationSeverityError, File: filename, Reference: reference}
+	u, err := url.Parse(reference)
+	if err == nil {
+		u = v.resolveBase(filename, u)
+	}
+	switch {
+	case err != nil:
+		issue.Code = ValidationInvalidURL
+		issue.Message = "The reference is not a valid URL."
+	case u.Scheme == "data":
+		return "", false
+	case u.Scheme == "file":
+		issue.Code = ValidationOutsideWorkspace
+		issue.Message = "file: URLs point outside the uploaded files; use a path relative to the file."
+	case u.Scheme != "" || u.Host != "":
+		issue.Severity = ValidationSeverityWarning
+		issue.Code = ValidationRemoteURL
+		issue.Message = "The renderer has no network access and will not load this URL; upload the file as a part instead."
+	case u.Path == "":
+		return "", false
+	case path.IsAbs(u.Path):
+		issue.Code = ValidationOutsideWorkspace
+		issue.Message = "Absolute paths point outside the uploaded files; use a path relative to the file."
+	default:
+		resolved := path.Join(path.Dir(filename), u.Path)
+		if resolved == ".." || strings.HasPrefix(resolved, "../") {
+			issue.Code = ValidationOutsideWorkspace
+			issue.Message = "The path points outside the uploaded files."
+			break
+		}
+		if _, err := v.root.Stat(resolved); err == nil {
+			return resolved, true
+		}
+		issue.Code = ValidationMissingAsset
+		issue.Message = fmt.Sprintf("No part with the file name %q was uploaded.", resolved)
+	}
+	v.report.add(issue)
+	return "", false
+}
+
+// checkFonts reports the used font families that are neither declared with @font-face nor in installed.
+func (v *payloadValidator) checkFonts(installed []string) {
+	available := map[string]bool{}
+	for _, family := range installed {
+		available[strings.ToLower(family)] = true
+	}
+	reported := map[string]bool{}
+	for _, use := range v.usedFonts {
+		family := strings.ToLower(use.family)
+		if v.declaredFonts[family] || available[family] || reported[family] {
+			continue
+		}
+		reported[family] = true
+		v.report.add(ValidationIssue{
+			Severity:  ValidationSeverityWarning,
+			Code:      ValidationUnknownFont,
+			Message:   fmt.Sprintf("Font family %q is not installed or declared with @font-face; another font will be used.", use.family),
+			File:      use.file,
+			Reference: use.family,
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const validateTestHTML = `<html>
+<head>
+  <link rel="stylesheet" href="theme.css">
+  <style>h1 { font-family: "Comic Neue", cursive; }</style>
+</head>
+<body>
+  <img src="logo.png">
+  <img src="images/missing.png">
+  <img src="https://cdn.example.com/banner.png">
+  <img src="data:image/png;base64,iVBORw0KGgo=">
+  <img src="/etc/passwd">
+  <img src="file:///etc/hostname">
+  <a href="https://example.com">not fetched</a>
+  <p style="background: url('../secret.png')">Total</p>
+</body>
+</html>`
+
+const validateTestCSS = `/* url(commented.png) */
+@import "print.css";
+@font-face { font-family: "Brand Sans"; src: url(fonts/brand.woff2); }
+body { font-family: "Brand Sans", "DejaVu Sans", sans-serif; }`
+
+func TestValidatePDFReportsIssues(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{}, WithFontLister(fakeFontLister{"DejaVu Sans", "DejaVu Serif"}))
+
+	rec := postMultipart(t, svc, "/pdf/validate", []testPart{
+		{field: "html", filename: "index.html", content: validateTestHTML},
+		{field: "asset.theme", filename: "theme.css", content: validateTestCSS},
+		{field: "asset.logo", filename: "logo.png", content: "png"},
+	})
+
+	assert.Equal(t, http.StatusOK, rec.Code, "body: %s", rec.Body.String())
+	var report ValidationReport
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.False(t, report.Valid)
+	assert.True(t, report.FontsChecked)
+	assert.Len(t, report.Inputs, 3)
+	type issue struct{ severity, code, file, reference string }
+	var issues []issue
+	for _, i := range report.Issues {
+		issues = append(issues, issue{i.Severity, i.Code, i.File, i.Reference})
+	}
+	assert.Equal(t, []issue{
+		{ValidationSeverityError, ValidationMissingAsset, "theme.css", "print.css"},
+		{ValidationSeverityError, ValidationMissingAsset, "theme.css", "fonts/brand.woff2"},
+		{ValidationSeverityError, ValidationMissingAsset, "index.html", "images/missing.png"},
+		{ValidationSeverityWarning, ValidationRemoteURL, "index.html", "https://cdn.example.com/banner.png"},
+		{ValidationSeverityError, ValidationOutsideWorkspace, "index.html", "/etc/passwd"},
+		{ValidationSeverityError, ValidationOutsideWorkspace, "index.html", "file:///etc/hostname"},
+		{ValidationSeverityError, ValidationOutsideWorkspace, "index.html", "../secret.png"},
+		{ValidationSeverityWarning, ValidationUnknownFont, "index.html", "Comic Neue"},
+	}, issues)
+}
+
+func TestValidatePDFResolvesAgainstBaseHref(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		code    string
+		message string
+	}{
+		{name: "relative", base: "assets/", code: ValidationMissingAsset, message: `No part with the file name "assets/logo.png" was uploaded.`},
+		{name: "remote", base: "https://cdn.example.com/", code: ValidationRemoteURL},
+		{name: "file", base: "file:///srv/", code: ValidationOutsideWorkspace},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService(fakeValidator{}, &fakeRunner{})
+
+			rec := postMultipart(t, svc, "/pdf/validate", []testPart{
+				{field: "html", filename: "index.html", content: `<head><base href="` + tt.base + `"></head><img src="logo.png">`},
+				{field: "asset.logo", filename: "logo.png", content: "png"},
+			})
+
+			assert.Equal(t, http.StatusOK, rec.Code, "body: %s", rec.Body.String())
+			var report ValidationReport
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+			if assert.Len(t, report.Issues, 1) {
+				assert.Equal(t, tt.code, report.Issues[0].Code)
+				assert.Equal(t, "logo.png", report.Issues[0].Reference)
+				if tt.message != "" {
+					assert.Equal(t, tt.message, report.Issues[0].Message)
+				}
+			}
+		})
+	}
+}
+
+func TestValidatePDFAcceptsCompletePayload(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{})
+
+	rec := postMultipart(t, svc, "/pdf/validate", []testPart{
+		{field: "html", filename: "index.html", content: `<img src="logo.png"><p style="font-family: Unlisted">ok</p>`},
+		{field: "css", filename: "style.css", content: `body { background: url("logo.png"); }`},
+		{field: "asset.logo", filename: "logo.png", content: "png"},
+	})
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var report ValidationReport
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.True(t, report.Valid)
+	assert.False(t, report.FontsChecked)
+	assert.Empty(t, report.Issues)
+}
+
+func TestValidatePDFReportsUnsupportedCapabilities(t *testing.T) {
+	svc := newTestService(fakeValidator{}, nil, withTestEngines(Engine{Name: "minimal", Capabilities: []string{}, Runner: &fakeRunner{}}))
+
+	rec := postMultipart(t, svc, "/pdf/validate", []testPart{
+		{field: "html", filename: "index.html", content: "<p>ok</p>"},
+		{field: "css", filename: "style.css", content: "body {}"},
+	})
+
+	var report ValidationReport
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.False(t, report.Valid)
+	if assert.Len(t, report.Issues, 1) {
+		assert.Equal(t, ValidationUnsupportedCapability, report.Issues[0].Code)
+	}
+}
+
+func TestValidatePDFRequiresHTML(t *testing.T) {
+	svc := newTestService(fakeValidator{}, &fakeRunner{})
+
+	rec := postMultipart(t, svc, "/pdf/validate", []testPart{{field: "css", filename: "style.css", content: "body {}"}})
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestFontconfigListerSplitsFamilies(t *testing.T) {
+	fcList := filepath.Join(t.TempDir(), "fc-list")
+	assert.NoError(t, os.WriteFile(fcList, []byte("#!/bin/sh\nprintf 'DejaVu Sans,DejaVu Sans Condensed\\nLiberation Serif\\n'\n"), 0o700))
+	lister := &FontconfigLister{Path: fcList}
+
+	families, err := lister.FontFamilies(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"DejaVu Sans", "DejaVu Sans Condensed", "Liberation Serif"}, families)
+}
+
+type fakeFontLister []string
+
+func (f fakeFontLister) FontFamilies(context.Context) ([]string, error) {
+	return f, nil
+}
@@ -0,0 +1,668 @@
+// Package config loads the service configuration from an optional YAML file and environment overrides.
+package config
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/bcc-code/pdf-service/internal/app"
+)
+
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Render    RenderConfig    `yaml:"render"`
+	Audit     AuditConfig     `yaml:"audit"`
+	Telemetry TelemetryConfig `yaml:"telemetry"`
+}
+
+type ServerConfig struct {
+	Port              int           `yaml:"port" env:"PORT"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	// WriteTimeout defaults to the render request timeout plus 15 seconds.
+	WriteTimeout time.Duration `yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+	// TLSCertFile and TLSKeyFile enable HTTPS. The certificate is reloaded on SIGHUP.
+	TLSCertFile string `yaml:"tls_cert_file" env:"TLS_CERT_FILE"`
+	TLSKeyFile  string `yaml:"tls_key_file" env:"TLS_KEY_FILE"`
+	// ShutdownDelay keeps serving with a failing health check before the listener closes, so load
+	// balancers stop routing new requests first.
+	ShutdownDelay time.Duration `yaml:"shutdown_delay" env:"SERVER_SHUTDOWN_DELAY"`
+	// ShutdownTimeout bounds how long in-flight renders may drain. It defaults to the render request timeout plus 10 seconds.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SERVER_SHUTDOWN_TIMEOUT"`
+	// AdminPort serves the operational endpoints, such as the Prometheus metrics, apart from the API. Zero
+	// disables the admin listener.
+	AdminPort int `yaml:"admin_port" env:"SERVER_ADMIN_PORT"`
+	// AdminAddress is the IP address the admin listener binds to. It defaults to loopback, since the
+	// endpoints have no authentication; clusters that scrape the metrics bind it to the pod address or
+	// 0.0.0.0 and restrict who can reach the port.
+	AdminAddress string `yaml:"admin_address" env:"SERVER_ADMIN_ADDRESS"`
+	// TrustedProxies lists the CIDR prefixes or addresses of the load balancers whose Forwarded and
+	// X-Forwarded-For headers give the client address. Without it the direct peer is the client.
+	TrustedProxies []string `yaml:"trusted_proxies" env:"SERVER_TRUSTED_PROXIES"`
+	// ForwardedHeader is the header the trusted proxies write the client address to, "x-forwarded-for" or
+	// "forwarded". The other one is ignored, since proxies pass it through from the client.
+	ForwardedHeader string `yaml:"forwarded_header" env:"SERVER_FORWARDED_HEADER"`
+}
+
+type AuthConfig struct {
+	Authority        string        `yaml:"authority" env:"AUTH_AUTHORITY"`
+	Audience         string        `yaml:"audience" env:"AUTH_AUDIENCE"`
+	TrustedIssuers   []string      `yaml:"trusted_issuers" env:"AUTH_TRUSTED_ISSUERS"`
+	RequiredScope    string        `yaml:"required_scope" env:"AUTH_REQUIRED_SCOPE"`
+	HMACKeysFile     string        `yaml:"hmac_keys_file" env:"AUTH_HMAC_KEYS_FILE"`
+	HMACMaxClockSkew time.Duration `yaml:"hmac_max_clock_skew" env:"AUTH_HMAC_MAX_CLOCK_SKEW"`
+}
+
+const (
+	RunnerBwrap    = "bwrap"
+	RunnerLandlock = "landlock"
+	RunnerRemote   = "remote"
+)
+
+type RenderConfig struct {
+	// EngineName names the engine built from the settings below.
+	EngineName string `yaml:"engine_name" env:"RENDER_ENGINE_NAME"`
+	// DefaultEngine renders requests that do not select an engine. It defaults to EngineName.
+	DefaultEngine string `yaml:"default_engine" env:"RENDER_DEFAULT_ENGINE"`
+	// Runner selects the sandbox: RunnerBwrap, RunnerLandlock for hosts without unprivileged user namespaces,
+	// or RunnerRemote to render on other instances.
+	Runner                string        `yaml:"runner" env:"RENDER_RUNNER"`
+	MaxRequestBytes       int64         `yaml:"max_request_bytes" env:"RENDER_MAX_REQUEST_BYTES"`
+	RequestTimeout        time.Duration `yaml:"request_timeout" env:"RENDER_REQUEST_TIMEOUT"`
+	BwrapPath             string        `yaml:"bwrap_path" env:"RENDER_BWRAP_PATH"`
+	WeasyprintPath        string        `yaml:"weasyprint_path" env:"RENDER_WEASYPRINT_PATH"`
+	DefaultStylesheetPath string        `yaml:"default_stylesheet_path" env:"RENDER_DEFAULT_STYLESHEET_PATH"`
+	MaxConcurrentRenders  int           `yaml:"max_concurrent_renders" env:"RENDER_MAX_CONCURRENT_RENDERS"`
+	MaxQueuedRenders      int           `yaml:"max_queued_renders" env:"RENDER_MAX_QUEUED_RENDERS"`
+	MinFreeWorkDirBytes   int64         `yaml:"min_free_work_dir_bytes" env:"RENDER_MIN_FREE_WORK_DIR_BYTES"`
+	// RequireSandboxSelfTest refuses to start when the sandbox isolation self-test fails.
+	RequireSandboxSelfTest bool `yaml:"require_sandbox_self_test" env:"RENDER_REQUIRE_SANDBOX_SELF_TEST"`
+	// UsageHeaders reports the CPU time, memory and wall time of each render in response headers.
+	UsageHeaders bool `yaml:"usage_headers" env:"RENDER_USAGE_HEADERS"`
+	// RecentFailures is how many failed renders are kept in memory for /debug/failures on server.admin_port.
+	RecentFailures int          `yaml:"recent_failures" env:"RENDER_RECENT_FAILURES"`
+	Limits         LimitsConfig `yaml:"limits"`
+	Pool           PoolConfig   `yaml:"pool"`
+	Remote         RemoteConfig `yaml:"remote"`
+	// Engines are additional engines requests can select, for example a newer WeasyPrint being rolled out.
+	Engines []EngineConfig `yaml:"engines"`
+	Shadow  ShadowConfig   `yaml:"shadow"`
+}
+
+// EngineConfig describes a render engine. Settings an additional engine leaves unset are taken from render.
+type EngineConfig struct {
+	Name                  string `yaml:"name"`
+	Runner                string `yaml:"runner"`
+	WeasyprintPath        string `yaml:"weasyprint_path"`
+	DefaultStylesheetPath string `yaml:"default_stylesheet_path"`
+	// Capabilities lists the request features the engine accepts; empty accepts all that its runner supports.
+	Capabilities []string     `yaml:"capabilities"`
+	Pool         PoolConfig   `yaml:"pool"`
+	Remote       RemoteConfig `yaml:"remote"`
+}
+
+// LimitsConfig bounds the resources of a single render. Zero disables a limit.
+type LimitsConfig struct {
+	MemoryBytes  int64         `yaml:"memory_bytes" env:"RENDER_LIMIT_MEMORY_BYTES"`
+	CPUTime      time.Duration `yaml:"cpu_time" env:"RENDER_LIMIT_CPU_TIME"`
+	CPUQuota     float64       `yaml:"cpu_quota" env:"RENDER_LIMIT_CPU_QUOTA"`
+	MaxProcesses int           `yaml:"max_processes" env:"RENDER_LIMIT_MAX_PROCESSES"`
+	MaxFileBytes int64         `yaml:"max_file_bytes" env:"RENDER_LIMIT_MAX_FILE_BYTES"`
+	// Cgroups enforces memory_bytes and cpu_quota with a cgroup per render when cgroup v2 delegation is available.
+	Cgroups bool `yaml:"cgroups" env:"RENDER_LIMIT_CGROUPS"`
+}
+
+// PoolConfig keeps persistent WeasyPrint workers instead of starting a sandbox per render. It needs the bwrap runner.
+type PoolConfig struct {
+	// Size is the number of workers; zero disables the pool.
+	Size                 int    `yaml:"size" env:"RENDER_POOL_SIZE"`
+	PythonPath           string `yaml:"python_path" env:"RENDER_POOL_PYTHON_PATH"`
+	MaxJobsPerWorker     int    `yaml:"max_jobs_per_worker" env:"RENDER_POOL_MAX_JOBS_PER_WORKER"`
+	MaxWorkerMemoryBytes int64  `yaml:"max_worker_memory_bytes" env:"RENDER_POOL_MAX_WORKER_MEMORY_BYTES"`
+}
+
+// RemoteConfig lists the render nodes used by the remote runner. Requests to them are signed with the
+// HMAC key HMACKeyID from HMACKeysFile, which the nodes must accept.
+type RemoteConfig struct {
+	Nodes          []string      `yaml:"nodes" env:"RENDER_REMOTE_NODES"`
+	HMACKeysFile   string        `yaml:"hmac_keys_file" env:"RENDER_REMOTE_HMAC_KEYS_FILE"`
+	HMACKeyID      string        `yaml:"hmac_key_id" env:"RENDER_REMOTE_HMAC_KEY_ID"`
+	HealthInterval time.Duration `yaml:"health_interval" env:"RENDER_REMOTE_HEALTH_INTERVAL"`
+}
+
+// ShadowConfig repeats a sample of renders on a candidate engine in the background and reports how its
+// output differs. An empty Engine disables shadow rendering.
+type ShadowConfig struct {
+	Engine        string  `yaml:"engine" env:"RENDER_SHADOW_ENGINE"`
+	SampleRate    float64 `yaml:"sample_rate" env:"RENDER_SHADOW_SAMPLE_RATE"`
+	MaxConcurrent int     `yaml:"max_concurrent" env:"RENDER_SHADOW_MAX_CONCURRENT"`
+	ReportDir     string  `yaml:"report_dir" env:"RENDER_SHADOW_REPORT_DIR"`
+}
+
+type AuditConfig struct {
+	Path     string `yaml:"path" env:"AUDIT_LOG_PATH"`
+	MaxBytes int64  `yaml:"max_bytes" env:"AUDIT_LOG_MAX_BYTES"`
+}
+
+type TelemetryConfig struct {
+	// ServiceName enables the OpenTelemetry provider when set.
+	ServiceName string `yaml:"service_name" env:"OTEL_SERVICE_NAME"`
+	// Exporter is where traces, metrics and logs go when ServiceName is set: app.TelemetryExporterOTLP,
+	// app.TelemetryExporterStdout or app.TelemetryExporterFile (File).
+	Exporter     string `yaml:"exporter" env:"TELEMETRY_EXPORTER"`
+	OTLPProtocol string `yaml:"otlp_protocol" env:"OTEL_EXPORTER_OTLP_PROTOCOL"`
+	File         string `yaml:"file" env:"TELEMETRY_FILE"`
+	// SampleRatio is the share of new traces that are sampled; traces started by a caller keep its decision.
+	SampleRatio float64 `yaml:"sample_ratio" env:"TELEMETRY_SAMPLE_RATIO"`
+	// UntracedPaths get no spans or HTTP metrics.
+	UntracedPaths []string      `yaml:"untraced_paths" env:"TELEMETRY_UNTRACED_PATHS"`
+	ExportTimeout time.Duration `yaml:"export_timeout" env:"TELEMETRY_EXPORT_TIMEOUT"`
+	// LogFormat is "text" or "json" for the local logs written to stderr when ServiceName is not set.
+	LogFormat string `yaml:"log_format" env:"LOG_FORMAT"`
+	// Prometheus serves the metrics at /metrics on server.admin_port, with or without the OTLP exporters.
+	Prometheus bool `yaml:"prometheus" env:"TELEMETRY_PROMETHEUS"`
+}
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:              8080,
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			AdminAddress:      "127.0.0.1",
+			ForwardedHeader:   app.ForwardedHeaderXForwardedFor,
+		},
+		Auth: AuthConfig{
+			RequiredScope:    "pdf#create",
+			HMACMaxClockSkew: 5 * time.Minute,
+		},
+		Render: RenderConfig{
+			EngineName:             "weasyprint",
+			Runner:                 RunnerBwrap,
+			MaxRequestBytes:        104857600,
+			RequestTimeout:         120 * time.Second,
+			BwrapPath:              "bwrap",
+			WeasyprintPath:         "weasyprint",
+			DefaultStylesheetPath:  "assets/default.css",
+			MaxConcurrentRenders:   runtime.NumCPU(),
+			MaxQueuedRenders:       4 * runtime.NumCPU(),
+			MinFreeWorkDirBytes:    1073741824,
+			RequireSandboxSelfTest: true,
+			RecentFailures:         50,
+			Limits: LimitsConfig{
+				MemoryBytes:  2147483648,
+				CPUTime:      120 * time.Second,
+				MaxFileBytes: 268435456,
+				Cgroups:      true,
+			},
+			Pool: PoolConfig{
+				PythonPath:           "python3",
+				MaxJobsPerWorker:     100,
+				MaxWorkerMemoryBytes: 1073741824,
+			},
+			Remote: RemoteConfig{
+				HealthInterval: 5 * time.Second,
+			},
+			Shadow: ShadowConfig{
+				SampleRate:    0.01,
+				MaxConcurrent: 1,
+			},
+		},
+		Audit: AuditConfig{
+			MaxBytes: 104857600,
+		},
+		Telemetry: TelemetryConfig{
+			Exporter:      app.TelemetryExporterOTLP,
+			OTLPProtocol:  app.OTLPProtocolGRPC,
+			SampleRatio:   1,
+			UntracedPaths: []string{"/healthcheck", "/livez", "/readyz"},
+			ExportTimeout: 10 * time.Second,
+			LogFormat:     LogFormatText,
+		},
+	}
+}
+
+// Load reads defaults, then the YAML file at path (if path is not empty), then environment overrides,
+// and validates the result.
+func Load(path string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(cfg).Elem(), lookupEnv); err != nil {
+		return nil, err
+	}
+
+	if cfg.Server.WriteTimeout == 0 {
+		cfg.Server.WriteTimeout = cfg.Render.RequestTimeout + 15*time.Second
+	}
+	if cfg.Server.ShutdownTimeout == 0 {
+		cfg.Server.ShutdownTimeout = cfg.Render.RequestTimeout + 10*time.Second
+	}
+	if cfg.Render.DefaultEngine == "" {
+		cfg.Render.DefaultEngine = cfg.Render.EngineName
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+	require := func(ok bool, field string, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	require(c.Server.Port > 0 && c.Server.Port < 65536, "server.port", "must be between 1 and 65535, got %d", c.Server.Port)
+	require(c.Server.ReadHeaderTimeout > 0, "server.read_header_timeout", "must be positive")
+	require(c.Server.ReadTimeout > 0, "server.read_timeout", "must be positive")
+	require(c.Server.WriteTimeout > c.Render.RequestTimeout, "server.write_timeout", "must be longer than render.request_timeout (%s)", c.Render.RequestTimeout)
+	require(c.Server.IdleTimeout > 0, "server.idle_timeout", "must be positive")
+	require(c.Server.ShutdownDelay >= 0, "server.shutdown_delay", "must not be negative")
+	require(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout", "must be positive")
+	require(c.Server.AdminPort >= 0 && c.Server.AdminPort < 65536, "server.admin_port", "must be between 0 and 65535, got %d", c.Server.AdminPort)
+	require(c.Server.AdminPort != c.Server.Port, "server.admin_port", "must differ from server.port")
+	_, err := netip.ParseAddr(c.Server.AdminAddress)
+	require(err == nil, "server.admin_address", "must be an IP address, got %q", c.Server.AdminAddress)
+	require(c.Server.ForwardedHeader == app.ForwardedHeaderXForwardedFor || c.Server.ForwardedHeader == app.ForwardedHeaderForwarded,
+		"server.forwarded_header", "must be %q or %q, got %q", app.ForwardedHeaderXForwardedFor, app.ForwardedHeaderForwarded, c.Server.ForwardedHeader)
+	for _, proxy := range c.Server.TrustedProxies {
+		_, err := app.ParseTrustedProxy(proxy)
+		require(err == nil, "server.trusted_proxies", "%q is not a CIDR prefix or an IP address", proxy)
+	}
+	require((c.Server.TLSCertFile == "") == (c.Server.TLSKeyFile == ""), "server.tls_cert_file", "must be set together with server.tls_key_file")
+
+	require(c.Auth.Authority != "", "auth.authority", "is required (set AUTH_AUTHORITY)")
+	require(c.Auth.Audience != "", "auth.audience", "is required (set AUTH_AUDIENCE)")
+	require(c.Auth.RequiredScope != "", "auth.required_scope", "is required")
+	require(c.Auth.HMACMaxClockSkew > 0, "auth.hmac_max_clock_skew", "must be positive")
+
+	require(c.Render.MaxRequestBytes > 0, "render.max_request_bytes", "must be positive")
+	require(c.Render.RequestTimeout > 0, "render.request_timeout", "must be positive")
+	require(c.Render.Runner == RunnerBwrap || c.Render.Runner == RunnerLandlock || c.Render.Runner == RunnerRemote,
+		"render.runner", "must be %q, %q or %q, got %q", RunnerBwrap, RunnerLandlock, RunnerRemote, c.Render.Runner)
+	require(c.Render.BwrapPath != "", "render.bwrap_path", "is required")
+	require(c.Render.WeasyprintPath != "", "render.weasyprint_path", "is required")
+	require(c.Render.DefaultStylesheetPath != "", "render.default_stylesheet_path", "is required")
+	require(c.Render.MaxConcurrentRenders > 0, "render.max_concurrent_renders", "must be positive")
+	require(c.Render.MaxQueuedRenders >= 0, "render.max_queued_renders", "must not be negative")
+	require(c.Render.Limits.MemoryBytes >= 0, "render.limits.memory_bytes", "must not be negative")
+	require(c.Render.Limits.CPUTime == 0 || c.Render.Limits.CPUTime >= time.Second, "render.limits.cpu_time", "must be at least 1s")
+	require(c.Render.Limits.CPUQuota >= 0, "render.limits.cpu_quota", "must not be negative")
+	require(c.Render.Limits.MaxProcesses >= 0, "render.limits.max_processes", "must not be negative")
+	require(c.Render.Limits.MaxFileBytes >= 0, "render.limits.max_file_bytes", "must not be negative")
+	require(c.Render.Pool.Size >= 0, "render.pool.size", "must not be negative")
+	require(c.Render.Pool.Size == 0 || c.Render.Runner == RunnerBwrap, "render.pool.size", "requires render.runner %q", RunnerBwrap)
+	require(c.Render.Pool.PythonPath != "", "render.pool.python_path", "is required")
+	require(c.Render.Pool.MaxJobsPerWorker >= 0, "render.pool.max_jobs_per_worker", "must not be negative")
+	require(c.Render.Pool.MaxWorkerMemoryBytes >= 0, "render.pool.max_worker_memory_bytes", "must not be negative")
+	if c.Render.Runner == RunnerRemote {
+		require(len(c.Render.Remote.Nodes) > 0, "render.remote.nodes", "is required for render.runner %q", RunnerRemote)
+		require(c.Render.Remote.HMACKeysFile != "", "render.remote.hmac_keys_file", "is required for render.runner %q", RunnerRemote)
+		require(c.Render.Remote.HMACKeyID != "", "render.remote.hmac_key_id", "is required for render.runner %q", RunnerRemote)
+	}
+	require(c.Render.Remote.HealthInterval > 0, "render.remote.health_interval", "must be positive")
+	require(c.Render.RecentFailures >= 0, "render.recent_failures", "must not be negative")
+	require(c.Render.MinFreeWorkDirBytes >= 0, "render.min_free_work_dir_bytes", "must not be negative")
+	require(c.Render.EngineName != "", "render.engine_name", "is required")
+	engineNames := map[string]bool{c.Render.EngineName: true}
+	for i, engine := range c.Engines()[1:] {
+		field := fmt.Sprintf("render.engines[%d]", i)
+		require(engine.Name != "", field+".name", "is required")
+		require(engine.Name == "" || !engineNames[engine.Name], field+".name", "%q is already used by another engine", engine.Name)
+		engineNames[engine.Name] = true
+		require(engine.Runner == RunnerBwrap || engine.Runner == RunnerLandlock || engine.Runner == RunnerRemote,
+			field+".runner", "must be %q, %q or %q, got %q", RunnerBwrap, RunnerLandlock, RunnerRemote, engine.Runner)
+		require(engine.Pool.Size >= 0, field+".pool.size", "must not be negative")
+		require(engine.Pool.Size == 0 || engine.Runner == RunnerBwrap, field+".pool.size", "requires runner %q", RunnerBwrap)
+		if engine.Runner == RunnerRemote {
+			require(len(engine.Remote.Nodes) > 0, field+".remote.nodes", "is required for runner %q", RunnerRemote)
+			require(engine.Remote.HMACKeysFile != "", field+".remote.hmac_keys_file", "is required for runner %q", RunnerRemote)
+			require(engine.Remote.HMACKeyID != "", field+".remote.hmac_key_id", "is required for runner %q", RunnerRemote)
+		}
+		for _, capability := range engine.Capabilities {
+			require(slices.Contains(app.AllCapabilities, capability), field+".capabilities", "unknown capability %q, must be one of %s", capability, strings.Join(app.AllCapabilities, ", "))
+		}
+	}
+	require(engineNames[c.Render.DefaultEngine], "render.default_engine", "%q is not a configured engine", c.Render.DefaultEngine)
+	require(c.Render.Shadow.Engine == "" || engineNames[c.Render.Shadow.Engine], "render.shadow.engine", "%q is not a configured engine", c.Render.Shadow.Engine)
+	require(c.Render.Shadow.SampleRate >= 0 && c.Render.Shadow.SampleRate <= 1, "render.shadow.sample_rate", "must be between 0 and 1")
+	require(c.Render.Shadow.MaxConcurrent > 0, "render.shadow.max_concurrent", "must be positive")
+
+	require(c.Audit.MaxBytes >= 0, "audit.max_bytes", "must not be negative")
+
+	require(c.Telemetry.Exporter == app.TelemetryExporterOTLP || c.Telemetry.Exporter == app.TelemetryExporterStdout || c.Telemetry.Exporter == app.TelemetryExporterFile,
+		"telemetry.exporter", "must be %q, %q or %q, got %q", app.TelemetryExporterOTLP, app.TelemetryExporterStdout, app.TelemetryExporterFile, c.Telemetry.Exporter)
+	require(c.Telemetry.OTLPProtocol == app.OTLPProtocolGRPC || c.Telemetry.OTLPProtocol == app.OTLPProtocolHTTP,
+		"telemetry.otlp_protocol", "must be %q or %q, got %q", app.OTLPProtocolGRPC, app.OTLPProtocolHTTP, c.Telemetry.OTLPProtocol)
+	require(c.Telemetry.Exporter != app.TelemetryExporterFile || c.Telemetry.File != "", "telemetry.file", "is required for telemetry.exporter %q", app.TelemetryExporterFile)
+	require(c.Telemetry.SampleRatio >= 0 && c.Telemetry.SampleRatio <= 1, "telemetry.sample_ratio", "must be between 0 and 1")
+	require(c.Telemetry.ExportTimeout > 0, "telemetry.export_timeout", "must be positive")
+	require(c.Telemetry.LogFormat == LogFormatText || c.Telemetry.LogFormat == LogFormatJSON,
+		"telemetry.log_format", "must be %q or %q, got %q", LogFormatText, LogFormatJSON, c.Telemetry.LogFormat)
+	require(!c.Telemetry.Prometheus || c.Server.AdminPort != 0, "telemetry.prometheus", "requires server.admin_port")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Issuers returns the authority followed by any additional trusted issuers.
+func (c *Config) Issuers() []string {
+	return append([]string{c.Auth.Authority}, c.Auth.TrustedIssuers...)
+}
+
+func (c *Config) TLSEnabled() bool {
+	return c.Server.TLSCertFile != ""
+}
+
+// RestartRequired lists settings that differ between old and new but are only read at startup.
+// Limits, default stylesheets, trusted issuers, HMAC keys and TLS certificate contents are applied on reload.
+func RestartRequired(old *Config, new *Config) []string {
+	var changed []string
+	check := func(name string, differs bool) {
+		if differs {
+			changed = append(changed, name)
+		}
+	}
+
+	check("server.port", old.Server.Port != new.Server.Port)
+	check("server.read_header_timeout", old.Server.ReadHeaderTimeout != new.Server.ReadHeaderTimeout)
+	check("server.read_timeout", old.Server.ReadTimeout != new.Server.ReadTimeout)
+	check("server.write_timeout", old.Server.WriteTimeout != new.Server.WriteTimeout)
+	check("server.idle_timeout", old.Server.IdleTimeout != new.Server.IdleTimeout)
+	check("server.shutdown_delay", old.Server.ShutdownDelay != new.Server.ShutdownDelay)
+	check("server.shutdown_timeout", old.Server.ShutdownTimeout != new.Server.ShutdownTimeout)
+	check("server.admin_port", old.Server.AdminPort != new.Server.AdminPort)
+	check("server.admin_address", old.Server.AdminAddress != new.Server.AdminAddress)
+	check("server.tls_cert_file", old.TLSEnabled() != new.TLSEnabled())
+	check("auth.audience", old.Auth.Audience != new.Auth.Audience)
+	check("auth.required_scope", old.Auth.RequiredScope != new.Auth.RequiredScope)
+	check("auth.hmac_max_clock_skew", old.Auth.HMACMaxClockSkew != new.Auth.HMACMaxClockSkew)
+	check("render.engine_name", old.Render.EngineName != new.Render.EngineName)
+	check("render.default_engine", old.Render.DefaultEngine != new.Render.DefaultEngine)
+	check("render.runner", old.Render.Runner != new.Render.Runner)
+	check("render.bwrap_path", old.Render.BwrapPath != new.Render.BwrapPath)
+	check("render.weasyprint_path", old.Render.WeasyprintPath != new.Render.WeasyprintPath)
+	check("render.max_concurrent_renders", old.Render.MaxConcurrentRenders != new.Render.MaxConcurrentRenders)
+	check("render.max_queued_renders", old.Render.MaxQueuedRenders != new.Render.MaxQueuedRenders)
+	check("render.recent_failures", old.Render.RecentFailures != new.Render.RecentFailures)
+	check("render.limits.cgroups", old.Render.Limits.Cgroups != new.Render.Limits.Cgroups)
+	check("render.pool", old.Render.Pool != new.Render.Pool)
+	check("render.remote", !reflect.DeepEqual(old.Render.Remote, new.Render.Remote))
+	check("render.engines", !reflect.DeepEqual(withoutStylesheets(old.Render.Engines), withoutStylesheets(new.Render.Engines)))
+	check("render.shadow", old.Render.Shadow != new.Render.Shadow)
+	check("render.require_sandbox_self_test", old.Render.RequireSandboxSelfTest != new.Render.RequireSandboxSelfTest)
+	check("audit", old.Audit != new.Audit)
+	check("telemetry", !reflect.DeepEqual(old.Telemetry, new.Telemetry))
+	return changed
+}
+
+// withoutStylesheets returns engines without their default stylesheets, which are applied on reload.
+func withoutStylesheets(engines []EngineConfig) []EngineConfig {
+	engines = slices.Clone(engines)
+	for i := range engines {
+		engines[i].DefaultStylesheetPath = ""
+	}
+	return engines
+}
+
+func (c *Config) ServiceConfig() app.Config {
+	return app.Config{
+		MaxRequestBytes:      c.Render.MaxRequestBytes,
+		RequestTimeout:       c.Render.RequestTimeout,
+		MaxConcurrentRenders: c.Render.MaxConcurrentRenders,
+		MaxQueuedRenders:     c.Render.MaxQueuedRenders,
+		MinFreeWorkDirBytes:  c.Render.MinFreeWorkDirBytes,
+		UsageHeaders:         c.Render.UsageHeaders,
+		TrustedProxies:       c.trustedProxies(),
+		ForwardedHeader:      c.Server.ForwardedHeader,
+	}
+}
+
+// Redacted returns a copy of c that is safe to show, with the passwords of URLs replaced. Keys are never
+// part of the configuration, only the paths of the files holding them.
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redacted.Auth.Authority = redactURL(c.Auth.Authority)
+	redacted.Auth.TrustedIssuers = redactURLs(c.Auth.TrustedIssuers)
+	redacted.Render.Remote.Nodes = redactURLs(c.Render.Remote.Nodes)
+	redacted.Render.Engines = slices.Clone(c.Render.Engines)
+	for i := range redacted.Render.Engines {
+		redacted.Render.Engines[i].Remote.Nodes = redactURLs(c.Render.Engines[i].Remote.Nodes)
+	}
+	return &redacted
+}
+
+func redactURLs(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	redacted := make([]string, len(values))
+	for i, value := range values {
+		redacted[i] = redactURL(value)
+	}
+	return redacted
+}
+
+func redactURL(value string) string {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.User == nil {
+		return value
+	}
+	return parsed.Redacted()
+}
+
+// trustedProxies parses server.trusted_proxies, which Validate has checked.
+func (c *Config) trustedProxies() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, proxy := range c.Server.TrustedProxies {
+		if prefix, err := app.ParseTrustedProxy(proxy); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+func (c *Config) ObservabilityConfig() app.ObservabilityConfig {
+	return app.ObservabilityConfig{
+		ServiceName:   c.Telemetry.ServiceName,
+		Exporter:      c.Telemetry.Exporter,
+		OTLPProtocol:  c.Telemetry.OTLPProtocol,
+		File:          c.Telemetry.File,
+		SampleRatio:   c.Telemetry.SampleRatio,
+		UntracedPaths: c.Telemetry.UntracedPaths,
+		ExportTimeout: c.Telemetry.ExportTimeout,
+	}
+}
+
+// Engines returns the engine built from the render settings followed by the additional engines, with
+// their unset settings filled in.
+func (c *Config) Engines() []EngineConfig {
+	engines := []EngineConfig{{
+		Name:                  c.Render.EngineName,
+		Runner:                c.Render.Runner,
+		WeasyprintPath:        c.Render.WeasyprintPath,
+		DefaultStylesheetPath: c.Render.DefaultStylesheetPath,
+		Pool:                  c.Render.Pool,
+		Remote:                c.Render.Remote,
+	}}
+	for _, engine := range c.Render.Engines {
+		engine.Runner = cmp.Or(engine.Runner, c.Render.Runner)
+		engine.WeasyprintPath = cmp.Or(engine.WeasyprintPath, c.Render.WeasyprintPath)
+		engine.DefaultStylesheetPath = cmp.Or(engine.DefaultStylesheetPath, c.Render.DefaultStylesheetPath)
+		engine.Pool.PythonPath = cmp.Or(engine.Pool.PythonPath, c.Render.Pool.PythonPath)
+		engine.Pool.MaxJobsPerWorker = cmp.Or(engine.Pool.MaxJobsPerWorker, c.Render.Pool.MaxJobsPerWorker)
+		engine.Pool.MaxWorkerMemoryBytes = cmp.Or(engine.Pool.MaxWorkerMemoryBytes, c.Render.Pool.MaxWorkerMemoryBytes)
+		if len(engine.Remote.Nodes) == 0 {
+			engine.Remote.Nodes = c.Render.Remote.Nodes
+		}
+		engine.Remote.HMACKeysFile = cmp.Or(engine.Remote.HMACKeysFile, c.Render.Remote.HMACKeysFile)
+		engine.Remote.HMACKeyID = cmp.Or(engine.Remote.HMACKeyID, c.Render.Remote.HMACKeyID)
+		engine.Remote.HealthInterval = cmp.Or(engine.Remote.HealthInterval, c.Render.Remote.HealthInterval)
+		engines = append(engines, engine)
+	}
+	return engines
+}
+
+func (c *Config) WeasyprintRunner(engine EngineConfig) app.WeasyprintRunner {
+	return app.WeasyprintRunner{
+		BwrapPath:             c.Render.BwrapPath,
+		WeasyprintPath:        engine.WeasyprintPath,
+		DefaultStylesheetPath: engine.DefaultStylesheetPath,
+		Limits:                c.resourceLimits(),
+	}
+}
+
+// RunnerSettings returns the settings of the local runner of engine that are applied on reload.
+func (c *Config) RunnerSettings(engine EngineConfig) app.RunnerSettings {
+	return app.RunnerSettings{
+		DefaultStylesheetPath: engine.DefaultStylesheetPath,
+		Limits:                c.resourceLimits(),
+	}
+}
+
+func (c *Config) PoolConfig(engine EngineConfig) app.PoolConfig {
+	return app.PoolConfig{
+		Size:                 engine.Pool.Size,
+		PythonPath:           engine.Pool.PythonPath,
+		MaxJobsPerWorker:     engine.Pool.MaxJobsPerWorker,
+		MaxWorkerMemoryBytes: engine.Pool.MaxWorkerMemoryBytes,
+	}
+}
+
+func (c *Config) LandlockRunner(engine EngineConfig) app.LandlockRunner {
+	return app.LandlockRunner{
+		WeasyprintPath:        engine.WeasyprintPath,
+		DefaultStylesheetPath: engine.DefaultStylesheetPath,
+		Limits:                c.resourceLimits(),
+	}
+}
+
+func (c *Config) ShadowConfig() app.ShadowConfig {
+	return app.ShadowConfig{
+		Engine:        c.Render.Shadow.Engine,
+		SampleRate:    c.Render.Shadow.SampleRate,
+		MaxConcurrent: c.Render.Shadow.MaxConcurrent,
+		ReportDir:     c.Render.Shadow.ReportDir,
+	}
+}
+
+func (c *Config) resourceLimits() app.ResourceLimits {
+	return app.ResourceLimits{
+		MemoryBytes:  c.Render.Limits.MemoryBytes,
+		CPUTime:      c.Render.Limits.CPUTime,
+		CPUQuota:     c.Render.Limits.CPUQuota,
+		MaxProcesses: c.Render.Limits.MaxProcesses,
+		MaxFileBytes: c.Render.Limits.MaxFileBytes,
+	}
+}
+
+// applyEnv overrides every field tagged with `env` whose variable is set to a non-empty value.
+func applyEnv(v reflect.Value, lookupEnv func(string) (string, bool)) error {
+	var errs []error
+	for i := range v.NumField() {
+		field := v.Field(i)
+		structField := v.Type().Field(i)
+
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, lookupEnv); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		name := structField.Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		raw, ok := lookupEnv(name)
+		raw = strings.TrimSpace(raw)
+		if !ok || raw == "" {
+			continue
+		}
+		if err := setFromString(field, raw); err != nil {
+			errs = append(errs, fmt.Errorf("environment variable %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func setFromString(field reflect.Value, raw string) error {
+	switch {
+	case field.Type() == reflect.TypeFor[time.Duration]():
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.String:
+		field.SetString(raw)
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case field.CanInt():
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case field.CanFloat():
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		var values []string
+		for value := range strings.SplitSeq(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}